@@ -21,7 +21,9 @@ package apis
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/saba-futai/sudoku/internal/tunnel"
 	"github.com/saba-futai/sudoku/pkg/obfs/sudoku"
 )
 
@@ -95,6 +97,14 @@ type ProtocolConfig struct {
 	// 设置过大可能使服务器容易受到慢速攻击
 	HandshakeTimeoutSeconds int
 
+	// MaxTimeSkewSeconds 握手时间戳允许的最大偏差（秒）(仅服务端使用)
+	// 0 表示使用默认值 60；窗口内的握手会被记录，原样重放的握手将被拒绝并进入回落
+	MaxTimeSkewSeconds int
+
+	// ReplayCacheSize 重放缓存最多记录的握手数 (仅服务端使用)
+	// 0 表示使用默认值 65536；应不小于 MaxTimeSkew 窗口内的握手数，否则最早的指纹会被挤出并记录日志
+	ReplayCacheSize int
+
 	// ============ 通用开关 ============
 
 	// DisableHTTPMask 是否禁用 HTTP 伪装层
@@ -147,6 +157,14 @@ func (c *ProtocolConfig) Validate() error {
 		return fmt.Errorf("HandshakeTimeoutSeconds must be >= 0, got %d", c.HandshakeTimeoutSeconds)
 	}
 
+	if c.MaxTimeSkewSeconds < 0 {
+		return fmt.Errorf("MaxTimeSkewSeconds must be >= 0, got %d", c.MaxTimeSkewSeconds)
+	}
+
+	if c.ReplayCacheSize < 0 {
+		return fmt.Errorf("ReplayCacheSize must be >= 0, got %d", c.ReplayCacheSize)
+	}
+
 	return nil
 }
 
@@ -176,6 +194,13 @@ func DefaultConfig() *ProtocolConfig {
 	}
 }
 
+func (c *ProtocolConfig) maxTimeSkew() time.Duration {
+	if c.MaxTimeSkewSeconds > 0 {
+		return time.Duration(c.MaxTimeSkewSeconds) * time.Second
+	}
+	return tunnel.DefaultMaxTimeSkew
+}
+
 func (c *ProtocolConfig) tableCandidates() []*sudoku.Table {
 	if c == nil {
 		return nil
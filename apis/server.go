@@ -30,11 +30,15 @@ import (
 	"time"
 
 	"github.com/saba-futai/sudoku/internal/protocol"
+	"github.com/saba-futai/sudoku/internal/tunnel"
 	"github.com/saba-futai/sudoku/pkg/crypto"
 	"github.com/saba-futai/sudoku/pkg/obfs/httpmask"
 	"github.com/saba-futai/sudoku/pkg/obfs/sudoku"
 )
 
+// replayCache 记录近期握手指纹，拒绝在时间窗口内被原样重放的握手；容量取各配置 ReplayCacheSize 的最大值
+var replayCache = tunnel.NewReplayCache(tunnel.DefaultReplayCacheSize)
+
 // bufferedConn 这是一个内部辅助结构，用于将 bufio 多读的数据传递给后续层
 // 必须实现 net.Conn
 type bufferedConn struct {
@@ -96,7 +100,7 @@ func probeHandshakeBytes(probe []byte, cfg *ProtocolConfig, table *sudoku.Table)
 	}
 	ts := int64(binary.BigEndian.Uint64(handshakeBuf[:8]))
 	now := time.Now().Unix()
-	if abs(now-ts) > 60 {
+	if abs(now-ts) > int64(cfg.maxTimeSkew()/time.Second) {
 		return fmt.Errorf("timestamp skew/replay detected: server_time=%d client_time=%d", now, ts)
 	}
 
@@ -283,7 +287,8 @@ func serverHandshakeCore(rawConn net.Conn, cfg *ProtocolConfig) (net.Conn, func(
 		}
 	}
 
-	cConn, err := crypto.NewAEADConn(obfsConn, cfg.Key, cfg.AEADMethod)
+	tap := tunnel.NewHandshakeTap(obfsConn)
+	cConn, err := crypto.NewAEADConn(tap, cfg.Key, cfg.AEADMethod)
 	if err != nil {
 		return nil, nil, fail(fmt.Errorf("crypto setup failed: %w", err))
 	}
@@ -294,12 +299,18 @@ func serverHandshakeCore(rawConn net.Conn, cfg *ProtocolConfig) (net.Conn, func(
 		return nil, nil, fail(fmt.Errorf("read handshake failed: %w", err))
 	}
 
+	skew := cfg.maxTimeSkew()
 	ts := int64(binary.BigEndian.Uint64(handshakeBuf[:8]))
 	now := time.Now().Unix()
-	if abs(now-ts) > 60 {
+	if abs(now-ts) > int64(skew/time.Second) {
 		cConn.Close()
 		return nil, nil, fail(fmt.Errorf("timestamp skew/replay detected: server_time=%d client_time=%d", now, ts))
 	}
+	replayCache.Reserve(cfg.ReplayCacheSize)
+	if !replayCache.Check(tap.Fingerprint(), time.Unix(ts, 0).Add(skew+time.Second)) {
+		cConn.Close()
+		return nil, nil, fail(fmt.Errorf("replayed handshake detected"))
+	}
 
 	sConn.StopRecording()
 
@@ -66,6 +66,7 @@ func main() {
 				log.Fatalf("Failed to split key: %v", err)
 			}
 			fmt.Printf("Split Private Key: %s\n", splitKey)
+			printKeyIdentity(splitKey)
 			return
 		}
 
@@ -82,6 +83,7 @@ func main() {
 			log.Fatalf("Failed to generate key: %v", err)
 		}
 		fmt.Printf("Available Private Key: %s\n", splitKey)
+		printKeyIdentity(splitKey)
 		fmt.Printf("Master Private Key: %s\n", crypto.EncodeScalar(pair.Private))
 		fmt.Printf("Master Public Key:  %s\n", crypto.EncodePoint(pair.Public))
 		return
@@ -96,7 +98,7 @@ func main() {
 		if err != nil {
 			log.Fatalf("Failed to build table: %v", err)
 		}
-		app.RunClient(cfg, tables)
+		runClient(cfg, tables)
 		return
 	}
 
@@ -146,12 +148,22 @@ func main() {
 	}
 
 	if cfg.Mode == "client" {
-		app.RunClient(cfg, tables)
+		runClient(cfg, tables)
 	} else {
 		app.RunServer(cfg, tables)
 	}
 }
 
+// runClient 创建并启动 pac 规则管理器后运行客户端
+func runClient(cfg *config.Config, tables []*sudoku.Table) {
+	geoMgr := app.NewGeoManager(cfg)
+	if geoMgr != nil {
+		geoMgr.Start()
+		defer geoMgr.Close()
+	}
+	app.RunClient(cfg, tables, geoMgr)
+}
+
 func buildTables(key string, ascii string, customTable string, customTables []string) ([]*sudoku.Table, error) {
 	patterns := customTables
 	if len(patterns) == 0 && strings.TrimSpace(customTable) != "" {
@@ -170,3 +182,12 @@ func buildTables(key string, ascii string, customTable string, customTables []st
 	}
 	return tables, nil
 }
+
+// printKeyIdentity prints the identity a server lists under "users" to authorize this key.
+func printKeyIdentity(splitKey string) {
+	identity, err := crypto.KeyIdentity(splitKey)
+	if err != nil {
+		log.Fatalf("Failed to derive key identity: %v", err)
+	}
+	fmt.Printf("Key Identity: %s\n", identity)
+}
@@ -0,0 +1,103 @@
+// internal/app/auth.go
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/saba-futai/sudoku/internal/config"
+)
+
+const (
+	socks5MethodNone         = 0x00
+	socks5MethodUserPass     = 0x02
+	socks5MethodNoAcceptable = 0xFF
+)
+
+// localAuthEnabled 本地入口是否要求用户名/密码
+func localAuthEnabled(cfg *config.Config) bool {
+	return cfg.LocalUsername != ""
+}
+
+func credentialsMatch(cfg *config.Config, user, pass string) bool {
+	// 两项都比较，避免按用户名是否正确产生时间差
+	u := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.LocalUsername))
+	p := subtle.ConstantTimeCompare([]byte(pass), []byte(cfg.LocalPassword))
+	return u&p == 1
+}
+
+// socks5Negotiate 完成方法协商与（需要时）RFC 1929 用户名/密码子协商；返回 false 时连接应关闭
+func socks5Negotiate(conn net.Conn, cfg *config.Config) bool {
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return false
+	}
+	nMethods := int(buf[1])
+	if _, err := io.ReadFull(conn, buf[:nMethods]); err != nil {
+		return false
+	}
+	if !localAuthEnabled(cfg) {
+		conn.Write([]byte{0x05, socks5MethodNone})
+		return true
+	}
+	if !containsByte(buf[:nMethods], socks5MethodUserPass) {
+		conn.Write([]byte{0x05, socks5MethodNoAcceptable})
+		return false
+	}
+	conn.Write([]byte{0x05, socks5MethodUserPass})
+
+	// VER(1) | ULEN(1) | UNAME | PLEN(1) | PASSWD
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 0x01 {
+		return false
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return false
+	}
+	pass := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return false
+	}
+	if !credentialsMatch(cfg, string(user), string(pass)) {
+		conn.Write([]byte{0x01, 0x01})
+		return false
+	}
+	conn.Write([]byte{0x01, 0x00})
+	return true
+}
+
+func containsByte(b []byte, c byte) bool {
+	for _, v := range b {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+// httpProxyAuthorized 校验 Proxy-Authorization: Basic
+func httpProxyAuthorized(req *http.Request, cfg *config.Config) bool {
+	if !localAuthEnabled(cfg) {
+		return true
+	}
+	scheme, encoded, ok := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	return ok && credentialsMatch(cfg, user, pass)
+}
+
+const httpProxyAuthRequired = "HTTP/1.1 407 Proxy Authentication Required\r\n" +
+	"Proxy-Authenticate: Basic realm=\"sudoku\"\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"
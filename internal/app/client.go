@@ -2,22 +2,26 @@
 package app
 
 import (
-	"bufio"
 	"bytes"
-	"context"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/dns"
 	"github.com/saba-futai/sudoku/internal/protocol"
+	"github.com/saba-futai/sudoku/internal/router"
 	"github.com/saba-futai/sudoku/internal/tunnel"
 	"github.com/saba-futai/sudoku/pkg/crypto"
 	"github.com/saba-futai/sudoku/pkg/geodata"
@@ -105,7 +109,21 @@ func buildTablesFromConfig(cfg *config.Config) ([]*sudoku.Table, error) {
 	return tableSet.Candidates(), nil
 }
 
-func RunClient(cfg *config.Config, tables []*sudoku.Table) {
+// NewGeoManager 为 pac 模式创建 rule_urls 规则管理器；其它模式不需要，返回 nil。
+// 调用方负责 Start/Close，并将其传给 RunClient
+func NewGeoManager(cfg *config.Config) *geodata.Manager {
+	if cfg.ProxyMode != "pac" {
+		return nil
+	}
+	return geodata.NewManager(geodata.Options{
+		URLs:           cfg.RuleURLs,
+		CacheDir:       cfg.RuleCacheDir,
+		UpdateInterval: time.Duration(cfg.RuleUpdateInterval) * time.Second,
+	})
+}
+
+// RunClient 运行本地混合代理。geoMgr 提供 pac 模式的 rule_urls 规则，由调用方启动；为 nil 时 pac 模式全部走代理
+func RunClient(cfg *config.Config, tables []*sudoku.Table, geoMgr *geodata.Manager) {
 	// 1. Initialize Dialer
 	var dialer tunnel.Dialer
 
@@ -125,44 +143,161 @@ func RunClient(cfg *config.Config, tables []*sudoku.Table) {
 		}
 	}
 
-	baseDialer := tunnel.BaseDialer{
-		Config:     cfg,
-		Tables:     tables,
-		PrivateKey: privateKeyBytes,
+	if len(cfg.Servers) > 0 {
+		dialer, err = newGroupDialer(cfg, cfg.Servers, tables, privateKeyBytes)
+		if err != nil {
+			log.Fatalf("Invalid load balancing: %v", err)
+		}
+	} else {
+		dialer = newServerDialer(cfg, tables, privateKeyBytes)
 	}
+	defer closeDialer(dialer)
 
-	dialer = &tunnel.StandardDialer{
-		BaseDialer: baseDialer,
+	// 2. 编译路由规则与命名出口
+	outbounds, err := buildOutbounds(cfg, tables, privateKeyBytes)
+	if err != nil {
+		log.Fatalf("Invalid outbounds: %v", err)
 	}
-
-	// 2. 初始化 GeoIP/PAC 管理器
-	var geoMgr *geodata.Manager
-	if cfg.ProxyMode == "pac" {
-		geoMgr = geodata.GetInstance(cfg.RuleURLs)
+	defer closeOutbounds(outbounds)
+	// 启用本地 DNS 时，IP 类规则也经同一分流路径解析，避免代理域名的查询泄露到本地网络
+	var splitResolver *splitDNS
+	var resolve router.Resolver
+	if cfg.DNSListen != "" {
+		splitResolver = newSplitDNS(cfg, dialer)
+		resolve = splitResolver.lookupIP
+	}
+	routes, err := buildRoutes(cfg, geoMgr, outbounds, resolve)
+	if err != nil {
+		log.Fatalf("Invalid rules: %v", err)
+	}
+	defer routes.Close()
+	if cfg.FakeIPRange != "" {
+		if routes.fakeIP, err = dns.NewFakeIPPool(cfg.FakeIPRange); err != nil {
+			log.Fatalf("Invalid fake-ip range: %v", err)
+		}
+	}
+	if cfg.ProxyMode == "pac" && geoMgr == nil {
+		log.Printf("[GeoData] No rule manager provided, PAC mode proxies everything")
 	}
 
-	// 3. 监听本地端口
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.LocalPort))
+	// 3. 监听本地端口（及可选的 DNS、透明代理）
+	if splitResolver != nil {
+		splitResolver.routes = routes
+		splitResolver.fake = routes.fakeIP
+		dnsServer, err := dns.Listen(cfg.DNSListen, splitResolver.handle)
+		if err != nil {
+			log.Fatalf("DNS listen failed: %v", err)
+		}
+		log.Printf("[DNS] Listening on %s | Direct: %s | Remote (via tunnel): %s",
+			dnsServer.Addr(), cfg.DNSDirect, cfg.DNSRemote)
+		if routes.fakeIP != nil {
+			log.Printf("[DNS] Fake-IP enabled: %s", routes.fakeIP.Prefix())
+		}
+	}
+	if err := startTransparent(cfg, routes, dialer); err != nil {
+		log.Fatalf("Transparent proxy failed: %v", err)
+	}
+	if err := startTun(cfg, routes, dialer); err != nil {
+		log.Fatalf("TUN failed: %v", err)
+	}
+	listenAddr := net.JoinHostPort(cfg.LocalListen, strconv.Itoa(cfg.LocalPort))
+	l, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Client (Mixed) on :%d -> %s | Mode: %s | Rules: %d",
-		cfg.LocalPort, cfg.ServerAddress, cfg.ProxyMode, len(cfg.RuleURLs))
+	serverDesc := cfg.ServerAddress
+	if len(cfg.Servers) > 0 {
+		serverDesc = strings.Join(cfg.Servers, ", ")
+	}
+	ruleCount := len(cfg.RuleURLs)
+	if cfg.ProxyMode == "rule" {
+		ruleCount = len(routes.rules.Rules())
+	}
+	authDesc := "off"
+	if localAuthEnabled(cfg) {
+		authDesc = "on"
+	}
+	log.Printf("Client (Mixed) on %s -> %s | Mode: %s | Rules: %d | Auth: %s",
+		listenAddr, serverDesc, cfg.ProxyMode, ruleCount, authDesc)
 
 	var primaryTable *sudoku.Table
 	if len(tables) > 0 {
 		primaryTable = tables[0]
 	}
+	// 收到 SIGINT/SIGTERM 时关闭监听，返回前释放连接池与健康检查
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		if _, ok := <-stop; ok {
+			l.Close()
+		}
+	}()
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				log.Printf("Client shutting down")
+				return
+			}
 			continue
 		}
-		go handleMixedConn(c, cfg, primaryTable, geoMgr, dialer)
+		go handleMixedConn(c, cfg, primaryTable, routes, dialer)
+	}
+}
+
+// closeDialer 释放拨号器的后台资源（连接池、健康检查），不支持关闭的拨号器忽略
+func closeDialer(d tunnel.Dialer) {
+	if c, ok := d.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// newGroupDialer spreads connections over several servers according to cfg.LoadBalance.
+func newGroupDialer(cfg *config.Config, servers []string, tables []*sudoku.Table, privateKey []byte) (tunnel.Dialer, error) {
+	upstreams := make([]*tunnel.Upstream, 0, len(servers))
+	for _, addr := range servers {
+		serverCfg := *cfg
+		serverCfg.ServerAddress = addr
+		upstreams = append(upstreams, tunnel.NewUpstream(addr, newServerDialer(&serverCfg, tables, privateKey)))
+	}
+	interval := time.Duration(cfg.HealthCheckInterval) * time.Second
+	var d tunnel.Dialer
+	var err error
+	if cfg.LoadBalance == "" || cfg.LoadBalance == "failover" {
+		d, err = tunnel.NewFailoverDialer(upstreams, interval)
+	} else {
+		d, err = tunnel.NewBalancedDialer(upstreams, cfg.LoadBalance, interval)
+	}
+	if err != nil {
+		for _, u := range upstreams {
+			closeDialer(u.Dialer)
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// newServerDialer builds the dialer for the single server in cfg.ServerAddress.
+// The dialer is an io.Closer; closing it stops its connection pool.
+func newServerDialer(cfg *config.Config, tables []*sudoku.Table, privateKey []byte) tunnel.Dialer {
+	baseDialer := tunnel.BaseDialer{
+		Config:     cfg,
+		Tables:     tables,
+		PrivateKey: privateKey,
+	}
+
+	if cfg.Mux {
+		return &tunnel.MuxDialer{BaseDialer: baseDialer}
 	}
+	standard := &tunnel.StandardDialer{
+		BaseDialer: baseDialer,
+	}
+	standard.EnablePool(cfg.PoolSize, time.Duration(cfg.PoolMaxIdle)*time.Second)
+	return standard
 }
 
-func handleMixedConn(c net.Conn, cfg *config.Config, table *sudoku.Table, geoMgr *geodata.Manager, dialer tunnel.Dialer) {
+func handleMixedConn(c net.Conn, cfg *config.Config, table *sudoku.Table, routes *routeTable, dialer tunnel.Dialer) {
 	// peek第一个字节以确定协议
 	buf := make([]byte, 1)
 	if _, err := io.ReadFull(c, buf); err != nil {
@@ -176,31 +311,25 @@ func handleMixedConn(c net.Conn, cfg *config.Config, table *sudoku.Table, geoMgr
 	switch buf[0] {
 	case 0x05:
 		// SOCKS5
-		handleClientSocks5(pConn, cfg, table, geoMgr, dialer)
+		handleClientSocks5(pConn, cfg, table, routes, dialer)
 	case 0x04:
 		// SOCKS4
-		handleClientSocks4(pConn, cfg, table, geoMgr, dialer)
+		handleClientSocks4(pConn, cfg, table, routes, dialer)
 	default:
 		// 假设是 HTTP/HTTPS
-		handleHTTP(pConn, cfg, table, geoMgr, dialer)
+		handleHTTP(pConn, cfg, table, routes, dialer)
 	}
 }
 
 // ==== SOCKS5 Handler ====
 
-func handleClientSocks5(conn net.Conn, cfg *config.Config, table *sudoku.Table, geoMgr *geodata.Manager, dialer tunnel.Dialer) {
+func handleClientSocks5(conn net.Conn, cfg *config.Config, table *sudoku.Table, routes *routeTable, dialer tunnel.Dialer) {
 	defer conn.Close()
 
-	// 1. SOCKS5 握手
-	buf := make([]byte, 262)
-	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
-		return
-	}
-	nMethods := int(buf[1])
-	if _, err := io.ReadFull(conn, buf[:nMethods]); err != nil {
+	// 1. SOCKS5 握手（配置了 local_username 时要求用户名/密码）
+	if !socks5Negotiate(conn, cfg) {
 		return
 	}
-	conn.Write([]byte{0x05, 0x00})
 
 	// 2. 读取请求
 	header := make([]byte, 3)
@@ -212,12 +341,16 @@ func handleClientSocks5(conn net.Conn, cfg *config.Config, table *sudoku.Table,
 	switch header[1] {
 	case 0x01:
 		// CONNECT
+	case 0x02:
+		// BIND
+		handleSocks5Bind(conn, routes, dialer)
+		return
 	case 0x03:
 		// UDP Associate
 		handleSocks5UDPAssociate(conn, cfg, dialer)
 		return
 	default:
-		// 不支持 Bind 或其他命令
+		// 不支持的命令
 		conn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
 		return
 	}
@@ -226,22 +359,129 @@ func handleClientSocks5(conn net.Conn, cfg *config.Config, table *sudoku.Table,
 	if err != nil {
 		return
 	}
+	destAddrStr, destIP, ok := routes.restoreFakeIP(destAddrStr, destIP)
+	if !ok {
+		conn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	// 目标为 IP 且开启嗅探时，需先回复成功才能收到客户端首包；之后拨号失败只能直接断开
+	replied := false
+	if cfg.Sniff && destIP != nil {
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		replied = true
+		conn, destAddrStr = sniffTarget(conn, destAddrStr)
+	}
 
 	// 3. 路由与连接
-	targetConn, success := dialTarget(destAddrStr, destIP, cfg, geoMgr, dialer)
+	targetConn, success := dialTarget(destAddrStr, destIP, conn.RemoteAddr(), routes, dialer)
 	if !success {
 		// SOCKS5 Error
-		conn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		if !replied {
+			conn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		}
 		return
 	}
 
 	// SOCKS5 Success
-	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	if !replied {
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	}
 
 	// 4. 转发
 	pipeConn(conn, targetConn)
 }
 
+// handleSocks5Bind 按规则为 BIND 选择出口：代理出口由服务端监听，DIRECT 在本机监听；两次应答依次转给客户端
+func handleSocks5Bind(conn net.Conn, routes *routeTable, dialer tunnel.Dialer) {
+	peerAddr, _, peerIP, err := protocol.ReadAddress(conn)
+	if err != nil {
+		return
+	}
+	peerAddr, peerIP, ok := routes.restoreFakeIP(peerAddr, peerIP)
+	if !ok {
+		conn.Write(socks5Reply(0x04, ""))
+		return
+	}
+
+	target := routeTarget(peerAddr, peerIP, conn.RemoteAddr(), routes)
+	switch target {
+	case router.TargetReject:
+		conn.Write(socks5Reply(0x02, ""))
+		return
+	case router.TargetDirect:
+		bindDirect(conn, peerAddr)
+		return
+	}
+
+	bindDialer, ok := routes.outbound(target, dialer).(tunnel.BindDialer)
+	if !ok {
+		conn.Write(socks5Reply(0x07, ""))
+		return
+	}
+	tunnelConn, err := bindDialer.DialBind(peerAddr)
+	if err != nil {
+		log.Printf("[SOCKS5][Bind] %s: %v", peerAddr, err)
+		conn.Write(socks5Reply(0x01, ""))
+		return
+	}
+	// 第一次应答为服务端监听地址，第二次为接入的对端地址
+	for i := 0; i < 2; i++ {
+		status, addr, err := tunnel.ReadBindReply(tunnelConn)
+		if err != nil || status != tunnel.BindSucceeded {
+			tunnelConn.Close()
+			conn.Write(socks5Reply(0x01, ""))
+			return
+		}
+		if _, err := conn.Write(socks5Reply(0x00, addr)); err != nil {
+			tunnelConn.Close()
+			return
+		}
+	}
+	pipeConn(conn, tunnelConn)
+}
+
+// bindDirect 在本机监听 BIND，通告的地址为本机访问对端时使用的出口地址
+func bindDirect(conn net.Conn, peerAddr string) {
+	var advertise net.IP
+	if probe, err := net.Dial("udp", peerAddr); err == nil {
+		advertise = probe.LocalAddr().(*net.UDPAddr).IP
+		probe.Close()
+	} else if la, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		advertise = la.IP
+	}
+	bl, err := tunnel.ListenBind(peerAddr, advertise)
+	if err != nil {
+		conn.Write(socks5Reply(0x01, ""))
+		return
+	}
+	if _, err := conn.Write(socks5Reply(0x00, bl.Addr().String())); err != nil {
+		bl.Close()
+		return
+	}
+	peer, err := bl.Accept()
+	if err != nil {
+		conn.Write(socks5Reply(0x01, ""))
+		return
+	}
+	if _, err := conn.Write(socks5Reply(0x00, peer.RemoteAddr().String())); err != nil {
+		peer.Close()
+		return
+	}
+	pipeConn(conn, peer)
+}
+
+// socks5Reply 构造 SOCKS5 应答，addr 为空或无法编码时使用 0.0.0.0:0
+func socks5Reply(rep byte, addr string) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0x05, rep, 0x00})
+	if addr == "" || protocol.WriteAddress(buf, addr) != nil {
+		buf.Truncate(3)
+		buf.Write([]byte{0x01, 0, 0, 0, 0, 0, 0})
+	}
+	return buf.Bytes()
+}
+
 func handleSocks5UDPAssociate(ctrl net.Conn, cfg *config.Config, dialer tunnel.Dialer) {
 	uotDialer, ok := dialer.(tunnel.UoTDialer)
 	if !ok {
@@ -249,7 +489,13 @@ func handleSocks5UDPAssociate(ctrl net.Conn, cfg *config.Config, dialer tunnel.D
 		return
 	}
 
-	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	// UDP 中继绑定在客户端连进来的本地地址上，局域网客户端也能访问；
+	// 只接受来自控制连接同一 IP 的数据报，避免中继对局域网中的其他主机开放
+	bindIP := net.ParseIP("127.0.0.1")
+	if la, ok := ctrl.LocalAddr().(*net.TCPAddr); ok && la.IP != nil && !la.IP.IsUnspecified() {
+		bindIP = la.IP
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: bindIP, Port: 0})
 	if err != nil {
 		ctrl.Write([]byte{0x05, 0x01, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
 		return
@@ -271,6 +517,9 @@ func handleSocks5UDPAssociate(ctrl net.Conn, cfg *config.Config, dialer tunnel.D
 
 	log.Printf("[SOCKS5][UDP] Associate ready on %s -> %s", udpConn.LocalAddr().String(), cfg.ServerAddress)
 	session := newUoTClientSession(ctrl, udpConn, uotConn)
+	if ra, ok := ctrl.RemoteAddr().(*net.TCPAddr); ok {
+		session.allowedIP = ra.IP
+	}
 	session.run()
 }
 
@@ -304,17 +553,33 @@ type uotClientSession struct {
 	uotConn   net.Conn
 	closeOnce sync.Once
 	closed    chan struct{}
+	allowedIP net.IP // 非空时丢弃其他来源的数据报
 
-	clientAddrMu sync.RWMutex
-	clientAddr   *net.UDPAddr
+	// 同一关联可被多个应用（客户端地址）共用：按目标记录最近发往它的客户端，回包据此分发；
+	// 目标为域名时回包来源是 IP，无法对应，交给最近一次发送数据的客户端
+	peersMu    sync.Mutex
+	peers      map[string]udpPeer
+	lastClient *net.UDPAddr
+
+	frags map[string]*udpReassembler // 客户端地址 -> 分片重组队列，仅 pipeClientToServer 使用
+}
+
+type udpPeer struct {
+	client   *net.UDPAddr
+	lastSeen time.Time
 }
 
+// maxUDPPeers 目标映射超过该数量时清理空闲条目
+const maxUDPPeers = 1024
+
 func newUoTClientSession(ctrl net.Conn, udpConn *net.UDPConn, uotConn net.Conn) *uotClientSession {
 	return &uotClientSession{
 		ctrlConn: ctrl,
 		udpConn:  udpConn,
 		uotConn:  uotConn,
 		closed:   make(chan struct{}),
+		peers:    make(map[string]udpPeer),
+		frags:    make(map[string]*udpReassembler),
 	}
 }
 
@@ -359,11 +624,18 @@ func (s *uotClientSession) pipeClientToServer() {
 			s.close()
 			return
 		}
-		destAddr, payload, err := decodeSocks5UDPRequest(buf[:n])
+		if s.allowedIP != nil && !s.allowedIP.Equal(addr.IP) {
+			continue
+		}
+		frag, destAddr, payload, err := decodeSocks5UDPRequest(buf[:n])
 		if err != nil {
 			continue
 		}
-		s.setClientAddr(addr)
+		destAddr, payload, ok := s.reassemble(addr, frag, destAddr, payload)
+		if !ok {
+			continue
+		}
+		s.recordPeer(destAddr, addr)
 
 		if err := tunnel.WriteUoTDatagram(s.uotConn, destAddr, payload); err != nil {
 			s.close()
@@ -372,6 +644,57 @@ func (s *uotClientSession) pipeClientToServer() {
 	}
 }
 
+// reassemble 把分片交给该客户端的重组队列；未分片的数据报直接返回
+func (s *uotClientSession) reassemble(client *net.UDPAddr, frag byte, dest string, payload []byte) (string, []byte, bool) {
+	key := client.String()
+	r := s.frags[key]
+	if r == nil && frag == 0 {
+		return dest, payload, true
+	}
+	now := time.Now()
+	if r == nil {
+		if len(s.frags) >= maxUDPPeers {
+			for k, q := range s.frags {
+				if !q.pending(now) {
+					delete(s.frags, k)
+				}
+			}
+		}
+		r = &udpReassembler{}
+		s.frags[key] = r
+	}
+	dest, payload, ok := r.add(frag, dest, payload, now)
+	if !r.pending(now) {
+		delete(s.frags, key)
+	}
+	return dest, payload, ok
+}
+
+func (s *uotClientSession) recordPeer(dest string, client *net.UDPAddr) {
+	now := time.Now()
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	s.lastClient = client
+	if _, ok := s.peers[dest]; !ok && len(s.peers) >= maxUDPPeers {
+		for k, p := range s.peers {
+			if now.Sub(p.lastSeen) > udpIdleTimeout {
+				delete(s.peers, k)
+			}
+		}
+	}
+	s.peers[dest] = udpPeer{client: client, lastSeen: now}
+}
+
+// clientFor 返回应接收来自 src 的回包的客户端
+func (s *uotClientSession) clientFor(src string) *net.UDPAddr {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+	if p, ok := s.peers[src]; ok {
+		return p.client
+	}
+	return s.lastClient
+}
+
 func (s *uotClientSession) pipeServerToClient() {
 	for {
 		addrStr, payload, err := tunnel.ReadUoTDatagram(s.uotConn)
@@ -380,7 +703,7 @@ func (s *uotClientSession) pipeServerToClient() {
 			return
 		}
 
-		clientAddr := s.getClientAddr()
+		clientAddr := s.clientFor(addrStr)
 		if clientAddr == nil {
 			continue
 		}
@@ -396,23 +719,9 @@ func (s *uotClientSession) pipeServerToClient() {
 	}
 }
 
-func (s *uotClientSession) setClientAddr(addr *net.UDPAddr) {
-	s.clientAddrMu.Lock()
-	defer s.clientAddrMu.Unlock()
-	if s.clientAddr == nil {
-		s.clientAddr = addr
-	}
-}
-
-func (s *uotClientSession) getClientAddr() *net.UDPAddr {
-	s.clientAddrMu.RLock()
-	defer s.clientAddrMu.RUnlock()
-	return s.clientAddr
-}
-
 // ==== SOCKS4 Handler ====
 
-func handleClientSocks4(conn net.Conn, cfg *config.Config, table *sudoku.Table, geoMgr *geodata.Manager, dialer tunnel.Dialer) {
+func handleClientSocks4(conn net.Conn, cfg *config.Config, table *sudoku.Table, routes *routeTable, dialer tunnel.Dialer) {
 	defer conn.Close()
 
 	// SOCKS4 Request Format:
@@ -429,6 +738,11 @@ func handleClientSocks4(conn net.Conn, cfg *config.Config, table *sudoku.Table,
 	if vn != 0x04 || cd != 0x01 { // Only support Connect (0x01)
 		return
 	}
+	// SOCKS4 没有密码字段，启用鉴权时一律拒绝
+	if localAuthEnabled(cfg) {
+		conn.Write([]byte{0x00, 0x5B, 0, 0, 0, 0, 0, 0})
+		return
+	}
 
 	port := binary.BigEndian.Uint16(buf[2:4])
 	ipBytes := buf[4:8]
@@ -453,17 +767,33 @@ func handleClientSocks4(conn net.Conn, cfg *config.Config, table *sudoku.Table,
 		destIP = net.IP(ipBytes)
 		destAddrStr = fmt.Sprintf("%s:%d", destIP.String(), port)
 	}
+	destAddrStr, destIP, ok := routes.restoreFakeIP(destAddrStr, destIP)
+	if !ok {
+		conn.Write([]byte{0x00, 0x5B, 0, 0, 0, 0, 0, 0})
+		return
+	}
+
+	replied := false
+	if cfg.Sniff && destIP != nil {
+		conn.Write([]byte{0x00, 0x5A, 0, 0, 0, 0, 0, 0})
+		replied = true
+		conn, destAddrStr = sniffTarget(conn, destAddrStr)
+	}
 
 	// Route & Connect
-	targetConn, success := dialTarget(destAddrStr, destIP, cfg, geoMgr, dialer)
+	targetConn, success := dialTarget(destAddrStr, destIP, conn.RemoteAddr(), routes, dialer)
 	if !success {
 		// SOCKS4 Error (91 = request rejected)
-		conn.Write([]byte{0x00, 0x5B, 0, 0, 0, 0, 0, 0})
+		if !replied {
+			conn.Write([]byte{0x00, 0x5B, 0, 0, 0, 0, 0, 0})
+		}
 		return
 	}
 
 	// SOCKS4 Success (90 = request granted)
-	conn.Write([]byte{0x00, 0x5A, 0, 0, 0, 0, 0, 0})
+	if !replied {
+		conn.Write([]byte{0x00, 0x5A, 0, 0, 0, 0, 0, 0})
+	}
 
 	pipeConn(conn, targetConn)
 }
@@ -483,24 +813,22 @@ func readString(r io.Reader) (string, error) {
 	return string(buf), nil
 }
 
-func decodeSocks5UDPRequest(pkt []byte) (string, []byte, error) {
+// decodeSocks5UDPRequest 解析 RSV(2) | FRAG(1) | ADDR | DATA，返回 FRAG 供分片重组使用
+func decodeSocks5UDPRequest(pkt []byte) (byte, string, []byte, error) {
 	if len(pkt) < 4 {
-		return "", nil, fmt.Errorf("packet too short")
-	}
-	if pkt[2] != 0x00 {
-		return "", nil, fmt.Errorf("frag not supported")
+		return 0, "", nil, fmt.Errorf("packet too short")
 	}
 
 	reader := bytes.NewReader(pkt[3:])
 	addrStr, _, _, err := protocol.ReadAddress(reader)
 	if err != nil {
-		return "", nil, err
+		return 0, "", nil, err
 	}
 	payload := make([]byte, reader.Len())
 	if _, err := io.ReadFull(reader, payload); err != nil {
-		return "", nil, err
+		return 0, "", nil, err
 	}
-	return addrStr, payload, nil
+	return pkt[2], addrStr, payload, nil
 }
 
 func buildUDPResponsePacket(addr string, payload []byte) []byte {
@@ -512,124 +840,3 @@ func buildUDPResponsePacket(addr string, payload []byte) []byte {
 	buf.Write(payload)
 	return buf.Bytes()
 }
-
-// ==== HTTP Handler ====
-
-func handleHTTP(conn net.Conn, cfg *config.Config, table *sudoku.Table, geoMgr *geodata.Manager, dialer tunnel.Dialer) {
-	defer conn.Close()
-
-	req, err := http.ReadRequest(bufio.NewReader(conn))
-	if err != nil {
-		return
-	}
-
-	host := req.Host
-	// 如果不带端口，默认补全
-	if !strings.Contains(host, ":") {
-		if req.Method == http.MethodConnect {
-			host += ":443"
-		} else {
-			host += ":80"
-		}
-	}
-
-	// 解析 IP (为了路由决策)
-	hostName, _, _ := net.SplitHostPort(host)
-	destIP := net.ParseIP(hostName)
-
-	// 路由决策与连接
-	targetConn, success := dialTarget(host, destIP, cfg, geoMgr, dialer)
-	if !success {
-		conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
-		return
-	}
-
-	if req.Method == http.MethodConnect {
-		// HTTPS Tunnel: 建立连接后回复 200 OK，然后纯透传
-		conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
-		pipeConn(conn, targetConn)
-	} else {
-		req.RequestURI = ""
-		// 如果是绝对路径转换为相对路径
-		if req.URL.Scheme != "" {
-			req.URL.Scheme = ""
-			req.URL.Host = ""
-		}
-
-		if err := req.Write(targetConn); err != nil {
-			targetConn.Close()
-			return
-		}
-		pipeConn(conn, targetConn)
-	}
-}
-
-// ==== Common Logic  ====
-
-func dialTarget(destAddrStr string, destIP net.IP, cfg *config.Config, geoMgr *geodata.Manager, dialer tunnel.Dialer) (net.Conn, bool) {
-	shouldProxy := true
-
-	if cfg.ProxyMode == "global" {
-		shouldProxy = true
-	} else if cfg.ProxyMode == "direct" {
-		shouldProxy = false
-	} else if cfg.ProxyMode == "pac" {
-		// 1. 检查域名或已知 IP 是否在 CN 列表
-		if geoMgr.IsCN(destAddrStr, destIP) {
-			shouldProxy = false
-			log.Printf("[PAC] %s -> DIRECT (Rule Match)", destAddrStr)
-		} else {
-			// 2. 如果没有匹配且 destIP 未知 (是域名)，尝试解析 IP 再检查
-			if destIP == nil {
-				host, _, _ := net.SplitHostPort(destAddrStr)
-
-				// Try Cache First
-				if cachedIP := globalDNSCache.Lookup(host); cachedIP != nil {
-					if geoMgr.IsCN(destAddrStr, cachedIP) {
-						shouldProxy = false
-						log.Printf("[PAC] %s (%s) -> DIRECT (Cache Rule Match)", destAddrStr, cachedIP)
-					} else {
-						log.Printf("[PAC] %s (%s) -> PROXY (Cache)", destAddrStr, cachedIP)
-					}
-				} else {
-					// Real Lookup
-					ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-					ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
-					cancel()
-
-					if err == nil && len(ips) > 0 {
-						globalDNSCache.Set(host, ips[0]) // Cache it
-						if geoMgr.IsCN(destAddrStr, ips[0]) {
-							shouldProxy = false
-							log.Printf("[PAC] %s (%s) -> DIRECT (IP Rule Match)", destAddrStr, ips[0])
-						} else {
-							log.Printf("[PAC] %s (%s) -> PROXY", destAddrStr, ips[0])
-						}
-					} else {
-						log.Printf("[PAC] %s -> PROXY (Default)", destAddrStr)
-					}
-				}
-			} else {
-				// 解析失败或无 IP，默认代理
-				log.Printf("[PAC] %s -> PROXY", destAddrStr)
-			}
-		}
-	}
-
-	if shouldProxy {
-		conn, err := dialer.Dial(destAddrStr)
-		if err != nil {
-			log.Printf("[Proxy] Dial Failed: %v", err)
-			return nil, false
-		}
-		return conn, true
-	} else {
-		// 直连模式
-		dConn, err := net.DialTimeout("tcp", destAddrStr, 5*time.Second)
-		if err != nil {
-			log.Printf("[Direct] Dial Failed: %v", err)
-			return nil, false
-		}
-		return dConn, true
-	}
-}
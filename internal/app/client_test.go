@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -168,3 +169,81 @@ func TestHandleMixedConn_HTTP(t *testing.T) {
 		t.Errorf("HTTP target mismatch: got %q, want %q", target, expectedTarget)
 	}
 }
+
+func TestHandleMixedConn_SOCKS5Auth(t *testing.T) {
+	cfg := &config.Config{ProxyMode: "global", LocalUsername: "alice", LocalPassword: "s3cret"}
+	table := sudoku.NewTable("key", "prefer_entropy")
+	request := []byte{0x05, 0x01, 0x00, 0x01, 1, 2, 3, 4, 0, 80}
+
+	tests := []struct {
+		name       string
+		input      []byte
+		wantReply  []byte
+		wantTarget string
+	}{
+		{
+			name:      "no-auth only",
+			input:     []byte{0x05, 0x01, 0x00},
+			wantReply: []byte{0x05, 0xFF},
+		},
+		{
+			name:      "wrong password",
+			input:     append([]byte{0x05, 0x02, 0x00, 0x02, 0x01, 5, 'a', 'l', 'i', 'c', 'e', 5, 'w', 'r', 'o', 'n', 'g'}, request...),
+			wantReply: []byte{0x05, 0x02, 0x01, 0x01},
+		},
+		{
+			name:       "valid credentials",
+			input:      append([]byte{0x05, 0x01, 0x02, 0x01, 5, 'a', 'l', 'i', 'c', 'e', 6, 's', '3', 'c', 'r', 'e', 't'}, request...),
+			wantReply:  []byte{0x05, 0x02, 0x01, 0x00, 0x05, 0x00},
+			wantTarget: "1.2.3.4:80",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewMockConn(tt.input)
+			var target string
+			dialer := &MockDialer{DialFunc: func(addr string) (net.Conn, error) {
+				target = addr
+				return NewMockConn(nil), nil
+			}}
+			handleMixedConn(conn, cfg, table, nil, dialer)
+			if got := conn.WriteBuf.Bytes(); !bytes.HasPrefix(got, tt.wantReply) {
+				t.Fatalf("reply = %x, want prefix %x", got, tt.wantReply)
+			}
+			if target != tt.wantTarget {
+				t.Fatalf("target = %q, want %q", target, tt.wantTarget)
+			}
+		})
+	}
+
+	// SOCKS4 无法携带密码，启用鉴权后拒绝
+	conn := NewMockConn([]byte{0x04, 0x01, 0x00, 0x50, 1, 2, 3, 4, 'u', 0x00})
+	handleMixedConn(conn, cfg, table, nil, &MockDialer{})
+	if got := conn.WriteBuf.Bytes(); len(got) < 2 || got[1] != 0x5B {
+		t.Fatalf("SOCKS4 reply = %x, want rejection", got)
+	}
+}
+
+func TestHandleMixedConn_HTTPAuth(t *testing.T) {
+	cfg := &config.Config{ProxyMode: "global", LocalUsername: "alice", LocalPassword: "s3cret"}
+	table := sudoku.NewTable("key", "prefer_entropy")
+
+	conn := NewMockConn([]byte("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	handleMixedConn(conn, cfg, table, nil, &MockDialer{})
+	if got := conn.WriteBuf.String(); !strings.HasPrefix(got, "HTTP/1.1 407") {
+		t.Fatalf("reply without credentials = %q", got)
+	}
+
+	// YWxpY2U6czNjcmV0 = base64("alice:s3cret")
+	conn = NewMockConn([]byte("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nProxy-Authorization: Basic YWxpY2U6czNjcmV0\r\n\r\n"))
+	upstream := NewMockConn(nil)
+	dialer := &MockDialer{DialFunc: func(addr string) (net.Conn, error) { return upstream, nil }}
+	handleMixedConn(conn, cfg, table, nil, dialer)
+	forwarded := upstream.WriteBuf.String()
+	if !strings.HasPrefix(forwarded, "GET / HTTP/1.1") {
+		t.Fatalf("request not forwarded: %q", forwarded)
+	}
+	if strings.Contains(forwarded, "Proxy-Authorization") {
+		t.Fatalf("credentials leaked upstream: %q", forwarded)
+	}
+}
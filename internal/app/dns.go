@@ -0,0 +1,148 @@
+// internal/app/dns.go
+package app
+
+import (
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/dns"
+	"github.com/saba-futai/sudoku/internal/router"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+)
+
+// splitDNS 按路由规则分流 DNS 查询：DIRECT 交给本地直连解析器，PROXY / 命名出口经对应隧道（UoT）
+// 交给远端解析器，REJECT 直接返回 NXDOMAIN。代理域名的查询因此不会出现在本地网络上。
+// 启用 Fake-IP 时，走隧道的域名不再真正解析，而是返回地址池中的假地址，连接时再还原为域名
+type splitDNS struct {
+	direct dns.Upstream
+	remote string // 隧道另一端使用的解析器地址
+	dialer tunnel.Dialer
+	routes *routeTable     // 在 buildRoutes 之后设置；为空时全部走代理
+	fake   *dns.FakeIPPool // 可选
+
+	mu      sync.Mutex
+	tunnels map[string]*dns.TunnelUpstream // 出口名 -> 共享的 UoT 查询会话
+}
+
+func newSplitDNS(cfg *config.Config, dialer tunnel.Dialer) *splitDNS {
+	return &splitDNS{
+		direct:  &dns.UDPUpstream{Addr: cfg.DNSDirect},
+		remote:  cfg.DNSRemote,
+		dialer:  dialer,
+		tunnels: make(map[string]*dns.TunnelUpstream),
+	}
+}
+
+// targetFor 返回 host 按规则应走的出口（不触发解析）
+func (s *splitDNS) targetFor(host string) string {
+	if s.routes != nil {
+		if rule := s.routes.rules.MatchDomain(host); rule != nil {
+			return rule.Target
+		}
+	}
+	return router.TargetProxy
+}
+
+// upstreamFor 返回 host 对应的出口名与解析器；REJECT 时解析器为 nil
+func (s *splitDNS) upstreamFor(host string) (string, dns.Upstream) {
+	return s.upstream(s.targetFor(host))
+}
+
+func (s *splitDNS) upstream(target string) (string, dns.Upstream) {
+	switch target {
+	case router.TargetReject:
+		return target, nil
+	case router.TargetDirect:
+		return target, s.direct
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if up, ok := s.tunnels[target]; ok {
+		return target, up
+	}
+	dialer := s.dialer
+	if target != router.TargetProxy {
+		dialer = s.routes.outbounds[target]
+	}
+	uot, ok := dialer.(tunnel.UoTDialer)
+	if !ok {
+		// 不能经隧道查询时宁可失败，也不回退到本地解析器造成泄露
+		return target, nil
+	}
+	up := &dns.TunnelUpstream{Dialer: uot, Addr: s.remote}
+	s.tunnels[target] = up
+	return target, up
+}
+
+// fakeIPTTL 保持很短，避免映射被回收后应用仍缓存旧的假地址
+const fakeIPTTL = 1
+
+// handle 实现 dns.Handler
+func (s *splitDNS) handle(req []byte) []byte {
+	q, _, err := dns.ParseQuestion(req)
+	if err != nil {
+		return dns.ErrorReply(req, dns.RcodeFormatError)
+	}
+	target := s.targetFor(q.Name)
+	if s.fake != nil && target != router.TargetDirect && target != router.TargetReject && q.Class == dns.ClassIN {
+		switch q.Type {
+		case dns.TypeA:
+			return dns.AnswerReply(req, fakeIPTTL, []net.IP{s.fake.IP(q.Name)})
+		case dns.TypeAAAA:
+			// 地址池只有 IPv4，AAAA 返回空应答，让应用改用 A 记录
+			return dns.AnswerReply(req, fakeIPTTL, nil)
+		}
+	}
+	_, up := s.upstream(target)
+	if up == nil {
+		if target == router.TargetReject {
+			return dns.ErrorReply(req, dns.RcodeNameError)
+		}
+		return dns.ErrorReply(req, dns.RcodeServerFailure)
+	}
+	resp, err := up.Exchange(req)
+	if err != nil {
+		log.Printf("[DNS] %s via %s failed: %v", q.Name, target, err)
+		return dns.ErrorReply(req, dns.RcodeServerFailure)
+	}
+	return resp
+}
+
+// lookupIP 用与 handle 相同的分流路径解析 host（IPv4 优先），供 IP 类规则使用，结果写入 globalDNSCache
+func (s *splitDNS) lookupIP(host string) net.IP {
+	if cachedIP := globalDNSCache.Lookup(host); cachedIP != nil {
+		return cachedIP
+	}
+	_, up := s.upstreamFor(host)
+	if up == nil {
+		return nil
+	}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		req, err := dns.NewQuery(uint16(rand.Uint32()), host, qtype)
+		if err != nil {
+			return nil
+		}
+		resp, err := up.Exchange(req)
+		if err != nil || dns.Rcode(resp) != dns.RcodeSuccess {
+			continue
+		}
+		if ips, _, err := dns.AnswerIPs(resp); err == nil && len(ips) > 0 {
+			globalDNSCache.Set(host, ips[0])
+			return ips[0]
+		}
+	}
+	return nil
+}
+
+func (s *splitDNS) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, up := range s.tunnels {
+		up.Close()
+	}
+	return nil
+}
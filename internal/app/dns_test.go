@@ -0,0 +1,188 @@
+package app
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/dns"
+	"github.com/saba-futai/sudoku/internal/router"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+)
+
+// uotDNSDialer answers every UoT DNS query with answer and records the requested names.
+type uotDNSDialer struct {
+	MockDialer
+	answer net.IP
+
+	mu    sync.Mutex
+	names []string
+}
+
+func (d *uotDNSDialer) DialUDPOverTCP() (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		for {
+			addr, req, err := tunnel.ReadUoTDatagram(server)
+			if err != nil {
+				return
+			}
+			q, _, _ := dns.ParseQuestion(req)
+			d.mu.Lock()
+			d.names = append(d.names, q.Name)
+			d.mu.Unlock()
+			if err := tunnel.WriteUoTDatagram(server, addr, dns.AnswerReply(req, 60, []net.IP{d.answer})); err != nil {
+				return
+			}
+		}
+	}()
+	return client, nil
+}
+
+func (d *uotDNSDialer) queried() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.names...)
+}
+
+type directDNS struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (d *directDNS) Exchange(req []byte) ([]byte, error) {
+	q, _, _ := dns.ParseQuestion(req)
+	d.mu.Lock()
+	d.names = append(d.names, q.Name)
+	d.mu.Unlock()
+	return dns.AnswerReply(req, 60, []net.IP{net.ParseIP("192.0.2.1")}), nil
+}
+
+func TestSplitDNSFollowsRules(t *testing.T) {
+	proxy := &uotDNSDialer{answer: net.ParseIP("198.51.100.1")}
+	us := &uotDNSDialer{answer: net.ParseIP("198.51.100.2")}
+	direct := &directDNS{}
+
+	cfg := &config.Config{
+		ProxyMode: "rule",
+		DNSRemote: "8.8.8.8:53",
+		Rules: []string{
+			"DOMAIN-SUFFIX,ads.example,REJECT",
+			"DOMAIN-SUFFIX,cn.example,DIRECT",
+			"DOMAIN,stream.example,us",
+			"IP-CIDR,10.0.0.0/8,DIRECT",
+			"MATCH,PROXY",
+		},
+	}
+	split := newSplitDNS(cfg, proxy)
+	split.direct = direct
+	defer split.Close()
+	routes, err := buildRoutes(cfg, nil, map[string]tunnel.Dialer{"us": us}, split.lookupIP)
+	if err != nil {
+		t.Fatalf("buildRoutes failed: %v", err)
+	}
+	split.routes = routes
+
+	cases := []struct {
+		name  string
+		rcode int
+		ip    string
+	}{
+		{"x.ads.example", dns.RcodeNameError, ""},
+		{"www.cn.example", dns.RcodeSuccess, "192.0.2.1"},
+		{"stream.example", dns.RcodeSuccess, "198.51.100.2"},
+		{"other.example", dns.RcodeSuccess, "198.51.100.1"},
+	}
+	for _, tc := range cases {
+		req, _ := dns.NewQuery(1, tc.name, dns.TypeA)
+		resp := split.handle(req)
+		if dns.Rcode(resp) != tc.rcode {
+			t.Fatalf("%s: rcode %d, want %d", tc.name, dns.Rcode(resp), tc.rcode)
+		}
+		ips, _, _ := dns.AnswerIPs(resp)
+		if tc.ip == "" {
+			if len(ips) != 0 {
+				t.Fatalf("%s: unexpected answers %v", tc.name, ips)
+			}
+			continue
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.ParseIP(tc.ip)) {
+			t.Fatalf("%s: got %v, want %s", tc.name, ips, tc.ip)
+		}
+	}
+
+	// The IP-CIDR rule must resolve proxied domains through the tunnel, never locally.
+	globalDNSCache.mu.Lock()
+	delete(globalDNSCache.cache, "resolve-me.example")
+	globalDNSCache.mu.Unlock()
+	routes.rules.Match(router.NewMetadata("resolve-me.example:443", nil, nil))
+	if got := proxy.queried(); len(got) != 2 || got[1] != "resolve-me.example" {
+		t.Fatalf("tunnel resolver saw %v", got)
+	}
+	direct.mu.Lock()
+	defer direct.mu.Unlock()
+	if len(direct.names) != 1 || direct.names[0] != "www.cn.example" {
+		t.Fatalf("direct resolver saw %v", direct.names)
+	}
+}
+
+func TestFakeIPRoundTrip(t *testing.T) {
+	pool, err := dns.NewFakeIPPool("198.18.0.0/15")
+	if err != nil {
+		t.Fatalf("NewFakeIPPool failed: %v", err)
+	}
+	direct := &directDNS{}
+	cfg := &config.Config{ProxyMode: "rule", Rules: []string{"DOMAIN-SUFFIX,cn.example,DIRECT", "MATCH,PROXY"}}
+	routes, err := buildRoutes(cfg, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("buildRoutes failed: %v", err)
+	}
+	routes.fakeIP = pool
+	split := newSplitDNS(cfg, &MockDialer{})
+	split.direct, split.routes, split.fake = direct, routes, pool
+
+	// Proxied names get a fake address (and an empty AAAA answer), direct names a real one.
+	req, _ := dns.NewQuery(1, "video.example", dns.TypeA)
+	ips, _, _ := dns.AnswerIPs(split.handle(req))
+	if len(ips) != 1 || !pool.Contains(ips[0]) {
+		t.Fatalf("proxied name answered with %v", ips)
+	}
+	fake := ips[0]
+	req, _ = dns.NewQuery(2, "video.example", dns.TypeAAAA)
+	if resp := split.handle(req); dns.Rcode(resp) != dns.RcodeSuccess {
+		t.Fatalf("AAAA rcode %d", dns.Rcode(resp))
+	} else if ips, _, _ := dns.AnswerIPs(resp); len(ips) != 0 {
+		t.Fatalf("AAAA answered with %v", ips)
+	}
+	req, _ = dns.NewQuery(3, "www.cn.example", dns.TypeA)
+	if ips, _, _ := dns.AnswerIPs(split.handle(req)); len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("direct name answered with %v", ips)
+	}
+
+	// A SOCKS5 CONNECT to the fake address reaches the tunnel by domain.
+	var target string
+	dialer := &MockDialer{DialFunc: func(addr string) (net.Conn, error) {
+		target = addr
+		return NewMockConn(nil), nil
+	}}
+	input := append([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01}, fake.To4()...)
+	input = append(input, 0x01, 0xBB)
+	handleClientSocks5(NewMockConn(input), cfg, nil, routes, dialer)
+	if target != "video.example:443" {
+		t.Fatalf("SOCKS5 target = %q", target)
+	}
+
+	// An address inside the pool without a mapping is refused rather than sent on as an IP.
+	target = ""
+	input = []byte{0x04, 0x01, 0x00, 0x50, 198, 19, 255, 1, 0x00}
+	conn := NewMockConn(input)
+	handleClientSocks4(conn, cfg, nil, routes, dialer)
+	if target != "" {
+		t.Fatalf("stale fake address was dialed as %q", target)
+	}
+	if resp := conn.WriteBuf.Bytes(); len(resp) < 2 || resp[1] != 0x5B {
+		t.Fatalf("SOCKS4 reply = %v", resp)
+	}
+}
@@ -0,0 +1,410 @@
+// internal/app/httpproxy.go
+package app
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+	"github.com/saba-futai/sudoku/pkg/obfs/sudoku"
+)
+
+// ==== HTTP Handler ====
+
+// hopHeaders 逐跳头部，只对当前连接有效，不转发（RFC 7230 §6.1）
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// maxHTTPDrain 回复错误后继续保持连接时，最多丢弃的请求体长度
+const maxHTTPDrain = 256 * 1024
+
+// handleHTTP 是 HTTP/1.1 正向代理：同一客户端连接上的每个请求独立解析与路由，
+// 上游连接按目标主机复用；CONNECT 与协议升级（如 WebSocket）转为透传
+func handleHTTP(conn net.Conn, cfg *config.Config, table *sudoku.Table, routes *routeTable, dialer tunnel.Dialer) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	pool := &httpUpstreams{routes: routes, dialer: dialer, src: conn.RemoteAddr(), idle: make(map[string]*httpUpstream)}
+	defer pool.closeAll()
+
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if !httpProxyAuthorized(req, cfg) {
+			conn.Write([]byte(httpProxyAuthRequired))
+			return
+		}
+
+		host, destIP, ok := httpTarget(req, routes)
+		if !ok {
+			if !writeHTTPError(conn, req, http.StatusForbidden, !req.Close) {
+				return
+			}
+			continue
+		}
+
+		if req.Method == http.MethodConnect {
+			// HTTPS Tunnel: 建立连接后回复 200，然后纯透传
+			targetConn, success := dialTarget(host, destIP, conn.RemoteAddr(), routes, dialer)
+			if !success {
+				conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+				return
+			}
+			conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+			pipeConn(withBuffered(conn, br), targetConn)
+			return
+		}
+
+		if headerHasToken(req.Header, "Connection", "upgrade") && req.Header.Get("Upgrade") != "" {
+			handleHTTPUpgrade(conn, br, req, host, destIP, routes, dialer)
+			return
+		}
+
+		if !forwardHTTPRequest(conn, req, host, destIP, pool) {
+			return
+		}
+	}
+}
+
+// httpTarget 取出请求目标（补全默认端口）并还原 Fake-IP
+func httpTarget(req *http.Request, routes *routeTable) (string, net.IP, bool) {
+	host := req.Host
+	if req.URL != nil && req.URL.Host != "" {
+		host = req.URL.Host
+	}
+	// 如果不带端口，默认补全
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := "80"
+		if req.Method == http.MethodConnect || (req.URL != nil && req.URL.Scheme == "https") {
+			port = "443"
+		}
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	hostName, _, _ := net.SplitHostPort(host)
+	return routes.restoreFakeIP(host, net.ParseIP(hostName))
+}
+
+// forwardHTTPRequest 转发一个普通请求并写回响应；返回客户端连接是否可以继续使用
+func forwardHTTPRequest(conn net.Conn, req *http.Request, host string, destIP net.IP, pool *httpUpstreams) bool {
+	clientKeepAlive := !req.Close
+	prepareOutboundRequest(req)
+
+	if headerHasToken(req.Header, "Expect", "100-continue") {
+		// 由代理直接应答 100，避免客户端等待上游
+		req.Header.Del("Expect")
+		conn.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+	}
+
+	replayable := req.Body == nil || req.Body == http.NoBody
+	var up *httpUpstream
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		var reused bool
+		var err error
+		up, reused, err = pool.get(host, destIP)
+		if err != nil {
+			return writeHTTPError(conn, req, http.StatusBadGateway, clientKeepAlive)
+		}
+		if resp, err = up.roundTrip(req); err == nil {
+			break
+		}
+		up.Close()
+		// 复用的空闲连接可能已被上游关闭：无请求体时换新连接重试一次
+		if !reused || !replayable || attempt > 0 {
+			log.Printf("[HTTP] %s %s: %v", req.Method, host, err)
+			return writeHTTPError(conn, req, http.StatusBadGateway, clientKeepAlive)
+		}
+	}
+	defer resp.Body.Close()
+
+	upstreamKeepAlive := !resp.Close
+	removeHopHeaders(resp.Header)
+	if resp.ContentLength < 0 && !isChunked(resp.TransferEncoding) {
+		// 响应以关闭连接结束，客户端侧同样只能关闭
+		clientKeepAlive = false
+	}
+	if !req.ProtoAtLeast(1, 1) {
+		// HTTP/1.0 客户端不理解分块编码，未知长度时以关闭连接结束响应
+		if resp.ContentLength < 0 {
+			resp.TransferEncoding = nil
+			clientKeepAlive = false
+		} else if clientKeepAlive {
+			resp.Header.Set("Connection", "keep-alive")
+		}
+	}
+	resp.Close = !clientKeepAlive
+	if err := resp.Write(conn); err != nil {
+		up.Close()
+		return false
+	}
+	// resp.Write 已读完响应体，上游连接可留给下一个发往同一主机的请求
+	if upstreamKeepAlive {
+		pool.put(host, up)
+	} else {
+		up.Close()
+	}
+	return clientKeepAlive
+}
+
+func isChunked(te []string) bool {
+	return len(te) > 0 && te[0] == "chunked"
+}
+
+// prepareOutboundRequest 把代理请求改写为发往源站的形式：origin-form 请求行、去掉逐跳头部
+func prepareOutboundRequest(req *http.Request) {
+	req.RequestURI = ""
+	// 如果是绝对路径转换为相对路径
+	if req.URL.Scheme != "" || req.URL.Host != "" {
+		req.URL.Scheme = ""
+		req.URL.Host = ""
+	}
+	removeHopHeaders(req.Header)
+	req.Close = false
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// 不让 req.Write 补上 Go 的默认 User-Agent
+		req.Header["User-Agent"] = []string{""}
+	}
+}
+
+// handleHTTPUpgrade 转发协议升级请求，上游返回 101 后两端直接透传
+func handleHTTPUpgrade(conn net.Conn, br *bufio.Reader, req *http.Request, host string, destIP net.IP, routes *routeTable, dialer tunnel.Dialer) {
+	upgrade := req.Header.Get("Upgrade")
+	prepareOutboundRequest(req)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", upgrade)
+
+	targetConn, success := dialTarget(host, destIP, conn.RemoteAddr(), routes, dialer)
+	if !success {
+		writeHTTPError(conn, req, http.StatusBadGateway, false)
+		return
+	}
+	upBr := bufio.NewReader(targetConn)
+	if err := req.Write(targetConn); err != nil {
+		targetConn.Close()
+		return
+	}
+	resp, err := http.ReadResponse(upBr, req)
+	if err != nil {
+		targetConn.Close()
+		writeHTTPError(conn, req, http.StatusBadGateway, false)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// 上游拒绝升级：按普通响应返回后结束
+		removeHopHeaders(resp.Header)
+		resp.Close = true
+		resp.Write(conn)
+		resp.Body.Close()
+		targetConn.Close()
+		return
+	}
+	if err := resp.Write(conn); err != nil {
+		targetConn.Close()
+		return
+	}
+	pipeConn(withBuffered(conn, br), withBuffered(targetConn, upBr))
+}
+
+// writeHTTPError 回复一个空响应；keepAlive 且请求体可在限额内读完时返回 true，连接仍可继续使用
+func writeHTTPError(conn net.Conn, req *http.Request, code int, keepAlive bool) bool {
+	if keepAlive && req.Body != nil && req.Body != http.NoBody {
+		n, _ := io.CopyN(io.Discard, req.Body, maxHTTPDrain+1)
+		keepAlive = keepAlive && n <= maxHTTPDrain
+	}
+	resp := &http.Response{
+		StatusCode: code,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header:     make(http.Header),
+		Close:      !keepAlive,
+	}
+	if err := resp.Write(conn); err != nil {
+		return false
+	}
+	return keepAlive
+}
+
+// removeHopHeaders 删除逐跳头部以及 Connection 中列出的头部
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, f := range strings.Split(v, ",") {
+			if f = textproto.TrimString(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(textproto.TrimString(f), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withBuffered 把 bufio.Reader 中已读入但未消费的数据放回连接前面
+func withBuffered(c net.Conn, br *bufio.Reader) net.Conn {
+	n := br.Buffered()
+	if n == 0 {
+		return c
+	}
+	b, _ := br.Peek(n)
+	return &PeekConn{Conn: c, peeked: append([]byte(nil), b...)}
+}
+
+// httpUpstream 是一条到源站的连接及其响应读取缓冲
+type httpUpstream struct {
+	net.Conn
+	br *bufio.Reader
+
+	parkedAt time.Time   // 放回空闲表的时间
+	expiry   *time.Timer // 空闲超时后关闭连接
+}
+
+func (u *httpUpstream) roundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Write(u.Conn); err != nil {
+		return nil, err
+	}
+	for {
+		resp, err := http.ReadResponse(u.br, req)
+		if err != nil {
+			return nil, err
+		}
+		// 100 Continue 已由代理自行应答，其余 1xx 信息响应同样丢弃
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+		return resp, nil
+	}
+}
+
+var errHTTPDial = errors.New("dial failed")
+
+const (
+	// maxHTTPIdleHosts 每条客户端连接最多保留的空闲上游数，超出时关闭最早放回的一条
+	maxHTTPIdleHosts = 8
+	// httpIdleTimeout 空闲上游超过该时间未被复用即关闭
+	httpIdleTimeout = 30 * time.Second
+)
+
+// httpUpstreams 在一条客户端连接内按目标主机复用上游连接；请求串行处理，每个主机保留一条空闲连接
+type httpUpstreams struct {
+	routes *routeTable
+	dialer tunnel.Dialer
+	src    net.Addr
+
+	mu   sync.Mutex // 空闲超时的定时器在其它协程中移除条目
+	idle map[string]*httpUpstream
+}
+
+// get 取出 host 的空闲连接，没有时按路由新建；reused 表示连接之前用过。
+// 已被上游关闭（或收到了未请求数据）的空闲连接直接丢弃，改为新建
+func (p *httpUpstreams) get(host string, destIP net.IP) (*httpUpstream, bool, error) {
+	p.mu.Lock()
+	up := p.idle[host]
+	if up != nil {
+		delete(p.idle, host)
+		up.expiry.Stop()
+	}
+	p.mu.Unlock()
+	if up != nil {
+		if upstreamAlive(up) {
+			return up, true, nil
+		}
+		up.Close()
+	}
+	targetConn, ok := dialTarget(host, destIP, p.src, p.routes, p.dialer)
+	if !ok {
+		return nil, false, errHTTPDial
+	}
+	return &httpUpstream{Conn: targetConn, br: bufio.NewReader(targetConn)}, false, nil
+}
+
+// upstreamAlive 在复用前探测空闲连接：短暂读超时说明连接仍然空闲可用
+func upstreamAlive(up *httpUpstream) bool {
+	if up.br.Buffered() > 0 {
+		return false
+	}
+	if up.SetReadDeadline(time.Now().Add(time.Millisecond)) != nil {
+		// 已关闭的连接设置超时会报错
+		return false
+	}
+	_, err := up.br.Peek(1)
+	up.SetReadDeadline(time.Time{})
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func (p *httpUpstreams) put(host string, up *httpUpstream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old := p.idle[host]; old != nil {
+		old.expiry.Stop()
+		old.Close()
+		delete(p.idle, host)
+	}
+	if len(p.idle) >= maxHTTPIdleHosts {
+		var oldest string
+		for h, u := range p.idle {
+			if oldest == "" || u.parkedAt.Before(p.idle[oldest].parkedAt) {
+				oldest = h
+			}
+		}
+		p.idle[oldest].expiry.Stop()
+		p.idle[oldest].Close()
+		delete(p.idle, oldest)
+	}
+	up.parkedAt = time.Now()
+	up.expiry = time.AfterFunc(httpIdleTimeout, func() { p.expire(host, up) })
+	p.idle[host] = up
+}
+
+// expire 关闭超时仍未复用的空闲连接
+func (p *httpUpstreams) expire(host string, up *httpUpstream) {
+	p.mu.Lock()
+	if p.idle[host] != up {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.idle, host)
+	p.mu.Unlock()
+	up.Close()
+}
+
+func (p *httpUpstreams) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for host, up := range p.idle {
+		up.expiry.Stop()
+		up.Close()
+		delete(p.idle, host)
+	}
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/saba-futai/sudoku/internal/config"
+)
+
+func TestHTTPProxyKeepAliveRoutesEachRequest(t *testing.T) {
+	type seen struct {
+		host, path string
+		header     http.Header
+	}
+	var mu sync.Mutex
+	var requests []seen
+	newOrigin := func(name string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, seen{r.Host, r.URL.Path, r.Header.Clone()})
+			mu.Unlock()
+			w.Header().Set("Keep-Alive", "timeout=5")
+			fmt.Fprintf(w, "%s%s", name, r.URL.Path)
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	origins := map[string]string{
+		"a.test:80": newOrigin("a").Listener.Addr().String(),
+		"b.test:80": newOrigin("b").Listener.Addr().String(),
+	}
+
+	dials := make(map[string]int)
+	dialer := &MockDialer{DialFunc: func(addr string) (net.Conn, error) {
+		mu.Lock()
+		dials[addr]++
+		mu.Unlock()
+		return net.Dial("tcp", origins[addr])
+	}}
+	routes, err := buildRoutes(&config.Config{ProxyMode: "global"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("buildRoutes failed: %v", err)
+	}
+
+	client, proxy := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		handleHTTP(proxy, &config.Config{}, nil, routes, dialer)
+		close(done)
+	}()
+
+	br := bufio.NewReader(client)
+	roundTrip := func(raw, want string) *http.Response {
+		t.Helper()
+		if _, err := io.WriteString(client, raw); err != nil {
+			t.Fatalf("write request: %v", err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Fatalf("body = %q, want %q", body, want)
+		}
+		return resp
+	}
+
+	resp := roundTrip("GET http://a.test/one HTTP/1.1\r\nHost: a.test\r\nProxy-Connection: keep-alive\r\n"+
+		"Connection: X-Hop\r\nX-Hop: secret\r\n\r\n", "a/one")
+	if resp.Header.Get("Keep-Alive") != "" {
+		t.Fatalf("hop-by-hop response header forwarded: %v", resp.Header)
+	}
+	roundTrip("GET http://b.test/two HTTP/1.1\r\nHost: b.test\r\n\r\n", "b/two")
+	roundTrip("GET http://a.test/three HTTP/1.1\r\nHost: a.test\r\nConnection: close\r\n\r\n", "a/three")
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 3 || requests[1].host != "b.test" || requests[2].path != "/three" {
+		t.Fatalf("origin requests = %+v", requests)
+	}
+	for _, h := range []string{"Proxy-Connection", "X-Hop", "Connection", "User-Agent"} {
+		if v, ok := requests[0].header[h]; ok {
+			t.Fatalf("header %s=%q reached the origin", h, v)
+		}
+	}
+	if dials["a.test:80"] != 1 || dials["b.test:80"] != 1 {
+		t.Fatalf("upstream connections not reused: %v", dials)
+	}
+}
+
+func TestHTTPUpstreamsCapAndStaleConnections(t *testing.T) {
+	routes, err := buildRoutes(&config.Config{ProxyMode: "global"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("buildRoutes failed: %v", err)
+	}
+	var peers []net.Conn
+	dialer := &MockDialer{DialFunc: func(addr string) (net.Conn, error) {
+		c, peer := net.Pipe()
+		peers = append(peers, peer)
+		return c, nil
+	}}
+	pool := &httpUpstreams{routes: routes, dialer: dialer, idle: make(map[string]*httpUpstream)}
+	defer pool.closeAll()
+
+	// 超过上限时最早放回的连接被关闭
+	var first *httpUpstream
+	for i := 0; i <= maxHTTPIdleHosts; i++ {
+		host := fmt.Sprintf("h%d.test:80", i)
+		up, reused, err := pool.get(host, nil)
+		if err != nil || reused {
+			t.Fatalf("get %s: reused=%v err=%v", host, reused, err)
+		}
+		if i == 0 {
+			first = up
+		}
+		pool.put(host, up)
+	}
+	if len(pool.idle) != maxHTTPIdleHosts {
+		t.Fatalf("idle hosts = %d, want %d", len(pool.idle), maxHTTPIdleHosts)
+	}
+	if _, ok := pool.idle["h0.test:80"]; ok {
+		t.Fatalf("oldest idle upstream was not evicted")
+	}
+	if _, err := first.Write([]byte("x")); err == nil {
+		t.Fatalf("evicted upstream still open")
+	}
+
+	// 仍然空闲的连接可复用
+	if up, reused, _ := pool.get("h1.test:80", nil); !reused {
+		t.Fatalf("live idle upstream was not reused")
+	} else {
+		pool.put("h1.test:80", up)
+	}
+
+	// 上游已关闭的空闲连接被丢弃，改为新建
+	peers[2].Close()
+	dialsBefore := len(peers)
+	up, reused, err := pool.get("h2.test:80", nil)
+	if err != nil || reused || len(peers) != dialsBefore+1 {
+		t.Fatalf("stale upstream reused: reused=%v err=%v dials=%d", reused, err, len(peers)-dialsBefore)
+	}
+	up.Close()
+}
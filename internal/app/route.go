@@ -0,0 +1,304 @@
+// internal/app/route.go
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/dns"
+	"github.com/saba-futai/sudoku/internal/router"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+	"github.com/saba-futai/sudoku/pkg/geodata"
+	"github.com/saba-futai/sudoku/pkg/obfs/sudoku"
+)
+
+// routeTable 保存编译后的规则与各命名出口
+type routeTable struct {
+	rules     *router.Router
+	outbounds map[string]tunnel.Dialer
+	verbose   bool            // 是否逐连接打印路由结果（global/direct 模式下不打印）
+	fakeIP    *dns.FakeIPPool // 启用 Fake-IP 时用于把假地址还原为域名
+	geo       *geoData        // GEOIP/GEOSITE 数据，远程源在后台刷新
+}
+
+// Close 停止 GEOIP/GEOSITE 数据的后台刷新
+func (r *routeTable) Close() error {
+	if r.geo != nil {
+		return r.geo.Close()
+	}
+	return nil
+}
+
+// restoreFakeIP 把指向 Fake-IP 的目标还原为域名，使后续路由与隧道地址都使用域名；
+// 地址属于地址池但映射已被回收时返回 false
+func (r *routeTable) restoreFakeIP(destAddrStr string, destIP net.IP) (string, net.IP, bool) {
+	if r == nil || r.fakeIP == nil || destIP == nil || !r.fakeIP.Contains(destIP) {
+		return destAddrStr, destIP, true
+	}
+	domain, ok := r.fakeIP.Domain(destIP)
+	if !ok {
+		log.Printf("[FakeIP] %s has no mapping (expired?)", destAddrStr)
+		return destAddrStr, destIP, false
+	}
+	_, port, err := net.SplitHostPort(destAddrStr)
+	if err != nil {
+		return destAddrStr, destIP, false
+	}
+	return net.JoinHostPort(domain, port), nil, true
+}
+
+// buildRoutes 按 cfg.ProxyMode 构造路由：rule 模式编译 cfg.Rules，
+// global/direct 退化为单条 MATCH，pac 则在 MATCH,PROXY 之前插入 rule_urls 列表（命中走直连）。
+// resolve 为 IP 类规则解析域名，为空时使用系统解析器
+func buildRoutes(cfg *config.Config, geoMgr *geodata.Manager, outbounds map[string]tunnel.Dialer, resolve router.Resolver) (*routeTable, error) {
+	if resolve == nil {
+		resolve = resolveRouteIP
+	}
+	names := make([]string, 0, len(outbounds))
+	for name := range outbounds {
+		names = append(names, name)
+	}
+
+	var lines []string
+	switch cfg.ProxyMode {
+	case "rule":
+		lines = cfg.Rules
+	case "direct":
+		lines = []string{"MATCH,DIRECT"}
+	default:
+		lines = []string{"MATCH,PROXY"}
+	}
+	geo, err := loadGeoData(cfg, lines)
+	if err != nil {
+		return nil, err
+	}
+	opts := router.Options{Outbounds: names, Resolver: resolve}
+	if geo != nil {
+		opts.Geo = geo
+	}
+	rules, err := router.New(lines, opts)
+	if err != nil {
+		if geo != nil {
+			geo.Close()
+		}
+		return nil, err
+	}
+	if cfg.ProxyMode == "pac" && geoMgr != nil {
+		rules.Prepend(router.NewFuncRule("PAC", func(m *router.Metadata) bool {
+			if geoMgr.IsCN(m.Host, m.DstIP) {
+				return true
+			}
+			ip := m.ResolvedIP()
+			return ip != nil && geoMgr.IsCN(m.Host, ip)
+		}, router.TargetDirect))
+	}
+
+	return &routeTable{
+		rules:     rules,
+		outbounds: outbounds,
+		verbose:   cfg.ProxyMode == "rule" || cfg.ProxyMode == "pac",
+		geo:       geo,
+	}, nil
+}
+
+// geoData 把 geodata 的 GeoIP / GeoSite 适配为规则引擎所需的匹配器；远程数据在后台刷新后整体替换
+type geoData struct {
+	ip   atomic.Pointer[geodata.GeoIP]
+	site atomic.Pointer[geodata.GeoSite]
+
+	sources []*geodata.Dataset
+}
+
+func (g *geoData) GeoIP(code string) (func(net.IP) bool, error) {
+	ip := g.ip.Load()
+	if ip == nil {
+		return nil, fmt.Errorf("no geoip data configured")
+	}
+	if !ip.Has(code) {
+		return nil, fmt.Errorf("geoip code %q not found", code)
+	}
+	return func(addr net.IP) bool { return g.ip.Load().Match(code, addr) }, nil
+}
+
+func (g *geoData) GeoSite(code string) (func(string) bool, error) {
+	site := g.site.Load()
+	if site == nil {
+		return nil, fmt.Errorf("no geosite data configured")
+	}
+	if !site.Has(code) {
+		return nil, fmt.Errorf("geosite category %q not found", code)
+	}
+	return func(host string) bool { return g.site.Load().Match(code, host) }, nil
+}
+
+// Close 停止远程数据的后台刷新
+func (g *geoData) Close() error {
+	for _, src := range g.sources {
+		src.Close()
+	}
+	return nil
+}
+
+// loadGeoData 按规则中实际引用的代码加载 cfg.GeoIP / cfg.GeoSite；没有 GEOIP/GEOSITE 规则时不读取文件。
+// 本地文件与磁盘缓存同步读取，远程源随后在后台按 rule_update_interval 重新验证；调用方负责 Close
+func loadGeoData(cfg *config.Config, lines []string) (*geoData, error) {
+	ipCodes, siteCodes := router.GeoCodes(lines)
+	if len(ipCodes) == 0 && len(siteCodes) == 0 {
+		return nil, nil
+	}
+	geo := &geoData{}
+	interval := time.Duration(cfg.RuleUpdateInterval) * time.Second
+	if len(ipCodes) > 0 && cfg.GeoIP != "" {
+		src := geodata.NewDataset(cfg.GeoIP, cfg.RuleCacheDir, interval, func(b []byte) error {
+			g, err := geodata.LoadGeoIP(b, ipCodes)
+			if err == nil {
+				geo.ip.Store(g)
+			}
+			return err
+		})
+		if err := src.Start(); err != nil {
+			geo.Close()
+			return nil, fmt.Errorf("load geoip %s: %w", cfg.GeoIP, err)
+		}
+		geo.sources = append(geo.sources, src)
+	}
+	if len(siteCodes) > 0 && cfg.GeoSite != "" {
+		src := geodata.NewDataset(cfg.GeoSite, cfg.RuleCacheDir, interval, func(b []byte) error {
+			g, err := geodata.LoadGeoSite(b, siteCodes)
+			if err == nil {
+				geo.site.Store(g)
+			}
+			return err
+		})
+		if err := src.Start(); err != nil {
+			geo.Close()
+			return nil, fmt.Errorf("load geosite %s: %w", cfg.GeoSite, err)
+		}
+		geo.sources = append(geo.sources, src)
+	}
+	return geo, nil
+}
+
+// buildOutbounds 为 cfg.Outbounds 中的每个命名出口构造独立的拨号器；出错时关闭已构造的出口，成功时由调用方 closeOutbounds
+func buildOutbounds(cfg *config.Config, tables []*sudoku.Table, privateKey []byte) (map[string]tunnel.Dialer, error) {
+	outbounds := make(map[string]tunnel.Dialer, len(cfg.Outbounds))
+	names := make([]string, 0, len(cfg.Outbounds))
+	for name := range cfg.Outbounds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dialer, err := buildOutbound(cfg, name, tables, privateKey)
+		if err != nil {
+			closeOutbounds(outbounds)
+			return nil, err
+		}
+		outbounds[name] = dialer
+	}
+	return outbounds, nil
+}
+
+// buildOutbound 校验出口名并为其服务器列表构造拨号器
+func buildOutbound(cfg *config.Config, name string, tables []*sudoku.Table, privateKey []byte) (tunnel.Dialer, error) {
+	switch name {
+	case router.TargetProxy, router.TargetDirect, router.TargetReject:
+		return nil, fmt.Errorf("outbound name %q is reserved", name)
+	}
+	servers := cfg.Outbounds[name]
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("outbound %q has no servers", name)
+	}
+	dialer, err := newGroupDialer(cfg, servers, tables, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("outbound %q: %w", name, err)
+	}
+	return dialer, nil
+}
+
+// closeOutbounds 停止各命名出口的健康检查与连接池
+func closeOutbounds(outbounds map[string]tunnel.Dialer) {
+	for _, d := range outbounds {
+		closeDialer(d)
+	}
+}
+
+// resolveRouteIP 为 IP 类规则解析域名（IPv4 优先，支持纯 IPv6 域名），优先使用缓存
+func resolveRouteIP(host string) net.IP {
+	if cachedIP := globalDNSCache.Lookup(host); cachedIP != nil {
+		return cachedIP
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	cancel()
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	// 双栈域名优先按 IPv4 匹配，仅有 AAAA 记录时使用 IPv6
+	ip := ips[0]
+	for _, candidate := range ips {
+		if candidate.To4() != nil {
+			ip = candidate
+			break
+		}
+	}
+	globalDNSCache.Set(host, ip)
+	return ip
+}
+
+// dialTarget 按路由规则选择出口并建立连接；REJECT 或拨号失败时返回 false
+func dialTarget(destAddrStr string, destIP net.IP, src net.Addr, routes *routeTable, dialer tunnel.Dialer) (net.Conn, bool) {
+	target := routeTarget(destAddrStr, destIP, src, routes)
+	switch target {
+	case router.TargetReject:
+		return nil, false
+	case router.TargetDirect:
+		dConn, err := net.DialTimeout("tcp", destAddrStr, 5*time.Second)
+		if err != nil {
+			log.Printf("[Direct] Dial Failed: %v", err)
+			return nil, false
+		}
+		return dConn, true
+	}
+
+	conn, err := routes.outbound(target, dialer).Dial(destAddrStr)
+	if err != nil {
+		log.Printf("[Proxy] Dial Failed (%s): %v", target, err)
+		return nil, false
+	}
+	return conn, true
+}
+
+// routeTarget 返回匹配的出口名，无规则时为 PROXY
+func routeTarget(destAddrStr string, destIP net.IP, src net.Addr, routes *routeTable) string {
+	target := router.TargetProxy
+	if routes != nil {
+		meta := router.NewMetadata(destAddrStr, destIP, src)
+		if rule := routes.rules.Match(meta); rule != nil {
+			target = rule.Target
+			if routes.verbose {
+				if meta.DstIP != nil && meta.Host != "" {
+					log.Printf("[Rule] %s (%s) -> %s (%s)", destAddrStr, meta.DstIP, target, rule)
+				} else {
+					log.Printf("[Rule] %s -> %s (%s)", destAddrStr, target, rule)
+				}
+			}
+		} else if routes.verbose {
+			log.Printf("[Rule] %s -> PROXY (Default)", destAddrStr)
+		}
+	}
+	return target
+}
+
+// outbound 返回代理类出口对应的拨号器：PROXY 为主拨号器，其余为具名出站
+func (r *routeTable) outbound(target string, dialer tunnel.Dialer) tunnel.Dialer {
+	if target == router.TargetProxy || r == nil {
+		return dialer
+	}
+	return r.outbounds[target]
+}
@@ -0,0 +1,81 @@
+package app
+
+import (
+	"net"
+	"testing"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+)
+
+func TestDialTargetFollowsRules(t *testing.T) {
+	// A local listener stands in for a directly reachable target.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	var proxied, viaUS []string
+	proxy := &MockDialer{DialFunc: func(addr string) (net.Conn, error) {
+		proxied = append(proxied, addr)
+		return NewMockConn(nil), nil
+	}}
+	us := &MockDialer{DialFunc: func(addr string) (net.Conn, error) {
+		viaUS = append(viaUS, addr)
+		return NewMockConn(nil), nil
+	}}
+
+	cfg := &config.Config{
+		ProxyMode: "rule",
+		Rules: []string{
+			"DOMAIN-SUFFIX,ads.example,REJECT",
+			"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+			"DOMAIN-SUFFIX,netflix.com,us",
+			"MATCH,PROXY",
+		},
+	}
+	routes, err := buildRoutes(cfg, nil, map[string]tunnel.Dialer{"us": us}, nil)
+	if err != nil {
+		t.Fatalf("buildRoutes failed: %v", err)
+	}
+	src := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000}
+
+	if _, ok := dialTarget("x.ads.example:443", nil, src, routes, proxy); ok {
+		t.Fatalf("REJECT rule should fail the dial")
+	}
+	conn, ok := dialTarget(ln.Addr().String(), nil, src, routes, proxy)
+	if !ok {
+		t.Fatalf("DIRECT dial failed")
+	}
+	conn.Close()
+	if _, ok := dialTarget("www.netflix.com:443", nil, src, routes, proxy); !ok {
+		t.Fatalf("outbound dial failed")
+	}
+	if _, ok := dialTarget("example.org:443", nil, src, routes, proxy); !ok {
+		t.Fatalf("proxy dial failed")
+	}
+
+	if len(viaUS) != 1 || viaUS[0] != "www.netflix.com:443" {
+		t.Fatalf("named outbound got %v", viaUS)
+	}
+	if len(proxied) != 1 || proxied[0] != "example.org:443" {
+		t.Fatalf("default proxy got %v", proxied)
+	}
+}
+
+func TestBuildRoutesRejectsUnknownOutbound(t *testing.T) {
+	cfg := &config.Config{ProxyMode: "rule", Rules: []string{"DOMAIN,example.com,jp", "MATCH,PROXY"}}
+	if _, err := buildRoutes(cfg, nil, nil, nil); err == nil {
+		t.Fatalf("rule targeting an undefined outbound should fail")
+	}
+}
@@ -16,25 +16,35 @@ import (
 )
 
 func RunServer(cfg *config.Config, tables []*sudoku.Table) {
+	// 0. 按用户鉴权
+	auth, err := tunnel.NewServerAuth(cfg)
+	if err != nil {
+		log.Fatalf("Invalid users: %v", err)
+	}
+
 	// 1. 监听 TCP 端口
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.LocalPort))
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Printf("Server on :%d (Fallback: %s)", cfg.LocalPort, cfg.FallbackAddr)
+	if auth.Required() {
+		log.Printf("Server requires user identity (%d users)", len(cfg.Users))
+	}
+	go auth.Revocations().Watch(5 * time.Second)
 
 	for {
 		c, err := l.Accept()
 		if err != nil {
 			continue
 		}
-		go handleServerConn(c, cfg, tables)
+		go handleServerConn(c, cfg, tables, auth)
 	}
 }
 
-func handleServerConn(rawConn net.Conn, cfg *config.Config, tables []*sudoku.Table) {
+func handleServerConn(rawConn net.Conn, cfg *config.Config, tables []*sudoku.Table, auth *tunnel.ServerAuth) {
 	// Use Tunnel Abstraction for Handshake and Upgrade
-	tunnelConn, err := tunnel.HandshakeAndUpgradeWithTables(rawConn, cfg, tables)
+	tunnelConn, userID, err := tunnel.HandshakeAndUpgradeWithAuth(rawConn, cfg, tables, auth)
 	if err != nil {
 		if suspErr, ok := err.(*tunnel.SuspiciousError); ok {
 			log.Printf("[Security] Suspicious connection: %v", suspErr.Err)
@@ -46,21 +56,55 @@ func handleServerConn(rawConn net.Conn, cfg *config.Config, tables []*sudoku.Tab
 		return
 	}
 
+	serveTunnelConn(tunnelConn, cfg, userID, true)
+}
+
+// serveTunnelConn handles one upgraded tunnel connection or one mux stream inside it.
+// The first byte selects UoT, BIND, a health probe, mux (only on the outer connection) or a plain target address.
+func serveTunnelConn(tunnelConn net.Conn, cfg *config.Config, userID string, allowMux bool) {
 	// ==========================================
 	// 5. 连接目标地址
 	// ==========================================
 
-	// 判断是否为 UoT (UDP over TCP) 会话
+	// 判断是否为 UoT (UDP over TCP) / BIND / 健康探测 / Mux 会话
 	firstByte := make([]byte, 1)
 	if _, err := io.ReadFull(tunnelConn, firstByte); err != nil {
 		log.Printf("[Server] Failed to read first byte: %v", err)
+		tunnelConn.Close()
 		return
 	}
 
 	if firstByte[0] == tunnel.UoTMagicByte {
 		if err := tunnel.HandleUoTServer(tunnelConn); err != nil {
-			log.Printf("[Server][UoT] session ended: %v", err)
+			log.Printf("[Server][UoT]%s session ended: %v", userTag(userID), err)
+		}
+		return
+	}
+
+	if firstByte[0] == tunnel.BindMagicByte {
+		peer, err := tunnel.HandleBindServer(tunnelConn, cfg.AllowBind, net.ParseIP(cfg.BindAdvertise))
+		if err != nil {
+			log.Printf("[Server][Bind]%s failed: %v", userTag(userID), err)
+			tunnelConn.Close()
+			return
 		}
+		log.Printf("[Server][Bind]%s Accepted %s", userTag(userID), peer.RemoteAddr())
+		pipeConn(tunnelConn, peer)
+		return
+	}
+
+	if firstByte[0] == tunnel.PingMagicByte {
+		if err := tunnel.HandlePingServer(tunnelConn); err != nil {
+			log.Printf("[Server][Ping]%s failed: %v", userTag(userID), err)
+		}
+		return
+	}
+
+	if firstByte[0] == tunnel.MuxMagicByte && allowMux {
+		err := tunnel.HandleMuxServer(tunnelConn, func(stream net.Conn) {
+			serveTunnelConn(stream, cfg, userID, false)
+		})
+		log.Printf("[Server][Mux]%s session ended: %v", userTag(userID), err)
 		return
 	}
 
@@ -71,14 +115,16 @@ func handleServerConn(rawConn net.Conn, cfg *config.Config, tables []*sudoku.Tab
 	destAddrStr, _, _, err := protocol.ReadAddress(prefixedConn)
 	if err != nil {
 		log.Printf("[Server] Failed to read target address: %v", err)
+		tunnelConn.Close()
 		return
 	}
 
-	log.Printf("[Server] Connecting to %s", destAddrStr)
+	log.Printf("[Server]%s Connecting to %s", userTag(userID), destAddrStr)
 
 	target, err := net.DialTimeout("tcp", destAddrStr, 10*time.Second)
 	if err != nil {
 		log.Printf("[Server] Connect target failed: %v", err)
+		tunnelConn.Close()
 		return
 	}
 
@@ -87,3 +133,11 @@ func handleServerConn(rawConn net.Conn, cfg *config.Config, tables []*sudoku.Tab
 	// ==========================================
 	pipeConn(prefixedConn, target)
 }
+
+// userTag formats the authenticated user for log lines.
+func userTag(userID string) string {
+	if userID == "" {
+		return ""
+	}
+	return "[" + userID + "]"
+}
@@ -0,0 +1,48 @@
+// internal/app/sniff.go
+package app
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/sniff"
+)
+
+// sniffTimeout 客户端收到 SOCKS 成功应答后通常立即发送首包；服务端先发言的协议（SSH、SMTP 等）
+// 等到超时后按原 IP 处理
+const sniffTimeout = 300 * time.Millisecond
+
+// sniffTarget 读取客户端首包，从 TLS SNI 或 HTTP Host 中提取域名并替换 destAddrStr 的主机部分。
+// 返回的连接会先回放已读取的字节（同 PeekConn），调用方之后应只使用它
+func sniffTarget(conn net.Conn, destAddrStr string) (net.Conn, string) {
+	_, port, err := net.SplitHostPort(destAddrStr)
+	if err != nil {
+		return conn, destAddrStr
+	}
+
+	buf := make([]byte, 0, 2048)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	for len(buf) < sniff.MaxSize {
+		if len(buf) == cap(buf) {
+			buf = slices.Grow(buf, min(cap(buf), sniff.MaxSize-len(buf)))
+		}
+		n, readErr := conn.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		domain, sniffErr := sniff.Domain(buf)
+		if sniffErr == nil {
+			destAddrStr = net.JoinHostPort(domain, port)
+			break
+		}
+		if !errors.Is(sniffErr, sniff.ErrNeedMore) || readErr != nil {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if len(buf) == 0 {
+		return conn, destAddrStr
+	}
+	return &PeekConn{Conn: conn, peeked: buf}, destAddrStr
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"net"
+	"testing"
+
+	"github.com/saba-futai/sudoku/internal/config"
+)
+
+func TestSocks5SniffReaddressesIPTarget(t *testing.T) {
+	request := "GET / HTTP/1.1\r\nHost: www.example.org\r\n\r\n"
+	cases := []struct {
+		name    string
+		sniff   bool
+		payload string
+		want    string
+	}{
+		{"http host", true, request, "www.example.org:80"},
+		{"disabled", false, request, "93.184.216.34:80"},
+		{"server speaks first", true, "", "93.184.216.34:80"},
+		{"not tls or http", true, "\x00\x01binary", "93.184.216.34:80"},
+	}
+	for _, tc := range cases {
+		input := []byte{
+			0x05, 0x01, 0x00,
+			0x05, 0x01, 0x00, 0x01, 93, 184, 216, 34, 0, 80,
+		}
+		input = append(input, tc.payload...)
+		conn := NewMockConn(input)
+
+		var target string
+		upstream := NewMockConn(nil)
+		dialer := &MockDialer{DialFunc: func(addr string) (net.Conn, error) {
+			target = addr
+			return upstream, nil
+		}}
+		handleClientSocks5(conn, &config.Config{ProxyMode: "global", Sniff: tc.sniff}, nil, nil, dialer)
+
+		if target != tc.want {
+			t.Fatalf("%s: dialed %q, want %q", tc.name, target, tc.want)
+		}
+		// 嗅探读取的首包必须原样回放给上游
+		if got := upstream.WriteBuf.String(); got != tc.payload {
+			t.Fatalf("%s: upstream received %q, want %q", tc.name, got, tc.payload)
+		}
+		// 方法协商应答 (2 字节) + 一个成功应答 (10 字节)
+		if resp := conn.WriteBuf.Bytes(); len(resp) != 12 || resp[3] != 0x00 {
+			t.Fatalf("%s: expected exactly one success reply, got %v", tc.name, resp)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+// internal/app/transparent.go
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/router"
+	"github.com/saba-futai/sudoku/internal/tproxy"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+)
+
+// udpIdleTimeout 透明 UDP 会话无流量超过该时间后关闭
+const udpIdleTimeout = 60 * time.Second
+
+// startTransparent 按配置启动 REDIRECT（redir_port）与 TPROXY（tproxy_port，TCP+UDP）监听，与混合端口一样绑定 local_listen
+func startTransparent(cfg *config.Config, routes *routeTable, dialer tunnel.Dialer) error {
+	if cfg.RedirPort > 0 {
+		addr := net.JoinHostPort(cfg.LocalListen, strconv.Itoa(cfg.RedirPort))
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("redir listen: %w", err)
+		}
+		log.Printf("[Redir] Listening on %s (iptables REDIRECT)", addr)
+		go serveTransparentTCP(l, tproxy.OriginalDst, cfg, routes, dialer)
+	}
+	if cfg.TProxyPort > 0 {
+		addr := net.JoinHostPort(cfg.LocalListen, strconv.Itoa(cfg.TProxyPort))
+		l, err := tproxy.ListenTCP(addr)
+		if err != nil {
+			return fmt.Errorf("tproxy tcp listen: %w", err)
+		}
+		pc, err := tproxy.ListenUDP(addr)
+		if err != nil {
+			l.Close()
+			return fmt.Errorf("tproxy udp listen: %w", err)
+		}
+		log.Printf("[TProxy] Listening on %s (TCP+UDP)", addr)
+		// TPROXY 连接的本地地址就是原始目标
+		go serveTransparentTCP(l, func(c net.Conn) (*net.TCPAddr, error) {
+			addr, ok := c.LocalAddr().(*net.TCPAddr)
+			if !ok {
+				return nil, errors.New("not a TCP connection")
+			}
+			return addr, nil
+		}, cfg, routes, dialer)
+		go newTransparentUDP(routes, dialer).serve(pc)
+	}
+	return nil
+}
+
+func serveTransparentTCP(l net.Listener, origDst func(net.Conn) (*net.TCPAddr, error), cfg *config.Config, routes *routeTable, dialer tunnel.Dialer) {
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// 文件描述符耗尽等错误会持续出现，退避避免空转
+			delay = retryDelay(delay)
+			log.Printf("[Transparent] accept: %v; retrying in %v", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go func() {
+			dst, err := origDst(c)
+			if err != nil {
+				log.Printf("[Transparent] %s: no original destination: %v", c.RemoteAddr(), err)
+				c.Close()
+				return
+			}
+			handleTransparentTCP(c, dst, cfg, routes, dialer)
+		}()
+	}
+}
+
+// retryDelay 返回下一次重试前的等待时间：从 5ms 开始翻倍，最长 1s
+func retryDelay(prev time.Duration) time.Duration {
+	if prev == 0 {
+		return 5 * time.Millisecond
+	}
+	if prev *= 2; prev > time.Second {
+		return time.Second
+	}
+	return prev
+}
+
+// handleTransparentTCP 与 SOCKS 入口走相同的 Fake-IP 还原、嗅探与路由流程
+func handleTransparentTCP(conn net.Conn, dst *net.TCPAddr, cfg *config.Config, routes *routeTable, dialer tunnel.Dialer) {
+	defer conn.Close()
+
+	destAddrStr, destIP, ok := routes.restoreFakeIP(dst.String(), dst.IP)
+	if !ok {
+		return
+	}
+	if cfg.Sniff && destIP != nil {
+		conn, destAddrStr = sniffTarget(conn, destAddrStr)
+	}
+	targetConn, ok := dialTarget(destAddrStr, destIP, conn.RemoteAddr(), routes, dialer)
+	if !ok {
+		return
+	}
+	pipeConn(conn, targetConn)
+}
+
+// packetRelay 是一个 UDP 会话的上游：经隧道（UoT）或直连
+type packetRelay interface {
+	WritePacket(payload []byte) error
+	ReadPacket() ([]byte, error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+type uotRelay struct {
+	net.Conn
+	addr string
+}
+
+func (r *uotRelay) WritePacket(payload []byte) error {
+	return tunnel.WriteUoTDatagram(r.Conn, r.addr, payload)
+}
+
+func (r *uotRelay) ReadPacket() ([]byte, error) {
+	_, payload, err := tunnel.ReadUoTDatagram(r.Conn)
+	return payload, err
+}
+
+type directRelay struct {
+	net.Conn
+}
+
+func (r *directRelay) WritePacket(payload []byte) error {
+	_, err := r.Conn.Write(payload)
+	return err
+}
+
+func (r *directRelay) ReadPacket() ([]byte, error) {
+	buf := make([]byte, 65535)
+	n, err := r.Conn.Read(buf)
+	return buf[:n], err
+}
+
+// transparentUDP 按 (客户端, 原始目标) 维护会话：每个会话一条上游，
+// 回包经绑定在原始目标地址上的透明 socket 发回，客户端看到的来源与其发往的地址一致。
+// 上游在独立协程中建立，handlePacket 只入队或写出，慢速拨号不会阻塞其它会话
+type transparentUDP struct {
+	routes *routeTable
+	dialer tunnel.Dialer
+	// dialReply 创建从 from 发往 to 的回包通道，默认 tproxy.DialUDP；TUN 模式与测试中替换
+	dialReply func(from, to *net.UDPAddr) (io.WriteCloser, error)
+
+	mu       sync.Mutex
+	sessions map[string]*transparentUDPSession
+}
+
+// maxPendingUDP 会话建立期间每个会话最多缓存的数据报数，超出后丢弃
+const maxPendingUDP = 16
+
+type transparentUDPSession struct {
+	// relay/reply 在建立完成前为 nil，期间的数据报缓存在 pending；三者均受 transparentUDP.mu 保护
+	relay   packetRelay
+	reply   io.WriteCloser
+	pending [][]byte
+
+	writeMu    sync.Mutex // 串行化上游写入，保证缓存的数据报先于后续数据报发出
+	lastActive atomic.Int64
+	closeOnce  sync.Once
+}
+
+func newTransparentUDP(routes *routeTable, dialer tunnel.Dialer) *transparentUDP {
+	return &transparentUDP{
+		routes: routes,
+		dialer: dialer,
+		dialReply: func(from, to *net.UDPAddr) (io.WriteCloser, error) {
+			return tproxy.DialUDP(from, to)
+		},
+		sessions: make(map[string]*transparentUDPSession),
+	}
+}
+
+func (t *transparentUDP) serve(pc *net.UDPConn) {
+	defer pc.Close()
+	buf := make([]byte, 65535)
+	var delay time.Duration
+	for {
+		n, src, dst, err := tproxy.ReadFromUDP(pc, buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			delay = retryDelay(delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		t.handlePacket(src, dst, append([]byte(nil), buf[:n]...))
+	}
+}
+
+// handlePacket 把数据报交给对应会话；新会话先登记并缓存数据报，上游在后台建立
+func (t *transparentUDP) handlePacket(src, dst *net.UDPAddr, payload []byte) {
+	key := src.String() + "|" + dst.String()
+	t.mu.Lock()
+	s, ok := t.sessions[key]
+	if !ok {
+		s = &transparentUDPSession{pending: [][]byte{payload}}
+		s.lastActive.Store(time.Now().UnixNano())
+		t.sessions[key] = s
+		t.mu.Unlock()
+		go t.connect(key, s, src, dst)
+		return
+	}
+	relay := s.relay
+	if relay == nil {
+		if len(s.pending) < maxPendingUDP {
+			s.pending = append(s.pending, payload)
+		}
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+
+	s.lastActive.Store(time.Now().UnixNano())
+	s.writeMu.Lock()
+	err := relay.WritePacket(payload)
+	s.writeMu.Unlock()
+	if err != nil {
+		t.closeSession(key, s)
+	}
+}
+
+// connect 建立会话上游，成功后发出缓存的数据报并开始转发回包；失败时移除会话
+func (t *transparentUDP) connect(key string, s *transparentUDPSession, src, dst *net.UDPAddr) {
+	relay, reply, err := t.open(src, dst)
+	if err != nil {
+		if err != errRejected {
+			log.Printf("[TProxy][UDP] %s -> %s: %v", src, dst, err)
+		}
+		t.mu.Lock()
+		if t.sessions[key] == s {
+			delete(t.sessions, key)
+		}
+		t.mu.Unlock()
+		return
+	}
+
+	// 先持有 writeMu 再公开 relay，后续数据报只能排在缓存之后写出
+	s.writeMu.Lock()
+	t.mu.Lock()
+	s.relay, s.reply = relay, reply
+	pending := s.pending
+	s.pending = nil
+	t.mu.Unlock()
+	go t.pipeReplies(key, s)
+
+	for _, p := range pending {
+		if err := relay.WritePacket(p); err != nil {
+			s.writeMu.Unlock()
+			t.closeSession(key, s)
+			return
+		}
+	}
+	s.writeMu.Unlock()
+}
+
+var errRejected = errors.New("rejected by rule")
+
+// open 路由新会话并建立上游与回包连接
+func (t *transparentUDP) open(src, dst *net.UDPAddr) (packetRelay, io.WriteCloser, error) {
+	destAddrStr, destIP, ok := t.routes.restoreFakeIP(dst.String(), dst.IP)
+	if !ok {
+		return nil, nil, errRejected
+	}
+	target := router.TargetProxy
+	if t.routes != nil {
+		if rule := t.routes.rules.Match(router.NewMetadata(destAddrStr, destIP, src)); rule != nil {
+			target = rule.Target
+		}
+		if t.routes.verbose {
+			log.Printf("[TProxy][UDP] %s -> %s", destAddrStr, target)
+		}
+	}
+
+	var relay packetRelay
+	switch target {
+	case router.TargetReject:
+		return nil, nil, errRejected
+	case router.TargetDirect:
+		conn, err := net.Dial("udp", destAddrStr)
+		if err != nil {
+			return nil, nil, err
+		}
+		relay = &directRelay{Conn: conn}
+	default:
+		dialer := t.dialer
+		if target != router.TargetProxy {
+			dialer = t.routes.outbounds[target]
+		}
+		uot, ok := dialer.(tunnel.UoTDialer)
+		if !ok {
+			return nil, nil, fmt.Errorf("outbound %s does not support UDP", target)
+		}
+		conn, err := uot.DialUDPOverTCP()
+		if err != nil {
+			return nil, nil, err
+		}
+		relay = &uotRelay{Conn: conn, addr: destAddrStr}
+	}
+
+	reply, err := t.dialReply(dst, src)
+	if err != nil {
+		relay.Close()
+		return nil, nil, err
+	}
+	return relay, reply, nil
+}
+
+func (t *transparentUDP) pipeReplies(key string, s *transparentUDPSession) {
+	defer t.closeSession(key, s)
+	for {
+		s.relay.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		payload, err := s.relay.ReadPacket()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && time.Since(time.Unix(0, s.lastActive.Load())) < udpIdleTimeout {
+				continue
+			}
+			return
+		}
+		s.lastActive.Store(time.Now().UnixNano())
+		if _, err := s.reply.Write(payload); err != nil {
+			return
+		}
+	}
+}
+
+func (t *transparentUDP) closeSession(key string, s *transparentUDPSession) {
+	s.closeOnce.Do(func() {
+		t.mu.Lock()
+		if t.sessions[key] == s {
+			delete(t.sessions, key)
+		}
+		t.mu.Unlock()
+		s.relay.Close()
+		s.reply.Close()
+	})
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/dns"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+)
+
+func TestTransparentTCPRestoresFakeIP(t *testing.T) {
+	pool, _ := dns.NewFakeIPPool("198.18.0.0/15")
+	fake := pool.IP("app.example")
+	cfg := &config.Config{ProxyMode: "global"}
+	routes, err := buildRoutes(cfg, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("buildRoutes failed: %v", err)
+	}
+	routes.fakeIP = pool
+
+	var target string
+	dialer := &MockDialer{DialFunc: func(addr string) (net.Conn, error) {
+		target = addr
+		return NewMockConn(nil), nil
+	}}
+	handleTransparentTCP(NewMockConn([]byte("hello")), &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 8443}, cfg, routes, dialer)
+	if target != "203.0.113.9:8443" {
+		t.Fatalf("REDIRECT target = %q", target)
+	}
+	handleTransparentTCP(NewMockConn([]byte("hello")), &net.TCPAddr{IP: fake, Port: 443}, cfg, routes, dialer)
+	if target != "app.example:443" {
+		t.Fatalf("fake-ip target = %q", target)
+	}
+}
+
+// uotEchoDialer echoes every UoT datagram back, as if the remote peer answered.
+type uotEchoDialer struct {
+	MockDialer
+	dials atomic.Int32
+	addrs chan string
+}
+
+func (d *uotEchoDialer) DialUDPOverTCP() (net.Conn, error) {
+	d.dials.Add(1)
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		for {
+			addr, payload, err := tunnel.ReadUoTDatagram(server)
+			if err != nil {
+				return
+			}
+			d.addrs <- addr
+			if err := tunnel.WriteUoTDatagram(server, addr, payload); err != nil {
+				return
+			}
+		}
+	}()
+	return client, nil
+}
+
+func TestTransparentUDPSessions(t *testing.T) {
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer client.Close()
+	src := client.LocalAddr().(*net.UDPAddr)
+
+	cfg := &config.Config{ProxyMode: "rule", Rules: []string{"DST-PORT,9999,REJECT", "MATCH,PROXY"}}
+	routes, err := buildRoutes(cfg, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("buildRoutes failed: %v", err)
+	}
+	dialer := &uotEchoDialer{addrs: make(chan string, 8)}
+	udp := newTransparentUDP(routes, dialer)
+	// 测试环境无法伪造来源地址，回包改由普通 socket 发出
+	udp.dialReply = func(from, to *net.UDPAddr) (io.WriteCloser, error) {
+		return net.DialUDP("udp", nil, to)
+	}
+
+	dst := &net.UDPAddr{IP: net.ParseIP("203.0.113.53"), Port: 53}
+	udp.handlePacket(src, dst, []byte("one"))
+	udp.handlePacket(src, dst, []byte("two"))
+	udp.handlePacket(src, &net.UDPAddr{IP: net.ParseIP("203.0.113.53"), Port: 9999}, []byte("dropped"))
+
+	buf := make([]byte, 64)
+	for _, want := range []string{"one", "two"} {
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := client.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", want, err)
+		}
+		if string(buf[:n]) != want {
+			t.Fatalf("got %q, want %q", buf[:n], want)
+		}
+		if addr := <-dialer.addrs; addr != "203.0.113.53:53" {
+			t.Fatalf("UoT datagram sent to %s", addr)
+		}
+	}
+	if n := dialer.dials.Load(); n != 1 {
+		t.Fatalf("expected one UoT session for one (client, destination) pair, got %d", n)
+	}
+	// 会话在后台建立，被拒绝的会话随后移除
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		udp.mu.Lock()
+		sessions := len(udp.sessions)
+		udp.mu.Unlock()
+		if sessions == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rejected destination opened a session (%d sessions)", sessions)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// blockingUoTDialer never completes a dial until release is closed.
+type blockingUoTDialer struct {
+	uotEchoDialer
+	release chan struct{}
+}
+
+func (d *blockingUoTDialer) DialUDPOverTCP() (net.Conn, error) {
+	<-d.release
+	return d.uotEchoDialer.DialUDPOverTCP()
+}
+
+func TestTransparentUDPSlowDialDoesNotBlock(t *testing.T) {
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer client.Close()
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+	src := client.LocalAddr().(*net.UDPAddr)
+	direct := echo.LocalAddr().(*net.UDPAddr)
+
+	cfg := &config.Config{ProxyMode: "rule", Rules: []string{"IP-CIDR,127.0.0.0/8,DIRECT", "MATCH,PROXY"}}
+	routes, err := buildRoutes(cfg, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("buildRoutes failed: %v", err)
+	}
+	dialer := &blockingUoTDialer{uotEchoDialer: uotEchoDialer{addrs: make(chan string, 8)}, release: make(chan struct{})}
+	udp := newTransparentUDP(routes, dialer)
+	udp.dialReply = func(from, to *net.UDPAddr) (io.WriteCloser, error) {
+		return net.DialUDP("udp", nil, to)
+	}
+
+	// 代理会话的拨号被挂起，返回前 handlePacket 不得阻塞
+	proxied := &net.UDPAddr{IP: net.ParseIP("203.0.113.53"), Port: 53}
+	done := make(chan struct{})
+	go func() {
+		udp.handlePacket(src, proxied, []byte("first"))
+		udp.handlePacket(src, proxied, []byte("second"))
+		udp.handlePacket(src, direct, []byte("direct"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handlePacket blocked on a pending dial")
+	}
+
+	buf := make([]byte, 64)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, _, err := client.ReadFromUDP(buf); err != nil || string(buf[:n]) != "direct" {
+		t.Fatalf("direct session stalled behind pending dial: %q, %v", buf[:n], err)
+	}
+
+	// 拨号完成后，缓存的数据报按顺序发出
+	close(dialer.release)
+	for _, want := range []string{"first", "second"} {
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := client.ReadFromUDP(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("got %q, %v; want %q", buf[:n], err, want)
+		}
+	}
+}
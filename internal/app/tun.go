@@ -0,0 +1,68 @@
+// internal/app/tun.go
+package app
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/tun"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+)
+
+// startTun 打开 TUN 设备并在用户态协议栈上终结 TCP/UDP，流量与透明代理入口走相同的路由流程
+func startTun(cfg *config.Config, routes *routeTable, dialer tunnel.Dialer) error {
+	if cfg.Tun == "" {
+		return nil
+	}
+	mtu := cfg.TunMTU
+	if mtu <= 0 {
+		mtu = tun.DefaultMTU
+	}
+	dev, name, err := tun.Open(cfg.Tun, mtu)
+	if err != nil {
+		return err
+	}
+	stack := newTunStack(dev, mtu, cfg, routes, dialer)
+	log.Printf("[TUN] Device %s up (MTU %d)", name, mtu)
+	go func() {
+		if err := stack.Run(); err != nil {
+			log.Printf("[TUN] Device %s stopped: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+func newTunStack(dev io.ReadWriter, mtu int, cfg *config.Config, routes *routeTable, dialer tunnel.Dialer) *tun.Stack {
+	udp := newTransparentUDP(routes, dialer)
+	stack := tun.NewStack(dev, tun.Options{
+		MTU: mtu,
+		// 协议栈连接的本地地址即应用访问的原始目标
+		HandleTCP: func(c net.Conn) {
+			handleTransparentTCP(c, c.LocalAddr().(*net.TCPAddr), cfg, routes, dialer)
+		},
+		// handlePacket 只入队或写出，新会话的上游在后台建立，不会阻塞协议栈读包
+		HandleUDP: udp.handlePacket,
+	})
+	udp.dialReply = func(from, to *net.UDPAddr) (io.WriteCloser, error) {
+		return &tunUDPReply{stack: stack, from: from, to: to}, nil
+	}
+	return stack
+}
+
+// tunUDPReply 把会话回包写回 TUN，来源伪装为原始目标
+type tunUDPReply struct {
+	stack    *tun.Stack
+	from, to *net.UDPAddr
+}
+
+func (r *tunUDPReply) Write(p []byte) (int, error) {
+	if err := r.stack.WriteUDP(r.from, r.to, p); err != nil {
+		return 0, fmt.Errorf("tun reply: %w", err)
+	}
+	return len(p), nil
+}
+
+func (r *tunUDPReply) Close() error { return nil }
@@ -0,0 +1,65 @@
+// internal/app/udpfrag.go
+package app
+
+import "time"
+
+// socks5FragTimeout 分片重组计时器，RFC 1928 要求不少于 5 秒
+const socks5FragTimeout = 5 * time.Second
+
+// maxReassembledUDP 重组后的数据报上限（UoT 单帧负载上限）
+const maxReassembledUDP = 65535
+
+// udpReassembler 按 RFC 1928 §7 重组来自同一客户端的 UDP 分片：FRAG 低 7 位为从 1 开始的序号，
+// 最高位标记最后一个分片。序号回退、跳号或目标改变时放弃当前队列，计时器超时同样放弃。
+type udpReassembler struct {
+	dest     string
+	next     byte // 期望的下一个分片序号，0 表示队列为空
+	payload  []byte
+	deadline time.Time
+}
+
+// add 处理一个数据报；数据报完整时返回其目标与负载
+func (r *udpReassembler) add(frag byte, dest string, payload []byte, now time.Time) (string, []byte, bool) {
+	if frag == 0 {
+		// 独立数据报，同时丢弃未完成的队列
+		r.reset()
+		return dest, payload, true
+	}
+	pos, last := frag&0x7F, frag&0x80 != 0
+	if r.next != 0 && now.After(r.deadline) {
+		r.reset()
+	}
+
+	switch {
+	case pos == 1:
+		r.reset()
+		r.dest = dest
+		r.deadline = now.Add(socks5FragTimeout)
+	case pos == 0 || pos != r.next || dest != r.dest:
+		r.reset()
+		return "", nil, false
+	}
+	if len(r.payload)+len(payload) > maxReassembledUDP {
+		r.reset()
+		return "", nil, false
+	}
+	r.payload = append(r.payload, payload...)
+	r.next = pos + 1
+
+	if !last {
+		return "", nil, false
+	}
+	dest, payload = r.dest, r.payload
+	r.payload = nil
+	r.reset()
+	return dest, payload, true
+}
+
+func (r *udpReassembler) reset() {
+	r.dest, r.next, r.payload = "", 0, r.payload[:0]
+}
+
+// pending 队列中是否有未完成且未超时的分片
+func (r *udpReassembler) pending(now time.Time) bool {
+	return r.next != 0 && !now.After(r.deadline)
+}
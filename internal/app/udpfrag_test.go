@@ -0,0 +1,103 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPReassembler(t *testing.T) {
+	now := time.Now()
+	var r udpReassembler
+
+	if _, _, ok := r.add(0x01, "1.1.1.1:53", []byte("ab"), now); ok {
+		t.Fatal("first fragment should not complete")
+	}
+	if _, _, ok := r.add(0x02, "1.1.1.1:53", []byte("cd"), now); ok {
+		t.Fatal("middle fragment should not complete")
+	}
+	dest, payload, ok := r.add(0x83, "1.1.1.1:53", []byte("ef"), now)
+	if !ok || dest != "1.1.1.1:53" || string(payload) != "abcdef" {
+		t.Fatalf("reassembled = %q %q %v", dest, payload, ok)
+	}
+
+	// 跳号：放弃整个队列
+	r.add(0x01, "1.1.1.1:53", []byte("ab"), now)
+	if _, _, ok := r.add(0x83, "1.1.1.1:53", []byte("ef"), now); ok {
+		t.Fatal("gap should abandon the queue")
+	}
+	if r.pending(now) {
+		t.Fatal("queue should be empty after a gap")
+	}
+
+	// 计时器超时后到达的后续分片被丢弃
+	r.add(0x01, "1.1.1.1:53", []byte("ab"), now)
+	if _, _, ok := r.add(0x82, "1.1.1.1:53", []byte("cd"), now.Add(socks5FragTimeout+time.Second)); ok {
+		t.Fatal("fragment after timeout should be dropped")
+	}
+
+	// FRAG=0 的独立数据报直接通过并清空队列
+	r.add(0x01, "1.1.1.1:53", []byte("ab"), now)
+	if _, payload, ok := r.add(0x00, "8.8.8.8:53", []byte("solo"), now); !ok || string(payload) != "solo" {
+		t.Fatalf("standalone datagram = %q %v", payload, ok)
+	}
+	if r.pending(now) {
+		t.Fatal("standalone datagram should reset the queue")
+	}
+}
+
+func TestUoTClientSessionRoutesRepliesPerClient(t *testing.T) {
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctrl, ctrlPeer := net.Pipe()
+	defer ctrlPeer.Close()
+	uotConn, err := (&uotEchoDialer{addrs: make(chan string, 16)}).DialUDPOverTCP()
+	if err != nil {
+		t.Fatalf("dial uot: %v", err)
+	}
+	session := newUoTClientSession(ctrl, relay, uotConn)
+	go session.run()
+	defer session.close()
+
+	newClient := func() *net.UDPConn {
+		c, err := net.DialUDP("udp", nil, relay.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatalf("dial relay: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	packet := func(frag byte, port uint16, payload string) []byte {
+		b := []byte{0, 0, frag, 0x01, 10, 0, 0, 1, 0, 0}
+		binary.BigEndian.PutUint16(b[8:], port)
+		return append(b, payload...)
+	}
+	expect := func(c *net.UDPConn, want []byte) {
+		t.Helper()
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1500)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("read reply: %v", err)
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Fatalf("reply = %x, want %x", buf[:n], want)
+		}
+	}
+
+	a, b := newClient(), newClient()
+	a.Write(packet(0x01, 1000, "hel"))
+	a.Write(packet(0x82, 1000, "lo"))
+	expect(a, packet(0, 1000, "hello"))
+
+	b.Write(packet(0, 2000, "from-b"))
+	expect(b, packet(0, 2000, "from-b"))
+
+	// a 再次发往自己的目标，回包不会被 b 抢走
+	a.Write(packet(0, 1000, "again"))
+	expect(a, packet(0, 1000, "again"))
+}
@@ -19,4 +19,83 @@ type Config struct {
 	CustomTables       []string `json:"custom_tables"`        // 可选，多套 X/P/V 布局轮换
 	EnablePureDownlink bool     `json:"enable_pure_downlink"` // 启用纯 Sudoku 下行；false 时使用带宽优化下行编码
 	DisableHTTPMask    bool     `json:"disable_http_mask"`
+
+	// 按用户鉴权：服务端 users 为 用户名 -> 密钥身份(-keygen 输出的 Key Identity)，
+	// 非空时拒绝未携带或未登记身份的客户端；客户端 user_auth=true 时在握手中签名出示身份
+	Users    map[string]string `json:"users,omitempty"`
+	UserAuth bool              `json:"user_auth,omitempty"`
+
+	// 吊销列表：revoked_keys 为已吊销的密钥身份，revocation_file 为每行一个身份的文件（修改后自动重载）；
+	// 必须同时配置 users，否则持有被吊销密钥者可重新拆分出不在列表中的身份
+	RevokedKeys    []string `json:"revoked_keys,omitempty"`
+	RevocationFile string   `json:"revocation_file,omitempty"`
+
+	MaxTimeSkew     int `json:"max_time_skew,omitempty"`     // 握手时间戳允许的最大偏差（秒），默认 60；重放缓存按此窗口保留
+	ReplayCacheSize int `json:"replay_cache_size,omitempty"` // 重放缓存最多记录的握手数，默认 65536；窗口内握手数超过该值时最早的指纹被挤出（会记录日志）
+
+	// 前向保密：客户端 key_exchange=true 时在握手中做 X25519 临时密钥交换，每个会话使用独立派生密钥（需服务端同样为新版本）
+	KeyExchange bool `json:"key_exchange,omitempty"`
+	// 计数器 nonce 分帧：每方向独立密钥、隐式递增 nonce（不再逐帧携带随机 nonce），乱序/重放帧直接断开；隐含 key_exchange
+	CounterNonce bool `json:"counter_nonce,omitempty"`
+
+	// 会话内换钥（仅 counter_nonce 生效）：本端每方向加密 rekey_bytes 字节（默认 1 GiB）或经过 rekey_interval 秒后切换到下一把密钥
+	RekeyBytes    int64 `json:"rekey_bytes,omitempty"`
+	RekeyInterval int   `json:"rekey_interval,omitempty"`
+
+	Mux bool `json:"mux,omitempty"` // 客户端多路复用：所有连接（含 UoT）作为逻辑流共享一条已握手的隧道，减少重复握手
+
+	// 预握手连接池：客户端保持 pool_size 条已握手的隧道连接供新请求直接使用，空闲超过约 pool_max_idle 秒（默认 30）即替换
+	PoolSize    int `json:"pool_size,omitempty"`
+	PoolMaxIdle int `json:"pool_max_idle,omitempty"`
+
+	// 多服务器：servers 非空时覆盖 server_address，按顺序优先使用第一个健康的服务端；
+	// 每 health_check_interval 秒（默认 30）经隧道探测一次，故障时自动切换、恢复后切回
+	Servers             []string `json:"servers,omitempty"`
+	HealthCheckInterval int      `json:"health_check_interval,omitempty"`
+	// 多服务器负载均衡："failover"（默认，主备切换）、"round-robin"、"least-conn"、"latency"、"consistent-hash"（按目标主机）
+	LoadBalance string `json:"load_balance,omitempty"`
+
+	// 规则路由：按顺序匹配（首条命中生效），格式同 Clash，如 "DOMAIN-SUFFIX,google.com,PROXY"、"IP-CIDR,10.0.0.0/8,DIRECT"、"MATCH,PROXY"；
+	// 目标为 PROXY / DIRECT / REJECT 或 outbounds 中的名称。非空时覆盖 rule_urls 的 global/direct/pac 模式
+	Rules     []string            `json:"rules,omitempty"`
+	Outbounds map[string][]string `json:"outbounds,omitempty"` // 命名出口：名称 -> 服务端地址列表（与主服务端共用密钥与其它参数）
+
+	RuleCacheDir       string `json:"rule_cache_dir,omitempty"`       // 远程 rule_urls 的本地缓存目录，默认为用户缓存目录下的 sudoku/rules
+	RuleUpdateInterval int    `json:"rule_update_interval,omitempty"` // rule_urls 后台刷新间隔（秒），默认 86400；失败的源保留上一次的内容
+
+	// GEOIP / GEOSITE 规则的数据文件（本地路径或 URL）：geoip 支持 v2ray geoip.dat 与 MaxMind .mmdb，geosite 为 v2ray geosite.dat
+	GeoIP   string `json:"geoip,omitempty"`
+	GeoSite string `json:"geosite,omitempty"`
+
+	// 本地 DNS：dns_listen 非空（如 "127.0.0.1:5353"）时在该地址提供 UDP/TCP DNS 服务，按路由规则分流——
+	// 直连域名交给 dns_direct（默认 223.5.5.5:53），代理域名经隧道（UoT）交给 dns_remote（默认 8.8.8.8:53），避免泄露
+	DNSListen string `json:"dns_listen,omitempty"`
+	DNSDirect string `json:"dns_direct,omitempty"`
+	DNSRemote string `json:"dns_remote,omitempty"`
+	// Fake-IP：非空（如 "198.18.0.0/15"）时本地 DNS 对走隧道的域名返回该网段内的假地址，
+	// SOCKS 连接到假地址时还原为域名再路由与发往服务端；需同时设置 dns_listen
+	FakeIPRange string `json:"fake_ip_range,omitempty"`
+	// 流量嗅探：SOCKS 目标为 IP 时读取首包中的 TLS SNI / HTTP Host，用该域名做路由并作为发往服务端的地址
+	Sniff bool `json:"sniff,omitempty"`
+
+	// 透明代理（仅 Linux）：redir_port 接收 iptables REDIRECT 的 TCP 连接（经 SO_ORIGINAL_DST 取回原始目标）；
+	// tproxy_port 接收 TPROXY 转来的 TCP 与 UDP（需 CAP_NET_ADMIN），UDP 经 UoT 发往服务端
+	RedirPort  int `json:"redir_port,omitempty"`
+	TProxyPort int `json:"tproxy_port,omitempty"`
+
+	// TUN 模式（仅 Linux）：tun 为设备名（如 "sudoku0"），在用户态协议栈上终结设备中的 TCP/UDP 后按路由转发；
+	// 地址与路由需自行用 ip 命令配置，并排除服务端地址以免回环。tun_mtu 默认 1500
+	Tun    string `json:"tun,omitempty"`
+	TunMTU int    `json:"tun_mtu,omitempty"`
+
+	// 本地混合端口：local_listen 为监听地址（如 "127.0.0.1"，默认所有网卡，redir_port/tproxy_port 同样绑定该地址）；
+	// 设置 local_username 后 SOCKS5 要求 RFC 1929 用户名/密码，HTTP 要求 Proxy-Authorization: Basic，SOCKS4 被拒绝
+	LocalListen   string `json:"local_listen,omitempty"`
+	LocalUsername string `json:"local_username,omitempty"`
+	LocalPassword string `json:"local_password,omitempty"`
+
+	// SOCKS5 BIND（服务端）：allow_bind=true 时允许客户端在服务端所有网卡上临时监听端口（默认关闭）；
+	// bind_advertise 为应答中通告的 IP，默认取客户端连入的本地地址，位于 NAT、CDN 或反向代理之后时需手动设置
+	AllowBind     bool   `json:"allow_bind,omitempty"`
+	BindAdvertise string `json:"bind_advertise,omitempty"`
 }
@@ -4,6 +4,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 )
 
@@ -29,13 +30,39 @@ func Load(path string) (*Config, error) {
 		cfg.ASCII = "prefer_entropy"
 	}
 
+	if cfg.LocalPassword != "" && cfg.LocalUsername == "" {
+		return nil, fmt.Errorf("local_password requires local_username")
+	}
+	if len(cfg.LocalUsername) > 255 || len(cfg.LocalPassword) > 255 {
+		return nil, fmt.Errorf("local_username and local_password must be at most 255 bytes")
+	}
+
+	if cfg.BindAdvertise != "" && net.ParseIP(cfg.BindAdvertise) == nil {
+		return nil, fmt.Errorf("bind_advertise must be an IP address: %q", cfg.BindAdvertise)
+	}
+
+	if cfg.FakeIPRange != "" && cfg.DNSListen == "" {
+		return nil, fmt.Errorf("fake_ip_range requires dns_listen")
+	}
+	if cfg.DNSListen != "" {
+		if cfg.DNSDirect == "" {
+			cfg.DNSDirect = "223.5.5.5:53"
+		}
+		if cfg.DNSRemote == "" {
+			cfg.DNSRemote = "8.8.8.8:53"
+		}
+	}
+
 	if !cfg.EnablePureDownlink && cfg.AEAD == "none" {
 		return nil, fmt.Errorf("enable_pure_downlink=false requires AEAD to be enabled")
 	}
 
 	// 处理 ProxyMode 和 默认规则
 	// 如果用户显式设置了 rule_urls 为 ["global"] 或 ["direct"]，则覆盖模式
-	if len(cfg.RuleURLs) > 0 && (cfg.RuleURLs[0] == "global" || cfg.RuleURLs[0] == "direct") {
+	// 配置了 rules 时使用规则路由，优先于上述模式
+	if len(cfg.Rules) > 0 {
+		cfg.ProxyMode = "rule"
+	} else if len(cfg.RuleURLs) > 0 && (cfg.RuleURLs[0] == "global" || cfg.RuleURLs[0] == "direct") {
 		cfg.ProxyMode = cfg.RuleURLs[0]
 		cfg.RuleURLs = nil
 	} else if len(cfg.RuleURLs) > 0 {
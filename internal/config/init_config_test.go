@@ -61,3 +61,90 @@ func TestLoadRejectsPackedWithoutAEAD(t *testing.T) {
 		t.Fatalf("expected error when packed downlink used without AEAD")
 	}
 }
+
+func TestLoadRulesSelectRuleMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfg.json")
+	data := `{
+		"mode": "client",
+		"key": "k",
+		"aead": "chacha20-poly1305",
+		"rule_urls": ["https://example.com/cn.yaml"],
+		"rules": ["DOMAIN-SUFFIX,google.com,us", "MATCH,DIRECT"],
+		"outbounds": {"us": ["us.example.com:443"]}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.ProxyMode != "rule" || len(cfg.Rules) != 2 || len(cfg.Outbounds["us"]) != 1 {
+		t.Fatalf("rule mode not selected: mode=%s rules=%v outbounds=%v", cfg.ProxyMode, cfg.Rules, cfg.Outbounds)
+	}
+}
+
+func TestLoadDNSDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfg.json")
+	data := `{"mode": "client", "key": "k", "aead": "chacha20-poly1305", "dns_listen": "127.0.0.1:5353"}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if cfg.DNSDirect != "223.5.5.5:53" || cfg.DNSRemote != "8.8.8.8:53" {
+		t.Fatalf("dns defaults not applied: direct=%s remote=%s", cfg.DNSDirect, cfg.DNSRemote)
+	}
+
+	data = `{"mode": "client", "key": "k", "aead": "chacha20-poly1305", "fake_ip_range": "198.18.0.0/15"}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("fake_ip_range without dns_listen should fail")
+	}
+}
+
+func TestLoadRejectsPasswordWithoutUsername(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "cfg.json")
+
+	data := `{
+		"mode": "client",
+		"local_port": 1080,
+		"local_listen": "192.168.1.2",
+		"server_address": "1.1.1.1:443",
+		"key": "k",
+		"local_password": "s3cret"
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected error when local_password is set without local_username")
+	}
+}
+
+func TestLoadRejectsInvalidBindAdvertise(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "cfg.json")
+
+	data := `{
+		"mode": "server",
+		"local_port": 8080,
+		"key": "k",
+		"aead": "chacha20-poly1305",
+		"allow_bind": true,
+		"bind_advertise": "proxy.example.com"
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected error when bind_advertise is not an IP")
+	}
+}
@@ -0,0 +1,204 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/tunnel"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	req, err := NewQuery(0x1234, "WWW.Example.com.", TypeA)
+	if err != nil {
+		t.Fatalf("NewQuery failed: %v", err)
+	}
+	q, _, err := ParseQuestion(req)
+	if err != nil {
+		t.Fatalf("ParseQuestion failed: %v", err)
+	}
+	if q.Name != "www.example.com" || q.Type != TypeA || q.Class != ClassIN {
+		t.Fatalf("unexpected question %+v", q)
+	}
+
+	resp := AnswerReply(req, 300, []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("2001:db8::1"), net.ParseIP("5.6.7.8")})
+	if ID(resp) != 0x1234 || Rcode(resp) != RcodeSuccess || resp[2]&0x80 == 0 {
+		t.Fatalf("bad response header % x", resp[:4])
+	}
+	ips, ttl, err := AnswerIPs(resp)
+	if err != nil {
+		t.Fatalf("AnswerIPs failed: %v", err)
+	}
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("1.2.3.4")) || !ips[1].Equal(net.ParseIP("5.6.7.8")) || ttl != 300 {
+		t.Fatalf("A answer = %v ttl %d", ips, ttl)
+	}
+
+	nx := ErrorReply(req, RcodeNameError)
+	if Rcode(nx) != RcodeNameError {
+		t.Fatalf("rcode = %d", Rcode(nx))
+	}
+	if ips, _, err := AnswerIPs(nx); err != nil || len(ips) != 0 {
+		t.Fatalf("error reply carried answers: %v %v", ips, err)
+	}
+
+	if _, _, err := ParseQuestion(req[:len(req)-3]); err == nil {
+		t.Fatalf("truncated question should fail")
+	}
+	if _, err := NewQuery(1, "bad..name", TypeA); err == nil {
+		t.Fatalf("empty label should fail")
+	}
+}
+
+func TestServerUDPAndTCP(t *testing.T) {
+	srv, err := Listen("127.0.0.1:0", func(req []byte) []byte {
+		return AnswerReply(req, 60, []net.IP{net.ParseIP("10.0.0.1")})
+	})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer srv.Close()
+	addr := srv.Addr().String()
+
+	req, _ := NewQuery(7, "example.com", TypeA)
+	resp, err := (&UDPUpstream{Addr: addr, Timeout: time.Second}).Exchange(req)
+	if err != nil {
+		t.Fatalf("UDP exchange failed: %v", err)
+	}
+	if ips, _, _ := AnswerIPs(resp); len(ips) != 1 || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("UDP answer = %v", ips)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("tcp dial: %v", err)
+	}
+	defer conn.Close()
+	for i := 0; i < 2; i++ { // 同一 TCP 连接上可连续查询
+		if err := WriteTCPMessage(conn, req); err != nil {
+			t.Fatalf("tcp write: %v", err)
+		}
+		resp, err := ReadTCPMessage(conn)
+		if err != nil {
+			t.Fatalf("tcp read: %v", err)
+		}
+		if ID(resp) != 7 || Rcode(resp) != RcodeSuccess {
+			t.Fatalf("bad TCP response % x", resp[:4])
+		}
+	}
+}
+
+func TestServerTruncatesLargeUDPAnswers(t *testing.T) {
+	var many []net.IP
+	for i := 0; i < 60; i++ {
+		many = append(many, net.IPv4(10, 0, 0, byte(i)))
+	}
+	srv, err := Listen("127.0.0.1:0", func(req []byte) []byte { return AnswerReply(req, 60, many) })
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer srv.Close()
+
+	// UDPUpstream 收到 TC 后自动改用 TCP 取得完整应答
+	req, _ := NewQuery(9, "big.example", TypeA)
+	resp, err := (&UDPUpstream{Addr: srv.Addr().String(), Timeout: time.Second}).Exchange(req)
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	if ips, _, _ := AnswerIPs(resp); len(ips) != len(many) {
+		t.Fatalf("got %d answers, want %d", len(ips), len(many))
+	}
+}
+
+// fakeUoTDialer answers UoT DNS queries in reverse arrival order, so responses can only be paired
+// with their queries through the transaction id.
+type fakeUoTDialer struct {
+	mu    sync.Mutex
+	dials int
+	addrs []string
+}
+
+func (d *fakeUoTDialer) Dial(string) (net.Conn, error) { return nil, fmt.Errorf("not supported") }
+
+func (d *fakeUoTDialer) DialUDPOverTCP() (net.Conn, error) {
+	d.mu.Lock()
+	d.dials++
+	d.mu.Unlock()
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		var batch [][]byte
+		for {
+			addr, payload, err := tunnel.ReadUoTDatagram(server)
+			if err != nil {
+				return
+			}
+			d.mu.Lock()
+			d.addrs = append(d.addrs, addr)
+			d.mu.Unlock()
+			batch = append(batch, payload)
+			if len(batch) < 2 {
+				continue
+			}
+			for i := len(batch) - 1; i >= 0; i-- {
+				q, _, _ := ParseQuestion(batch[i])
+				ip := net.ParseIP("10.0.0.1")
+				if q.Name == "two.example" {
+					ip = net.ParseIP("10.0.0.2")
+				}
+				if err := tunnel.WriteUoTDatagram(server, addr, AnswerReply(batch[i], 60, []net.IP{ip})); err != nil {
+					return
+				}
+			}
+			batch = nil
+		}
+	}()
+	return client, nil
+}
+
+func TestTunnelUpstreamSharesSession(t *testing.T) {
+	dialer := &fakeUoTDialer{}
+	up := &TunnelUpstream{Dialer: dialer, Addr: "8.8.8.8:53", Timeout: 2 * time.Second}
+	defer up.Close()
+
+	// 两个客户端使用相同的事务 ID，必须各自拿回自己的应答
+	names := []string{"one.example", "two.example"}
+	results := make([]net.IP, len(names))
+	ids := make([]uint16, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := NewQuery(42, name, TypeA)
+			resp, err := up.Exchange(req)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				return
+			}
+			ids[i] = ID(resp)
+			if ips, _, _ := AnswerIPs(resp); len(ips) == 1 {
+				results[i] = ips[0]
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !results[0].Equal(net.ParseIP("10.0.0.1")) || !results[1].Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("answers mixed up: %v", results)
+	}
+	if ids[0] != 42 || ids[1] != 42 {
+		t.Fatalf("original ids not restored: %v", ids)
+	}
+	dialer.mu.Lock()
+	defer dialer.mu.Unlock()
+	if dialer.dials != 1 {
+		t.Fatalf("expected one shared UoT session, got %d", dialer.dials)
+	}
+	for _, addr := range dialer.addrs {
+		if addr != "8.8.8.8:53" {
+			t.Fatalf("query sent to %s", addr)
+		}
+	}
+}
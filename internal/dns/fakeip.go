@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// FakeIPPool hands out addresses from a reserved IPv4 range (e.g. 198.18.0.0/15) in place of real
+// answers, and maps them back to the queried domain when a connection to one arrives. Addresses are
+// reused round-robin: once the range is exhausted the oldest mapping is evicted.
+type FakeIPPool struct {
+	prefix netip.Prefix
+	base   uint32 // 网段首地址
+	size   uint32 // 可分配地址数（不含网络地址与广播地址）
+
+	mu       sync.Mutex
+	next     uint32
+	byDomain map[string]uint32
+	byOffset map[uint32]string
+}
+
+// NewFakeIPPool creates a pool over cidr, which must be an IPv4 range of at least /30.
+func NewFakeIPPool(cidr string) (*FakeIPPool, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil, fmt.Errorf("fake-ip range: %w", err)
+	}
+	prefix = prefix.Masked()
+	if !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return nil, fmt.Errorf("fake-ip range %s: need an IPv4 range of /30 or larger", cidr)
+	}
+	a := prefix.Addr().As4()
+	return &FakeIPPool{
+		prefix:   prefix,
+		base:     binary.BigEndian.Uint32(a[:]),
+		size:     1<<(32-prefix.Bits()) - 2,
+		byDomain: make(map[string]uint32),
+		byOffset: make(map[uint32]string),
+	}, nil
+}
+
+// Prefix returns the range the pool allocates from.
+func (p *FakeIPPool) Prefix() netip.Prefix {
+	return p.prefix
+}
+
+// Contains reports whether ip lies inside the pool's range.
+func (p *FakeIPPool) Contains(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	return ok && p.prefix.Contains(addr.Unmap())
+}
+
+// IP returns the fake address for domain, allocating one on first use.
+func (p *FakeIPPool) IP(domain string) net.IP {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	off, ok := p.byDomain[domain]
+	if !ok {
+		off = p.next + 1 // 跳过网络地址
+		p.next = (p.next + 1) % p.size
+		if old, used := p.byOffset[off]; used {
+			delete(p.byDomain, old)
+		}
+		p.byDomain[domain] = off
+		p.byOffset[off] = domain
+	}
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, p.base+off)
+	return ip
+}
+
+// Domain returns the domain currently mapped to ip.
+func (p *FakeIPPool) Domain(ip net.IP) (string, bool) {
+	if !p.Contains(ip) {
+		return "", false
+	}
+	off := binary.BigEndian.Uint32(ip.To4()) - p.base
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	domain, ok := p.byOffset[off]
+	return domain, ok
+}
@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestFakeIPPoolMapping(t *testing.T) {
+	pool, err := NewFakeIPPool("198.18.0.0/29")
+	if err != nil {
+		t.Fatalf("NewFakeIPPool failed: %v", err)
+	}
+	a := pool.IP("a.example")
+	if !a.Equal(net.ParseIP("198.18.0.1")) {
+		t.Fatalf("first address = %s", a)
+	}
+	if again := pool.IP("A.Example."); !again.Equal(a) {
+		t.Fatalf("same domain got a new address %s", again)
+	}
+	if d, ok := pool.Domain(a); !ok || d != "a.example" {
+		t.Fatalf("Domain(%s) = %q, %v", a, d, ok)
+	}
+	if _, ok := pool.Domain(net.ParseIP("198.18.0.5")); ok {
+		t.Fatalf("unallocated address should have no mapping")
+	}
+	if pool.Contains(net.ParseIP("198.18.0.8")) || !pool.Contains(net.ParseIP("198.18.0.6")) {
+		t.Fatalf("Contains wrong at range boundary")
+	}
+
+	// /29 有 6 个可分配地址，第 7 个域名回收最早的映射
+	for _, name := range []string{"b", "c", "d", "e", "f"} {
+		pool.IP(name + ".example")
+	}
+	g := pool.IP("g.example")
+	if !g.Equal(a) {
+		t.Fatalf("expected wraparound to %s, got %s", a, g)
+	}
+	if d, _ := pool.Domain(g); d != "g.example" {
+		t.Fatalf("recycled address maps to %q", d)
+	}
+	if again := pool.IP("a.example"); again.Equal(a) {
+		t.Fatalf("evicted domain kept its old address")
+	}
+
+	for _, bad := range []string{"198.18.0.0/31", "fc00::/64", "nonsense"} {
+		if _, err := NewFakeIPPool(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Package dns implements the small part of the DNS wire format the client's local resolver needs:
+// reading the question, building queries and simple answers, and extracting A/AAAA records.
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Record types and response codes used by the client.
+const (
+	TypeA    uint16 = 1
+	TypeAAAA uint16 = 28
+	ClassIN  uint16 = 1
+
+	RcodeSuccess       = 0
+	RcodeFormatError   = 1
+	RcodeServerFailure = 2
+	RcodeNameError     = 3
+	RcodeRefused       = 5
+
+	headerSize = 12
+)
+
+var errShortMessage = errors.New("dns: message too short")
+
+// Question is the first question of a message.
+type Question struct {
+	Name  string // 小写、无结尾点
+	Type  uint16
+	Class uint16
+}
+
+// ID returns the transaction id of msg.
+func ID(msg []byte) uint16 {
+	if len(msg) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(msg)
+}
+
+// SetID overwrites the transaction id of msg in place.
+func SetID(msg []byte, id uint16) {
+	if len(msg) >= 2 {
+		binary.BigEndian.PutUint16(msg, id)
+	}
+}
+
+// ParseQuestion returns the first question of msg and the offset just past it.
+func ParseQuestion(msg []byte) (Question, int, error) {
+	if len(msg) < headerSize {
+		return Question{}, 0, errShortMessage
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return Question{}, 0, errors.New("dns: no question")
+	}
+	name, off, err := readName(msg, headerSize)
+	if err != nil {
+		return Question{}, 0, err
+	}
+	if off+4 > len(msg) {
+		return Question{}, 0, errShortMessage
+	}
+	q := Question{
+		Name:  name,
+		Type:  binary.BigEndian.Uint16(msg[off:]),
+		Class: binary.BigEndian.Uint16(msg[off+2:]),
+	}
+	return q, off + 4, nil
+}
+
+// NewQuery builds a recursive query for name.
+func NewQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, headerSize, headerSize+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg, err := appendName(msg, name)
+	if err != nil {
+		return nil, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	return binary.BigEndian.AppendUint16(msg, ClassIN), nil
+}
+
+// ErrorReply answers req with rcode and no records.
+func ErrorReply(req []byte, rcode int) []byte {
+	return reply(req, rcode, 0, nil)
+}
+
+// AnswerReply answers the question of req with ips, skipping addresses that do not match the
+// question type (A or AAAA).
+func AnswerReply(req []byte, ttl uint32, ips []net.IP) []byte {
+	q, _, err := ParseQuestion(req)
+	if err != nil {
+		return ErrorReply(req, RcodeServerFailure)
+	}
+	var records []byte
+	count := 0
+	for _, ip := range ips {
+		var data net.IP
+		switch q.Type {
+		case TypeA:
+			data = ip.To4()
+		case TypeAAAA:
+			if ip.To4() == nil {
+				data = ip.To16()
+			}
+		}
+		if data == nil {
+			continue
+		}
+		records = append(records, 0xC0, headerSize) // pointer to the question name
+		records = binary.BigEndian.AppendUint16(records, q.Type)
+		records = binary.BigEndian.AppendUint16(records, ClassIN)
+		records = binary.BigEndian.AppendUint32(records, ttl)
+		records = binary.BigEndian.AppendUint16(records, uint16(len(data)))
+		records = append(records, data...)
+		count++
+	}
+	return reply(req, RcodeSuccess, count, records)
+}
+
+// reply copies the header and first question of req and appends answer records.
+func reply(req []byte, rcode, answers int, records []byte) []byte {
+	end := len(req)
+	if _, off, err := ParseQuestion(req); err == nil {
+		end = off
+	} else if end > headerSize {
+		end = headerSize
+	}
+	msg := make([]byte, end, end+len(records))
+	copy(msg, req[:end])
+	if len(msg) < headerSize {
+		msg = append(msg, make([]byte, headerSize-len(msg))...)
+	}
+	qdcount := uint16(1)
+	if end == headerSize {
+		qdcount = 0
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	flags = 0x8000 | flags&0x7900 | 0x0080 | uint16(rcode&0xF) // QR, keep opcode+RD, RA
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], qdcount)
+	binary.BigEndian.PutUint16(msg[6:], uint16(answers))
+	binary.BigEndian.PutUint16(msg[8:], 0)
+	binary.BigEndian.PutUint16(msg[10:], 0)
+	return append(msg, records...)
+}
+
+// Rcode returns the response code of msg.
+func Rcode(msg []byte) int {
+	if len(msg) < 4 {
+		return RcodeServerFailure
+	}
+	return int(msg[3] & 0xF)
+}
+
+// AnswerIPs extracts the A/AAAA records of a response and the smallest TTL among them.
+func AnswerIPs(msg []byte) ([]net.IP, uint32, error) {
+	if len(msg) < headerSize {
+		return nil, 0, errShortMessage
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+	off := headerSize
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		off = next + 4
+	}
+
+	var ips []net.IP
+	var minTTL uint32
+	for i := 0; i < ancount; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, 0, err
+		}
+		if next+10 > len(msg) {
+			return nil, 0, errShortMessage
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		ttl := binary.BigEndian.Uint32(msg[next+4:])
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+rdlen > len(msg) {
+			return nil, 0, errShortMessage
+		}
+		if (typ == TypeA && rdlen == 4) || (typ == TypeAAAA && rdlen == 16) {
+			ips = append(ips, net.IP(append([]byte(nil), msg[data:data+rdlen]...)))
+			if minTTL == 0 || ttl < minTTL {
+				minTTL = ttl
+			}
+		}
+		off = data + rdlen
+	}
+	return ips, minTTL, nil
+}
+
+// readName decodes a possibly compressed name starting at off.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errShortMessage
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), next, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errShortMessage
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			if jumps++; jumps > 32 {
+				return "", 0, errors.New("dns: compression loop")
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		case l&0xC0 != 0:
+			return "", 0, fmt.Errorf("dns: unsupported label type %#x", l)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errShortMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+func appendName(msg []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("dns: invalid name %q", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	return append(msg, 0), nil
+}
@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Handler answers one DNS query; it must always return a response (e.g. ErrorReply on failure).
+type Handler func(req []byte) []byte
+
+const (
+	maxUDPMessage  = 4096
+	tcpIdleTimeout = 30 * time.Second
+)
+
+// Server serves DNS over UDP and TCP on the same address.
+type Server struct {
+	handler Handler
+	udp     net.PacketConn
+	tcp     net.Listener
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Listen starts serving handler on addr (UDP and TCP).
+func Listen(addr string, handler Handler) (*Server, error) {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// TCP 使用 UDP 实际绑定的端口，便于 addr 端口为 0 时两者一致
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+	s := &Server{handler: handler, udp: udp, tcp: tcp}
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return s, nil
+}
+
+// Addr returns the bound UDP address (TCP uses the same port).
+func (s *Server) Addr() net.Addr {
+	return s.udp.LocalAddr()
+}
+
+// Close stops both listeners.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.udp.Close()
+		s.tcp.Close()
+	})
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, maxUDPMessage)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := append([]byte(nil), buf[:n]...)
+		go func() {
+			resp := s.handler(req)
+			if len(resp) > 512 && !hasEDNS(req) {
+				// 超出经典 UDP 上限时只回头部并置 TC，客户端会改用 TCP 重试
+				resp = truncate(resp)
+			}
+			if _, err := s.udp.WriteTo(resp, addr); err != nil {
+				log.Printf("[DNS] UDP reply to %s failed: %v", addr, err)
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleTCP(conn)
+	}
+}
+
+func (s *Server) handleTCP(conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		req, err := ReadTCPMessage(conn)
+		if err != nil {
+			return
+		}
+		if err := WriteTCPMessage(conn, s.handler(req)); err != nil {
+			return
+		}
+	}
+}
+
+// ReadTCPMessage reads one length-prefixed DNS message.
+func ReadTCPMessage(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteTCPMessage writes msg with its 2-byte length prefix in one call.
+func WriteTCPMessage(w io.Writer, msg []byte) error {
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// hasEDNS reports whether the query carries additional records (normally an OPT record
+// advertising a larger UDP payload size).
+func hasEDNS(req []byte) bool {
+	return len(req) >= headerSize && binary.BigEndian.Uint16(req[10:]) > 0
+}
+
+func truncate(resp []byte) []byte {
+	out := reply(resp, Rcode(resp), 0, nil)
+	out[2] |= 0x02 // TC
+	return out
+}
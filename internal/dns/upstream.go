@@ -0,0 +1,199 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/tunnel"
+)
+
+// DefaultTimeout bounds one upstream exchange.
+const DefaultTimeout = 5 * time.Second
+
+// Upstream forwards a query and returns the response.
+type Upstream interface {
+	Exchange(req []byte) ([]byte, error)
+}
+
+// UDPUpstream queries a resolver directly, retrying over TCP when the answer is truncated.
+type UDPUpstream struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+func (u *UDPUpstream) Exchange(req []byte) ([]byte, error) {
+	timeout := u.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	conn, err := net.DialTimeout("udp", u.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < headerSize || ID(buf[:n]) != ID(req) {
+			continue
+		}
+		if buf[2]&0x02 != 0 { // TC
+			return u.exchangeTCP(req, timeout)
+		}
+		return append([]byte(nil), buf[:n]...), nil
+	}
+}
+
+func (u *UDPUpstream) exchangeTCP(req []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", u.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := WriteTCPMessage(conn, req); err != nil {
+		return nil, err
+	}
+	return ReadTCPMessage(conn)
+}
+
+// TunnelUpstream sends queries to a remote resolver as UoT datagrams through the tunnel.
+// All queries share one UoT session; transaction ids are rewritten so concurrent queries
+// from different clients never collide.
+type TunnelUpstream struct {
+	Dialer  tunnel.UoTDialer
+	Addr    string // 远端解析器地址，如 8.8.8.8:53
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	writeMu sync.Mutex // 串行化 UoT 帧写入
+	conn    net.Conn
+	nextID  uint16
+	pending map[uint16]chan []byte
+}
+
+var errUpstreamClosed = errors.New("dns: tunnel session closed")
+
+func (u *TunnelUpstream) Exchange(req []byte) ([]byte, error) {
+	if len(req) < headerSize {
+		return nil, errShortMessage
+	}
+	timeout := u.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	conn, id, ch, err := u.register()
+	if err != nil {
+		return nil, err
+	}
+	defer u.unregister(id)
+
+	out := append([]byte(nil), req...)
+	SetID(out, id)
+	u.writeMu.Lock()
+	err = tunnel.WriteUoTDatagram(conn, u.Addr, out)
+	u.writeMu.Unlock()
+	if err != nil {
+		u.reset(conn)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errUpstreamClosed
+		}
+		SetID(resp, ID(req))
+		return resp, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("dns: query to %s via tunnel timed out", u.Addr)
+	}
+}
+
+// Close tears down the shared session.
+func (u *TunnelUpstream) Close() error {
+	u.mu.Lock()
+	conn := u.conn
+	u.mu.Unlock()
+	if conn != nil {
+		u.reset(conn)
+	}
+	return nil
+}
+
+func (u *TunnelUpstream) register() (net.Conn, uint16, chan []byte, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conn == nil {
+		conn, err := u.Dialer.DialUDPOverTCP()
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		u.conn = conn
+		u.pending = make(map[uint16]chan []byte)
+		go u.readLoop(conn)
+	}
+	if len(u.pending) >= 1<<16-1 {
+		return nil, 0, nil, errors.New("dns: too many outstanding queries")
+	}
+	for {
+		u.nextID++
+		if _, busy := u.pending[u.nextID]; !busy {
+			break
+		}
+	}
+	ch := make(chan []byte, 1)
+	u.pending[u.nextID] = ch
+	return u.conn, u.nextID, ch, nil
+}
+
+func (u *TunnelUpstream) unregister(id uint16) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.pending, id)
+}
+
+func (u *TunnelUpstream) readLoop(conn net.Conn) {
+	for {
+		_, payload, err := tunnel.ReadUoTDatagram(conn)
+		if err != nil {
+			u.reset(conn)
+			return
+		}
+		if len(payload) < headerSize {
+			continue
+		}
+		u.mu.Lock()
+		if ch, ok := u.pending[ID(payload)]; ok && u.conn == conn {
+			delete(u.pending, ID(payload))
+			ch <- payload // 带缓冲，不会阻塞
+		}
+		u.mu.Unlock()
+	}
+}
+
+// reset drops a broken session so the next query dials a fresh one.
+func (u *TunnelUpstream) reset(conn net.Conn) {
+	conn.Close()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.conn != conn {
+		return
+	}
+	for id, ch := range u.pending {
+		close(ch)
+		delete(u.pending, id)
+	}
+	u.conn = nil
+}
@@ -23,8 +23,8 @@ func TestWriteReadAddress_IPv4(t *testing.T) {
 	if typ != AddrTypeIPv4 {
 		t.Fatalf("type mismatch, got %d", typ)
 	}
-	if ip == nil {
-		t.Fatalf("ip should not be nil for ipv4")
+	if ip == nil || ip.String() != "1.2.3.4" {
+		t.Fatalf("ip mismatch for ipv4, got %v", ip)
 	}
 }
 
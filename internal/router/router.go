@@ -0,0 +1,142 @@
+// Package router decides, per connection, whether a target goes through the tunnel,
+// directly, to a named outbound, or is rejected.
+package router
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Resolver looks up an address for host; it returns nil when resolution fails.
+type Resolver func(host string) net.IP
+
+// Metadata describes the connection being routed.
+type Metadata struct {
+	Host    string // 目标域名（小写，无结尾点）；目标为 IP 时为空
+	DstIP   net.IP
+	DstPort uint16
+	SrcIP   net.IP
+
+	resolver Resolver
+	resolved bool
+}
+
+// NewMetadata builds routing metadata for a host:port target requested by src.
+// destIP, if non-nil, is the already known target address.
+func NewMetadata(addr string, destIP net.IP, src net.Addr) *Metadata {
+	m := &Metadata{DstIP: destIP}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if port, err := strconv.ParseUint(portStr, 10, 16); err == nil {
+		m.DstPort = uint16(port)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if m.DstIP == nil {
+			m.DstIP = ip
+		}
+	} else {
+		m.Host = normalizeDomain(host)
+	}
+	switch a := src.(type) {
+	case *net.TCPAddr:
+		m.SrcIP = a.IP
+	case *net.UDPAddr:
+		m.SrcIP = a.IP
+	}
+	return m
+}
+
+// ResolvedIP returns the target address, resolving the domain on first use.
+// Resolution happens at most once per connection and only when an IP rule needs it.
+func (m *Metadata) ResolvedIP() net.IP {
+	if m.DstIP != nil || m.resolved || m.Host == "" {
+		return m.DstIP
+	}
+	m.resolved = true
+	if m.resolver != nil {
+		m.DstIP = m.resolver(m.Host)
+	}
+	return m.DstIP
+}
+
+// Router evaluates an ordered rule list; the first matching rule wins.
+type Router struct {
+	rules    []*Rule
+	resolver Resolver
+}
+
+// Options configures how rules are compiled and evaluated.
+type Options struct {
+	Outbounds []string // 规则可引用的命名出口
+	Resolver  Resolver // IP 类规则遇到域名时的解析器
+	Geo       GeoData  // GEOIP / GEOSITE 数据，未使用这两类规则时可为空
+}
+
+// New compiles rule lines. Targets other than PROXY/DIRECT/REJECT must be one of opts.Outbounds.
+func New(lines []string, opts Options) (*Router, error) {
+	known := make(map[string]struct{}, len(opts.Outbounds))
+	for _, name := range opts.Outbounds {
+		known[name] = struct{}{}
+	}
+
+	r := &Router{resolver: opts.Resolver}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseRule(line, opts.Geo)
+		if err != nil {
+			return nil, err
+		}
+		if !isBuiltinTarget(rule.Target) {
+			if _, ok := known[rule.Target]; !ok {
+				return nil, fmt.Errorf("rule %q: unknown outbound %q", line, rule.Target)
+			}
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// Prepend inserts rules ahead of the compiled list.
+func (r *Router) Prepend(rules ...*Rule) {
+	r.rules = append(append([]*Rule(nil), rules...), r.rules...)
+}
+
+// Rules returns the compiled rules in evaluation order.
+func (r *Router) Rules() []*Rule {
+	return r.rules
+}
+
+// Match returns the first rule matching m, or nil when none does (callers treat that as PROXY).
+func (r *Router) Match(m *Metadata) *Rule {
+	if r == nil {
+		return nil
+	}
+	if m.resolver == nil {
+		m.resolver = r.resolver
+	}
+	for _, rule := range r.rules {
+		if rule.Match(m) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// MatchDomain matches a bare domain without ever resolving it; IP rules only match when the
+// domain is itself an address. Used for decisions made before a connection exists, such as DNS.
+func (r *Router) MatchDomain(host string) *Rule {
+	m := NewMetadata(host, nil, nil)
+	m.resolved = true
+	return r.Match(m)
+}
+
+func isBuiltinTarget(target string) bool {
+	return target == TargetProxy || target == TargetDirect || target == TargetReject
+}
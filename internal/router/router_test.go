@@ -0,0 +1,187 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestRouterFirstMatchWins(t *testing.T) {
+	resolved := map[string]net.IP{
+		"intranet.corp":   net.ParseIP("10.1.2.3"),
+		"v6.example.org":  net.ParseIP("2001:db8::1"),
+		"public.example":  net.ParseIP("93.184.216.34"),
+		"blocked.example": net.ParseIP("10.9.9.9"),
+	}
+	lookups := 0
+	resolver := func(host string) net.IP {
+		lookups++
+		return resolved[host]
+	}
+
+	r, err := New([]string{
+		"# comment lines are ignored",
+		"DOMAIN,blocked.example,REJECT",
+		"DOMAIN-SUFFIX,google.com,us",
+		"DOMAIN-KEYWORD,tracker,REJECT",
+		`DOMAIN-REGEX,^cdn[0-9]+\.example\.net$,direct`,
+		"SRC-IP,192.168.50.0/24,DIRECT",
+		"DST-PORT,6881-6889,DIRECT",
+		"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+		"IP-CIDR,10.0.0.0/8,DIRECT",
+		"IP-CIDR6,2001:db8::/32,DIRECT",
+		"MATCH,PROXY",
+	}, Options{Outbounds: []string{"us"}, Resolver: resolver})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	client := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}
+	lanClient := &net.TCPAddr{IP: net.ParseIP("192.168.50.7"), Port: 50000}
+	cases := []struct {
+		addr   string
+		src    net.Addr
+		target string
+		rule   string
+	}{
+		{"blocked.example:443", client, TargetReject, "DOMAIN,blocked.example"},
+		{"www.google.com:443", client, "us", "DOMAIN-SUFFIX,google.com"},
+		{"google.com.:443", client, "us", "DOMAIN-SUFFIX,google.com"},
+		{"notgoogle.com:443", client, TargetProxy, "MATCH"},
+		{"ad.tracker.io:80", client, TargetReject, "DOMAIN-KEYWORD,tracker"},
+		{"CDN42.example.net:443", client, TargetDirect, `DOMAIN-REGEX,^cdn[0-9]+\.example\.net$`},
+		{"any.example:443", lanClient, TargetDirect, "SRC-IP,192.168.50.0/24"},
+		{"peer.example:6885", client, TargetDirect, "DST-PORT,6881-6889"},
+		{"10.3.3.3:22", client, TargetDirect, "IP-CIDR,10.0.0.0/8"},
+		{"intranet.corp:22", client, TargetDirect, "IP-CIDR,10.0.0.0/8"},
+		{"[2001:db8::5]:443", client, TargetDirect, "IP-CIDR6,2001:db8::/32"},
+		{"v6.example.org:443", client, TargetDirect, "IP-CIDR6,2001:db8::/32"},
+		{"public.example:443", client, TargetProxy, "MATCH"},
+	}
+	for _, tc := range cases {
+		rule := r.Match(NewMetadata(tc.addr, nil, tc.src))
+		if rule == nil {
+			t.Fatalf("%s: no rule matched", tc.addr)
+		}
+		if rule.Target != tc.target || rule.String() != tc.rule {
+			t.Fatalf("%s: got %s -> %s, want %s -> %s", tc.addr, rule, rule.Target, tc.rule, tc.target)
+		}
+	}
+
+	// Domain-only rules never resolve; only the IP rules reached for a domain do, and only once.
+	lookups = 0
+	r.Match(NewMetadata("www.google.com:443", nil, client))
+	if lookups != 0 {
+		t.Fatalf("domain rule triggered %d lookups", lookups)
+	}
+	r.Match(NewMetadata("public.example:443", nil, client))
+	if lookups != 1 {
+		t.Fatalf("expected exactly one lookup, got %d", lookups)
+	}
+}
+
+func TestRouterNoMatchAndNil(t *testing.T) {
+	r, err := New([]string{"DOMAIN,example.com,DIRECT"}, Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if rule := r.Match(NewMetadata("other.com:80", nil, nil)); rule != nil {
+		t.Fatalf("unexpected match %s", rule)
+	}
+	var empty *Router
+	if rule := empty.Match(NewMetadata("example.com:80", nil, nil)); rule != nil {
+		t.Fatalf("nil router must not match")
+	}
+}
+
+func TestRouterPrependFuncRule(t *testing.T) {
+	r, _ := New([]string{"MATCH,PROXY"}, Options{})
+	r.Prepend(NewFuncRule("PAC", func(m *Metadata) bool { return m.Host == "baidu.com" }, TargetDirect))
+	if rule := r.Match(NewMetadata("baidu.com:443", nil, nil)); rule.Target != TargetDirect {
+		t.Fatalf("func rule should match first, got %s", rule)
+	}
+	if rule := r.Match(NewMetadata("example.com:443", nil, nil)); rule.Target != TargetProxy {
+		t.Fatalf("fallthrough to MATCH failed, got %s", rule)
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	bad := []string{
+		"MATCH",
+		"DOMAIN,example.com",
+		"GEO,CN,DIRECT",
+		"IP-CIDR,10.0.0.0/33,DIRECT",
+		"DST-PORT,90-80,DIRECT",
+		"DST-PORT,http,DIRECT",
+		"DOMAIN-REGEX,(,DIRECT",
+		"IP-CIDR,10.0.0.0/8,DIRECT,sometimes",
+		"IP-CIDR,2001:db8::/32,DIRECT",
+		"IP-CIDR6,10.0.0.0/8,DIRECT",
+		"ip-cidr6,192.168.1.1,DIRECT",
+	}
+	for _, line := range bad {
+		if _, err := ParseRule(line, nil); err == nil {
+			t.Fatalf("expected error for %q", line)
+		}
+	}
+	if _, err := New([]string{"DOMAIN,example.com,jp"}, Options{Outbounds: []string{"us"}}); err == nil {
+		t.Fatalf("unknown outbound should be rejected")
+	}
+}
+
+func TestParseRuleRegexWithCommas(t *testing.T) {
+	rule, err := ParseRule(`DOMAIN-REGEX,^a\d{1,3}\.example\.com$,PROXY`, nil)
+	if err != nil {
+		t.Fatalf("ParseRule failed: %v", err)
+	}
+	if rule.Payload != `^a\d{1,3}\.example\.com$` || rule.Target != TargetProxy {
+		t.Fatalf("payload=%q target=%q", rule.Payload, rule.Target)
+	}
+	if !rule.Match(NewMetadata("a123.example.com:443", nil, nil)) || rule.Match(NewMetadata("a1234.example.com:443", nil, nil)) {
+		t.Fatalf("quantifier regex matched incorrectly")
+	}
+
+	rule, err = ParseRule(`DOMAIN-REGEX,^(x|y){2,}\.test$,DIRECT,no-resolve`, nil)
+	if err != nil || rule.Payload != `^(x|y){2,}\.test$` || rule.Target != TargetDirect {
+		t.Fatalf("regex with trailing option: %+v, %v", rule, err)
+	}
+}
+
+type fakeGeo struct{}
+
+func (fakeGeo) GeoIP(code string) (func(net.IP) bool, error) {
+	if code != "CN" {
+		return nil, fmt.Errorf("geoip code %q not found", code)
+	}
+	return func(ip net.IP) bool { return ip.Equal(net.ParseIP("1.0.1.1")) }, nil
+}
+
+func (fakeGeo) GeoSite(code string) (func(string) bool, error) {
+	return func(host string) bool { return code == "category-ads" && host == "ads.example" }, nil
+}
+
+func TestGeoRules(t *testing.T) {
+	lines := []string{"GEOSITE,category-ads,REJECT", "GEOIP,CN,DIRECT", "MATCH,PROXY"}
+	resolver := func(host string) net.IP { return net.ParseIP("1.0.1.1") }
+	r, err := New(lines, Options{Resolver: resolver, Geo: fakeGeo{}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if rule := r.Match(NewMetadata("ads.example:443", nil, nil)); rule.Target != TargetReject {
+		t.Fatalf("GEOSITE rule not applied, got %s", rule)
+	}
+	if rule := r.Match(NewMetadata("cn.example:443", nil, nil)); rule.Target != TargetDirect {
+		t.Fatalf("GEOIP rule should match the resolved address, got %s", rule)
+	}
+
+	if _, err := New(lines, Options{}); err == nil {
+		t.Fatalf("geo rules without data should fail")
+	}
+	if _, err := New([]string{"GEOIP,XX,DIRECT"}, Options{Geo: fakeGeo{}}); err == nil {
+		t.Fatalf("unknown geoip code should fail")
+	}
+	ip, site := GeoCodes(lines)
+	if len(ip) != 1 || ip[0] != "CN" || len(site) != 1 || site[0] != "category-ads" {
+		t.Fatalf("GeoCodes = %v %v", ip, site)
+	}
+}
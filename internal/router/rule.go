@@ -0,0 +1,259 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Built-in rule targets. Any other target names an outbound.
+const (
+	TargetProxy  = "PROXY"
+	TargetDirect = "DIRECT"
+	TargetReject = "REJECT"
+)
+
+// Rule is one entry of the ordered rule list.
+type Rule struct {
+	Type    string
+	Payload string
+	Target  string
+
+	match func(m *Metadata) bool
+}
+
+// Match reports whether the rule applies to m.
+func (r *Rule) Match(m *Metadata) bool {
+	return r.match(m)
+}
+
+func (r *Rule) String() string {
+	if r.Payload == "" {
+		return r.Type
+	}
+	return r.Type + "," + r.Payload
+}
+
+// NewFuncRule wraps a custom matcher, e.g. an external rule list, as a rule.
+func NewFuncRule(name string, match func(m *Metadata) bool, target string) *Rule {
+	return &Rule{Type: name, Target: target, match: match}
+}
+
+// GeoData resolves the country codes of GEOIP rules and the categories of GEOSITE rules
+// into matchers; it returns an error for codes the loaded data does not contain.
+type GeoData interface {
+	GeoIP(code string) (func(ip net.IP) bool, error)
+	GeoSite(code string) (func(host string) bool, error)
+}
+
+// ParseRule parses a Clash style rule line: TYPE,PAYLOAD,TARGET[,no-resolve] or MATCH,TARGET.
+// geo is only needed for GEOIP and GEOSITE rules.
+func ParseRule(line string, geo GeoData) (*Rule, error) {
+	ruleType, payload, target, noResolve, err := splitRule(line)
+	if err != nil {
+		return nil, err
+	}
+
+	if ruleType == "MATCH" || ruleType == "FINAL" {
+		if payload != "" || target == "" {
+			return nil, fmt.Errorf("invalid rule %q: want MATCH,TARGET", line)
+		}
+		return &Rule{Type: "MATCH", Target: normalizeTarget(target), match: func(*Metadata) bool { return true }}, nil
+	}
+
+	if payload == "" || target == "" {
+		return nil, fmt.Errorf("invalid rule %q: want TYPE,PAYLOAD,TARGET", line)
+	}
+	// 只有正则可能包含逗号（如 {1,3} 量词），其它类型出现逗号说明目标后跟了未知选项
+	if ruleType != "DOMAIN-REGEX" && strings.Contains(payload, ",") {
+		return nil, fmt.Errorf("invalid rule %q: unknown option %q", line, target)
+	}
+	target = normalizeTarget(target)
+
+	r := &Rule{Type: ruleType, Payload: payload, Target: target}
+	switch ruleType {
+	case "DOMAIN":
+		domain := normalizeDomain(payload)
+		r.match = func(m *Metadata) bool { return m.Host != "" && m.Host == domain }
+	case "DOMAIN-SUFFIX":
+		suffix := normalizeDomain(payload)
+		r.match = func(m *Metadata) bool {
+			return m.Host != "" && (m.Host == suffix || strings.HasSuffix(m.Host, "."+suffix))
+		}
+	case "DOMAIN-KEYWORD":
+		keyword := strings.ToLower(payload)
+		r.match = func(m *Metadata) bool { return m.Host != "" && strings.Contains(m.Host, keyword) }
+	case "DOMAIN-REGEX":
+		re, err := regexp.Compile(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", line, err)
+		}
+		r.match = func(m *Metadata) bool { return m.Host != "" && re.MatchString(m.Host) }
+	case "IP-CIDR", "IP-CIDR6":
+		prefix, err := parsePrefix(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", line, err)
+		}
+		// 地址族须与类型一致，避免写错类型的规则悄悄匹配另一族地址
+		if ruleType == "IP-CIDR" && !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("invalid rule %q: IP-CIDR needs an IPv4 prefix, use IP-CIDR6", line)
+		}
+		if ruleType == "IP-CIDR6" && !prefix.Addr().Is6() {
+			return nil, fmt.Errorf("invalid rule %q: IP-CIDR6 needs an IPv6 prefix, use IP-CIDR", line)
+		}
+		r.match = func(m *Metadata) bool {
+			ip := m.DstIP
+			if ip == nil && !noResolve {
+				ip = m.ResolvedIP()
+			}
+			return prefixContains(prefix, ip)
+		}
+	case "SRC-IP", "SRC-IP-CIDR":
+		prefix, err := parsePrefix(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", line, err)
+		}
+		r.Type = "SRC-IP"
+		r.match = func(m *Metadata) bool { return prefixContains(prefix, m.SrcIP) }
+	case "GEOIP":
+		if geo == nil {
+			return nil, fmt.Errorf("invalid rule %q: no geoip data configured", line)
+		}
+		match, err := geo.GeoIP(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", line, err)
+		}
+		r.match = func(m *Metadata) bool {
+			ip := m.DstIP
+			if ip == nil && !noResolve {
+				ip = m.ResolvedIP()
+			}
+			return ip != nil && match(ip)
+		}
+	case "GEOSITE":
+		if geo == nil {
+			return nil, fmt.Errorf("invalid rule %q: no geosite data configured", line)
+		}
+		match, err := geo.GeoSite(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", line, err)
+		}
+		r.match = func(m *Metadata) bool { return m.Host != "" && match(m.Host) }
+	case "DST-PORT":
+		lo, hi, err := parsePortRange(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", line, err)
+		}
+		r.match = func(m *Metadata) bool { return m.DstPort >= lo && m.DstPort <= hi }
+	default:
+		return nil, fmt.Errorf("invalid rule %q: unknown type %s", line, ruleType)
+	}
+	return r, nil
+}
+
+// splitRule splits a rule line into its type, payload, target and options. The type ends at
+// the first comma; the target and trailing options are taken from the end, so the payload
+// keeps any commas of its own (DOMAIN-REGEX quantifiers such as {1,3}).
+// For MATCH the whole remainder is the target and payload is empty.
+func splitRule(line string) (ruleType, payload, target string, noResolve bool, err error) {
+	head, rest, _ := strings.Cut(line, ",")
+	ruleType = strings.ToUpper(strings.TrimSpace(head))
+	if ruleType == "MATCH" || ruleType == "FINAL" {
+		if strings.Contains(rest, ",") {
+			return "", "", "", false, fmt.Errorf("invalid rule %q: want MATCH,TARGET", line)
+		}
+		return ruleType, "", strings.TrimSpace(rest), false, nil
+	}
+
+	fields := strings.Split(rest, ",")
+	for len(fields) > 0 {
+		last := strings.TrimSpace(fields[len(fields)-1])
+		if last != "" && !strings.EqualFold(last, "no-resolve") {
+			break
+		}
+		noResolve = noResolve || last != ""
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) < 2 {
+		return ruleType, "", "", false, fmt.Errorf("invalid rule %q: want TYPE,PAYLOAD,TARGET", line)
+	}
+	target = strings.TrimSpace(fields[len(fields)-1])
+	payload = strings.TrimSpace(strings.Join(fields[:len(fields)-1], ","))
+	return ruleType, payload, target, noResolve, nil
+}
+
+// GeoCodes returns the GEOIP codes and GEOSITE categories referenced by lines,
+// so callers can load only the parts of the data files that are needed.
+func GeoCodes(lines []string) (geoip, geosite []string) {
+	for _, line := range lines {
+		ruleType, payload, _, _, err := splitRule(line)
+		if err != nil || payload == "" {
+			continue
+		}
+		switch ruleType {
+		case "GEOIP":
+			geoip = append(geoip, payload)
+		case "GEOSITE":
+			geosite = append(geosite, payload)
+		}
+	}
+	return geoip, geosite
+}
+
+func normalizeTarget(target string) string {
+	switch upper := strings.ToUpper(target); upper {
+	case TargetProxy, TargetDirect, TargetReject:
+		return upper
+	}
+	return target
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// parsePrefix accepts a CIDR or a bare address (treated as a single host).
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.Trim(s, "'\"")
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func prefixContains(p netip.Prefix, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	return p.Contains(addr.Unmap())
+}
+
+func parsePortRange(s string) (uint16, uint16, error) {
+	loStr, hiStr, isRange := strings.Cut(s, "-")
+	lo, err := strconv.ParseUint(strings.TrimSpace(loStr), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+	hi := lo
+	if isRange {
+		if hi, err = strconv.ParseUint(strings.TrimSpace(hiStr), 10, 16); err != nil || hi < lo {
+			return 0, 0, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return uint16(lo), uint16(hi), nil
+}
@@ -0,0 +1,164 @@
+// Package sniff extracts the intended domain from the first bytes a client sends:
+// the SNI of a TLS ClientHello or the Host header of an HTTP/1.x request.
+package sniff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	// ErrNeedMore means the data so far is a valid prefix; read more and try again.
+	ErrNeedMore = errors.New("sniff: need more data")
+	// ErrNotFound means the stream is not TLS/HTTP or carries no usable domain.
+	ErrNotFound = errors.New("sniff: no domain found")
+)
+
+// MaxSize bounds how much a caller needs to buffer before giving up.
+const MaxSize = 16*1024 + 5 // 一个完整的 TLS 记录
+
+// Domain tries TLS first, then HTTP.
+func Domain(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", ErrNeedMore
+	}
+	if data[0] == 0x16 {
+		return TLSServerName(data)
+	}
+	return HTTPHost(data)
+}
+
+// TLSServerName returns the server_name extension of a ClientHello contained in the first record.
+func TLSServerName(data []byte) (string, error) {
+	if len(data) < 5 {
+		return "", ErrNeedMore
+	}
+	if data[0] != 0x16 || data[1] != 0x03 {
+		return "", ErrNotFound
+	}
+	recordLen := int(binary.BigEndian.Uint16(data[3:5]))
+	if len(data) < 5+recordLen {
+		return "", ErrNeedMore
+	}
+	hs := data[5 : 5+recordLen]
+	if len(hs) < 4 || hs[0] != 0x01 { // ClientHello
+		return "", ErrNotFound
+	}
+	body := hs[4:]
+	if hsLen := int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3]); hsLen < len(body) {
+		body = body[:hsLen]
+	}
+
+	// client_version(2) random(32) session_id cipher_suites compression_methods extensions
+	p := 2 + 32
+	if p >= len(body) {
+		return "", ErrNotFound
+	}
+	p += 1 + int(body[p])
+	if p+2 > len(body) {
+		return "", ErrNotFound
+	}
+	p += 2 + int(binary.BigEndian.Uint16(body[p:]))
+	if p >= len(body) {
+		return "", ErrNotFound
+	}
+	p += 1 + int(body[p])
+	if p+2 > len(body) {
+		return "", ErrNotFound
+	}
+	extEnd := p + 2 + int(binary.BigEndian.Uint16(body[p:]))
+	p += 2
+	if extEnd > len(body) {
+		extEnd = len(body)
+	}
+	for p+4 <= extEnd {
+		typ := binary.BigEndian.Uint16(body[p:])
+		l := int(binary.BigEndian.Uint16(body[p+2:]))
+		p += 4
+		if p+l > extEnd {
+			break
+		}
+		if typ == 0 { // server_name
+			return parseServerName(body[p : p+l])
+		}
+		p += l
+	}
+	return "", ErrNotFound
+}
+
+func parseServerName(ext []byte) (string, error) {
+	if len(ext) < 2 {
+		return "", ErrNotFound
+	}
+	list := ext[2:]
+	for len(list) >= 3 {
+		typ := list[0]
+		l := int(binary.BigEndian.Uint16(list[1:]))
+		if 3+l > len(list) {
+			break
+		}
+		if typ == 0 { // host_name
+			return validDomain(string(list[3 : 3+l]))
+		}
+		list = list[3+l:]
+	}
+	return "", ErrNotFound
+}
+
+var httpMethods = []string{"GET ", "POST ", "HEAD ", "PUT ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// HTTPHost returns the Host header of an HTTP/1.x request head, without port.
+func HTTPHost(data []byte) (string, error) {
+	method := false
+	for _, m := range httpMethods {
+		n := min(len(m), len(data))
+		if string(data[:n]) == m[:n] {
+			if n < len(m) {
+				return "", ErrNeedMore
+			}
+			method = true
+			break
+		}
+	}
+	if !method {
+		return "", ErrNotFound
+	}
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		if len(data) >= MaxSize {
+			return "", ErrNotFound
+		}
+		return "", ErrNeedMore
+	}
+	lines := strings.Split(string(data[:end]), "\r\n")
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "Host") {
+			continue
+		}
+		host := strings.TrimSpace(value)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return validDomain(host)
+	}
+	return "", ErrNotFound
+}
+
+// validDomain rejects IP literals and obviously malformed names.
+func validDomain(host string) (string, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || len(host) > 253 || net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return "", ErrNotFound
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return "", ErrNotFound
+		}
+	}
+	return host, nil
+}
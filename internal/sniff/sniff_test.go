@@ -0,0 +1,67 @@
+package sniff
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+)
+
+// clientHello captures the first flight of a real crypto/tls client.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		tls.Client(c, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		c.Close()
+	}()
+	buf := make([]byte, MaxSize)
+	n := 0
+	for n < 5 || n < 5+int(buf[3])<<8|int(buf[4]) {
+		m, err := s.Read(buf[n:])
+		if err != nil {
+			t.Fatalf("read ClientHello: %v", err)
+		}
+		n += m
+	}
+	return buf[:n]
+}
+
+func TestTLSServerName(t *testing.T) {
+	hello := clientHello(t, "WWW.Example.com")
+	if name, err := Domain(hello); err != nil || name != "www.example.com" {
+		t.Fatalf("Domain = %q, %v", name, err)
+	}
+	for _, n := range []int{0, 3, 5, len(hello) - 1} {
+		if _, err := TLSServerName(hello[:n]); !errors.Is(err, ErrNeedMore) {
+			t.Fatalf("prefix of %d bytes: err = %v, want ErrNeedMore", n, err)
+		}
+	}
+	// No SNI when connecting by IP.
+	if _, err := TLSServerName(clientHello(t, "10.0.0.1")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("IP ServerName: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestHTTPHost(t *testing.T) {
+	cases := []struct {
+		data string
+		host string
+		err  error
+	}{
+		{"GET / HTTP/1.1\r\nUser-Agent: x\r\nhost: Example.org:8080\r\n\r\n", "example.org", nil},
+		{"POST /api HTTP/1.1\r\nHost: api.example.org\r\n\r\nbody", "api.example.org", nil},
+		{"GE", "", ErrNeedMore},
+		{"GET / HTTP/1.1\r\nHost: example.org\r\n", "", ErrNeedMore},
+		{"GET / HTTP/1.1\r\nHost: 93.184.216.34\r\n\r\n", "", ErrNotFound},
+		{"GET / HTTP/1.1\r\nAccept: */*\r\n\r\n", "", ErrNotFound},
+		{"SSH-2.0-OpenSSH_9.6\r\n", "", ErrNotFound},
+	}
+	for _, tc := range cases {
+		host, err := Domain([]byte(tc.data))
+		if host != tc.host || !errors.Is(err, tc.err) {
+			t.Fatalf("%q: got %q, %v; want %q, %v", tc.data, host, err, tc.host, tc.err)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// Package tproxy provides the socket plumbing for transparent proxying on Linux: recovering the
+// original destination of iptables REDIRECT connections (SO_ORIGINAL_DST) and accepting TCP/UDP
+// traffic diverted by TPROXY (IP_TRANSPARENT). Other platforms get stubs returning ErrUnsupported.
+package tproxy
+
+import "errors"
+
+// ErrUnsupported is returned on platforms without transparent proxy support.
+var ErrUnsupported = errors.New("tproxy: transparent proxy is only supported on linux")
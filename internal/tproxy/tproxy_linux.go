@@ -0,0 +1,162 @@
+//go:build linux
+
+package tproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// OriginalDst returns the destination a REDIRECT-ed TCP connection was originally sent to.
+func OriginalDst(c net.Conn) (*net.TCPAddr, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("tproxy: %T has no file descriptor", c)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	local, _ := c.LocalAddr().(*net.TCPAddr)
+
+	var addr *net.TCPAddr
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		if local == nil || local.IP.To4() != nil {
+			// sockaddr_in 放得进 IPv6Mreq 的 20 字节
+			var mreq *unix.IPv6Mreq
+			if mreq, optErr = unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST); optErr == nil {
+				sa := mreq.Multiaddr
+				addr = &net.TCPAddr{IP: net.IPv4(sa[4], sa[5], sa[6], sa[7]), Port: int(sa[2])<<8 | int(sa[3])}
+			}
+			return
+		}
+		// sockaddr_in6 放得进 IPv6MTUInfo 的 32 字节
+		var info *unix.IPv6MTUInfo
+		if info, optErr = unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, unix.SO_ORIGINAL_DST); optErr == nil {
+			p := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+			addr = &net.TCPAddr{IP: net.IP(append([]byte(nil), info.Addr.Addr[:]...)), Port: int(p[0])<<8 | int(p[1])}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if optErr != nil {
+		return nil, fmt.Errorf("tproxy: SO_ORIGINAL_DST: %w", optErr)
+	}
+	return addr, nil
+}
+
+// ListenTCP listens on addr with IP_TRANSPARENT so connections diverted by TPROXY are accepted;
+// the LocalAddr of each accepted connection is its original destination.
+func ListenTCP(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: control(false)}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// ListenUDP listens on addr with IP_TRANSPARENT and original-destination reporting enabled,
+// for use with ReadFromUDP.
+func ListenUDP(addr string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: control(true)}
+	pc, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// ReadFromUDP reads one datagram from a ListenUDP socket along with its sender and original destination.
+func ReadFromUDP(conn *net.UDPConn, b []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	oob := make([]byte, 128)
+	n, oobn, _, src, err := conn.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	dst, err := parseOrigDst(oob[:oobn])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return n, src, dst, nil
+}
+
+func parseOrigDst(oob []byte) (*net.UDPAddr, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	for i := range msgs {
+		sa, err := unix.ParseOrigDstAddr(&msgs[i])
+		if err != nil {
+			continue
+		}
+		switch sa := sa.(type) {
+		case *unix.SockaddrInet4:
+			return &net.UDPAddr{IP: net.IP(append([]byte(nil), sa.Addr[:]...)), Port: sa.Port}, nil
+		case *unix.SockaddrInet6:
+			return &net.UDPAddr{IP: net.IP(append([]byte(nil), sa.Addr[:]...)), Port: sa.Port}, nil
+		}
+	}
+	return nil, errors.New("tproxy: no original destination in control message")
+}
+
+// DialUDP opens a UDP socket bound to laddr, which need not be a local address, and connected to
+// raddr. TPROXY UDP replies are sent through it so they appear to come from the original destination.
+func DialUDP(laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	d := net.Dialer{LocalAddr: laddr, Control: control(false)}
+	network := "udp4"
+	if laddr.IP.To4() == nil {
+		network = "udp6"
+	}
+	c, err := d.Dial(network, raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return c.(*net.UDPConn), nil
+}
+
+func control(recvOrigDst bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var optErr error
+		err := c.Control(func(fd uintptr) {
+			s := int(fd)
+			if optErr = unix.SetsockoptInt(s, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); optErr != nil {
+				return
+			}
+			v6 := strings.HasSuffix(network, "6")
+			if v6 {
+				optErr = unix.SetsockoptInt(s, unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+			} else {
+				optErr = unix.SetsockoptInt(s, unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+			}
+			if optErr != nil {
+				optErr = fmt.Errorf("set transparent (needs CAP_NET_ADMIN): %w", optErr)
+				return
+			}
+			if recvOrigDst {
+				if v6 {
+					optErr = unix.SetsockoptInt(s, unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1)
+				} else {
+					optErr = unix.SetsockoptInt(s, unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1)
+				}
+			}
+			// 双栈 IPv6 socket 也会收到映射的 IPv4 流量
+			if v6 && optErr == nil {
+				unix.SetsockoptInt(s, unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+				if recvOrigDst {
+					unix.SetsockoptInt(s, unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return optErr
+	}
+}
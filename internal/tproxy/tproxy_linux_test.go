@@ -0,0 +1,92 @@
+//go:build linux
+
+package tproxy
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Without iptables rules a TPROXY socket still reports the original destination of ordinary
+// packets (the listener's own address), which is enough to exercise the plumbing.
+func TestUDPOriginalDestination(t *testing.T) {
+	conn, err := ListenUDP("127.0.0.1:0")
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("IP_TRANSPARENT needs CAP_NET_ADMIN")
+	}
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("client listen: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.WriteToUDP([]byte("ping"), local); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, src, dst, err := ReadFromUDP(conn, buf)
+	if err != nil {
+		t.Fatalf("ReadFromUDP failed: %v", err)
+	}
+	if string(buf[:n]) != "ping" || src.Port != client.LocalAddr().(*net.UDPAddr).Port {
+		t.Fatalf("got %q from %s", buf[:n], src)
+	}
+	if !dst.IP.Equal(local.IP) || dst.Port != local.Port {
+		t.Fatalf("original destination = %s, want %s", dst, local)
+	}
+
+	// 回包从“原始目标”地址发出：这里用另一个回环地址代替非本机地址
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 5353}
+	reply, err := DialUDP(from, src)
+	if err != nil {
+		t.Fatalf("DialUDP failed: %v", err)
+	}
+	defer reply.Close()
+	if _, err := reply.Write([]byte("pong")); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, got, err := client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("client read: %v", err)
+	}
+	if string(buf[:n]) != "pong" || !got.IP.Equal(from.IP) || got.Port != from.Port {
+		t.Fatalf("reply %q from %s, want from %s", buf[:n], got, from)
+	}
+}
+
+func TestOriginalDstRequiresRedirect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			time.Sleep(100 * time.Millisecond)
+			c.Close()
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer c.Close()
+	// 未经 REDIRECT（或未加载 conntrack）的连接没有原始目标
+	if addr, err := OriginalDst(c); err == nil && addr.String() != ln.Addr().String() {
+		t.Fatalf("unexpected original destination %s", addr)
+	}
+	if _, err := OriginalDst(&net.UDPConn{}); err == nil {
+		t.Fatalf("expected error for a non-TCP connection")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package tproxy
+
+import "net"
+
+// OriginalDst returns the destination a REDIRECT-ed TCP connection was originally sent to.
+func OriginalDst(c net.Conn) (*net.TCPAddr, error) { return nil, ErrUnsupported }
+
+// ListenTCP listens for connections diverted by TPROXY.
+func ListenTCP(addr string) (net.Listener, error) { return nil, ErrUnsupported }
+
+// ListenUDP listens for datagrams diverted by TPROXY.
+func ListenUDP(addr string) (*net.UDPConn, error) { return nil, ErrUnsupported }
+
+// ReadFromUDP reads one datagram along with its sender and original destination.
+func ReadFromUDP(conn *net.UDPConn, b []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	return 0, nil, nil, ErrUnsupported
+}
+
+// DialUDP opens a UDP socket bound to a non-local laddr and connected to raddr.
+func DialUDP(laddr, raddr *net.UDPAddr) (*net.UDPConn, error) { return nil, ErrUnsupported }
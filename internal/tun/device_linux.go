@@ -0,0 +1,70 @@
+//go:build linux
+
+package tun
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Open creates (or attaches to) the TUN interface name, sets its MTU and brings it up.
+// An empty name lets the kernel pick one; the actual name is returned.
+// Addresses and routes are left to the caller (e.g. `ip addr` / `ip route`).
+func Open(name string, mtu int) (io.ReadWriteCloser, string, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("tun: open /dev/net/tun: %w", err)
+	}
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, "", fmt.Errorf("tun: %w", err)
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, "", fmt.Errorf("tun: TUNSETIFF %q: %w", name, err)
+	}
+	name = ifr.Name()
+	if err := setLinkUp(name, mtu); err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	// 非阻塞 fd 交给 runtime poller，Close 能打断阻塞中的 Read
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, "", fmt.Errorf("tun: %w", err)
+	}
+	return os.NewFile(uintptr(fd), "/dev/net/tun"), name, nil
+}
+
+func setLinkUp(name string, mtu int) error {
+	sock, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("tun: %w", err)
+	}
+	defer unix.Close(sock)
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return fmt.Errorf("tun: %w", err)
+	}
+	ifr.SetUint32(uint32(mtu))
+	if err := unix.IoctlIfreq(sock, unix.SIOCSIFMTU, ifr); err != nil {
+		return fmt.Errorf("tun: set MTU of %s: %w", name, err)
+	}
+	if ifr, err = unix.NewIfreq(name); err != nil {
+		return fmt.Errorf("tun: %w", err)
+	}
+	if err := unix.IoctlIfreq(sock, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("tun: get flags of %s: %w", name, err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP | unix.IFF_RUNNING)
+	if err := unix.IoctlIfreq(sock, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("tun: bring up %s: %w", name, err)
+	}
+	return nil
+}
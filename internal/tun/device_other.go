@@ -0,0 +1,13 @@
+//go:build !linux
+
+package tun
+
+import (
+	"errors"
+	"io"
+)
+
+// Open creates the TUN interface name; only supported on linux.
+func Open(name string, mtu int) (io.ReadWriteCloser, string, error) {
+	return nil, "", errors.New("tun: TUN devices are only supported on linux")
+}
@@ -0,0 +1,224 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	protoTCP = 6
+	protoUDP = 17
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	tcpHeaderLen  = 20
+	udpHeaderLen  = 8
+	defaultTTL    = 64
+)
+
+// ipPacket is a parsed IPv4/IPv6 packet without options or extension headers.
+type ipPacket struct {
+	src, dst netip.Addr
+	proto    uint8
+	payload  []byte // 传输层报文
+}
+
+// parseIP parses b, rejecting fragments and anything this stack does not handle.
+func parseIP(b []byte) (ipPacket, bool) {
+	if len(b) < 1 {
+		return ipPacket{}, false
+	}
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < ipv4HeaderLen {
+			return ipPacket{}, false
+		}
+		ihl := int(b[0]&0x0F) * 4
+		total := int(binary.BigEndian.Uint16(b[2:4]))
+		if ihl < ipv4HeaderLen || total < ihl || total > len(b) {
+			return ipPacket{}, false
+		}
+		// 不做分片重组：MF 置位或偏移非零的分片直接丢弃
+		if binary.BigEndian.Uint16(b[6:8])&0x3FFF != 0 {
+			return ipPacket{}, false
+		}
+		return ipPacket{
+			src:     netip.AddrFrom4([4]byte(b[12:16])),
+			dst:     netip.AddrFrom4([4]byte(b[16:20])),
+			proto:   b[9],
+			payload: b[ihl:total],
+		}, true
+	case 6:
+		if len(b) < ipv6HeaderLen {
+			return ipPacket{}, false
+		}
+		end := ipv6HeaderLen + int(binary.BigEndian.Uint16(b[4:6]))
+		if end > len(b) {
+			return ipPacket{}, false
+		}
+		return ipPacket{
+			src:     netip.AddrFrom16([16]byte(b[8:24])),
+			dst:     netip.AddrFrom16([16]byte(b[24:40])),
+			proto:   b[6],
+			payload: b[ipv6HeaderLen:end],
+		}, true
+	}
+	return ipPacket{}, false
+}
+
+// buildIP wraps a transport segment whose checksum is already set.
+func buildIP(src, dst netip.Addr, proto uint8, transport []byte) []byte {
+	if src.Is4() {
+		b := make([]byte, ipv4HeaderLen+len(transport))
+		b[0] = 0x45
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+		binary.BigEndian.PutUint16(b[6:], 0x4000) // DF
+		b[8] = defaultTTL
+		b[9] = proto
+		s, d := src.As4(), dst.As4()
+		copy(b[12:16], s[:])
+		copy(b[16:20], d[:])
+		binary.BigEndian.PutUint16(b[10:], checksum(b[:ipv4HeaderLen], 0))
+		copy(b[ipv4HeaderLen:], transport)
+		return b
+	}
+	b := make([]byte, ipv6HeaderLen+len(transport))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:], uint16(len(transport)))
+	b[6] = proto
+	b[7] = defaultTTL
+	s, d := src.As16(), dst.As16()
+	copy(b[8:24], s[:])
+	copy(b[24:40], d[:])
+	copy(b[ipv6HeaderLen:], transport)
+	return b
+}
+
+// pseudoHeaderSum is the partial checksum of the TCP/UDP pseudo header.
+func pseudoHeaderSum(src, dst netip.Addr, proto uint8, length int) uint32 {
+	var sum uint32
+	for _, a := range []netip.Addr{src, dst} {
+		b := a.AsSlice()
+		for i := 0; i < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+	}
+	return sum + uint32(proto) + uint32(length)
+}
+
+// checksum computes the Internet checksum of b seeded with initial.
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for len(b) >= 2 {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// transportValid verifies the TCP/UDP checksum of p.
+func transportValid(p ipPacket) bool {
+	if p.proto == protoUDP && p.src.Is4() && len(p.payload) >= udpHeaderLen && binary.BigEndian.Uint16(p.payload[6:8]) == 0 {
+		return true // IPv4 UDP 可不带校验和
+	}
+	return checksum(p.payload, pseudoHeaderSum(p.src, p.dst, p.proto, len(p.payload))) == 0
+}
+
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagRST = 0x04
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+type tcpSegment struct {
+	srcPort, dstPort uint16
+	seq, ack         uint32
+	flags            uint8
+	window           uint16
+	mss              uint16 // 仅 SYN 中的 MSS 选项，未携带时为 0
+	payload          []byte
+}
+
+func parseTCP(b []byte) (tcpSegment, bool) {
+	if len(b) < tcpHeaderLen {
+		return tcpSegment{}, false
+	}
+	off := int(b[12]>>4) * 4
+	if off < tcpHeaderLen || off > len(b) {
+		return tcpSegment{}, false
+	}
+	seg := tcpSegment{
+		srcPort: binary.BigEndian.Uint16(b[0:2]),
+		dstPort: binary.BigEndian.Uint16(b[2:4]),
+		seq:     binary.BigEndian.Uint32(b[4:8]),
+		ack:     binary.BigEndian.Uint32(b[8:12]),
+		flags:   b[13],
+		window:  binary.BigEndian.Uint16(b[14:16]),
+		payload: b[off:],
+	}
+	opts := b[tcpHeaderLen:off]
+	for len(opts) > 0 {
+		kind := opts[0]
+		if kind == 0 {
+			break
+		}
+		if kind == 1 {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || int(opts[1]) < 2 || int(opts[1]) > len(opts) {
+			break
+		}
+		if kind == 2 && opts[1] == 4 {
+			seg.mss = binary.BigEndian.Uint16(opts[2:4])
+		}
+		opts = opts[opts[1]:]
+	}
+	return seg, true
+}
+
+// buildTCP returns a TCP segment with checksum, plus an MSS option when mss > 0.
+func buildTCP(src, dst netip.AddrPort, seq, ack uint32, flags uint8, window, mss uint16, payload []byte) []byte {
+	hl := tcpHeaderLen
+	if mss > 0 {
+		hl += 4
+	}
+	b := make([]byte, hl+len(payload))
+	binary.BigEndian.PutUint16(b[0:], src.Port())
+	binary.BigEndian.PutUint16(b[2:], dst.Port())
+	binary.BigEndian.PutUint32(b[4:], seq)
+	binary.BigEndian.PutUint32(b[8:], ack)
+	b[12] = byte(hl/4) << 4
+	b[13] = flags
+	binary.BigEndian.PutUint16(b[14:], window)
+	if mss > 0 {
+		b[20], b[21] = 2, 4
+		binary.BigEndian.PutUint16(b[22:], mss)
+	}
+	copy(b[hl:], payload)
+	binary.BigEndian.PutUint16(b[16:], checksum(b, pseudoHeaderSum(src.Addr(), dst.Addr(), protoTCP, len(b))))
+	return buildIP(src.Addr(), dst.Addr(), protoTCP, b)
+}
+
+// buildUDP returns a complete IP packet carrying one UDP datagram.
+func buildUDP(src, dst netip.AddrPort, payload []byte) []byte {
+	b := make([]byte, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(b[0:], src.Port())
+	binary.BigEndian.PutUint16(b[2:], dst.Port())
+	binary.BigEndian.PutUint16(b[4:], uint16(len(b)))
+	copy(b[udpHeaderLen:], payload)
+	sum := checksum(b, pseudoHeaderSum(src.Addr(), dst.Addr(), protoUDP, len(b)))
+	if sum == 0 {
+		sum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(b[6:], sum)
+	return buildIP(src.Addr(), dst.Addr(), protoUDP, b)
+}
@@ -0,0 +1,207 @@
+// Package tun terminates TCP and UDP carried in raw IP packets (read from a TUN device or any other
+// packet source) with a small userspace stack, handing each TCP connection to the caller as a
+// net.Conn and each UDP datagram to a callback.
+//
+// The stack is deliberately minimal: IPv4 and IPv6 without options, fragments or extension headers,
+// no window scaling or SACK, in-order delivery only (out-of-order segments are dropped and left to
+// the peer's retransmission) and go-back-N retransmission on our side. That is enough for the
+// loss-free local link between the kernel and the process.
+package tun
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// DefaultMTU is used when Options.MTU is zero.
+const DefaultMTU = 1500
+
+// Options configures a Stack.
+type Options struct {
+	MTU int
+	// HandleTCP is called in its own goroutine for every established connection; LocalAddr is the
+	// destination the application dialed and RemoteAddr the application's own address.
+	HandleTCP func(conn net.Conn)
+	// HandleUDP is called synchronously from Run for every datagram; payload is owned by the
+	// callee. Run reads no packets while it executes, so it must not block on network I/O
+	// (dial new flows in the background). Replies are sent with WriteUDP.
+	HandleUDP func(src, dst *net.UDPAddr, payload []byte)
+}
+
+type flowKey struct {
+	local, remote netip.AddrPort // local = 应用访问的目标，remote = 应用自身
+}
+
+// Stack is a userspace TCP/UDP endpoint over a packet device.
+type Stack struct {
+	dev  io.ReadWriter
+	opts Options
+
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	conns  map[flowKey]*tcpConn
+	closed bool
+}
+
+// NewStack creates a stack reading and writing whole IP packets on dev.
+func NewStack(dev io.ReadWriter, opts Options) *Stack {
+	if opts.MTU <= 0 {
+		opts.MTU = DefaultMTU
+	}
+	return &Stack{dev: dev, opts: opts, conns: make(map[flowKey]*tcpConn)}
+}
+
+// Run processes packets until the device fails or the stack is closed.
+func (s *Stack) Run() error {
+	buf := make([]byte, s.opts.MTU+ipv6HeaderLen)
+	for {
+		n, err := s.dev.Read(buf)
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.handlePacket(buf[:n])
+	}
+}
+
+// Close resets every open connection and closes the device if it is an io.Closer.
+func (s *Stack) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conns := make([]*tcpConn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.abort(true)
+	}
+	if c, ok := s.dev.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// WriteUDP sends payload to the application at to, appearing to come from from.
+func (s *Stack) WriteUDP(from, to *net.UDPAddr, payload []byte) error {
+	src, ok1 := netip.AddrFromSlice(from.IP)
+	dst, ok2 := netip.AddrFromSlice(to.IP)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("tun: invalid UDP address %s -> %s", from, to)
+	}
+	src, dst = src.Unmap(), dst.Unmap()
+	if src.Is4() != dst.Is4() {
+		return fmt.Errorf("tun: address family mismatch %s -> %s", from, to)
+	}
+	if len(payload)+udpHeaderLen+ipHeaderLen(src) > s.opts.MTU {
+		return errors.New("tun: UDP payload exceeds MTU")
+	}
+	return s.send(buildUDP(netip.AddrPortFrom(src, uint16(from.Port)), netip.AddrPortFrom(dst, uint16(to.Port)), payload))
+}
+
+func (s *Stack) send(pkt []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.dev.Write(pkt)
+	return err
+}
+
+func (s *Stack) handlePacket(b []byte) {
+	p, ok := parseIP(b)
+	if !ok || (p.proto != protoTCP && p.proto != protoUDP) || !transportValid(p) {
+		return
+	}
+	if p.proto == protoUDP {
+		s.handleUDP(p)
+		return
+	}
+	seg, ok := parseTCP(p.payload)
+	if !ok {
+		return
+	}
+	key := flowKey{
+		local:  netip.AddrPortFrom(p.dst, seg.dstPort),
+		remote: netip.AddrPortFrom(p.src, seg.srcPort),
+	}
+	s.mu.Lock()
+	c := s.conns[key]
+	if c == nil && seg.flags&(flagSYN|flagACK|flagRST) == flagSYN && !s.closed {
+		c = newTCPConn(s, key, seg)
+		s.conns[key] = c
+		s.mu.Unlock()
+		c.start()
+		return
+	}
+	s.mu.Unlock()
+
+	if c == nil {
+		if seg.flags&flagRST == 0 {
+			s.sendReset(key, seg)
+		}
+		return
+	}
+	c.handleSegment(seg)
+}
+
+func (s *Stack) handleUDP(p ipPacket) {
+	b := p.payload
+	if len(b) < udpHeaderLen || s.opts.HandleUDP == nil {
+		return
+	}
+	length := int(b[4])<<8 | int(b[5])
+	if length < udpHeaderLen || length > len(b) {
+		return
+	}
+	src := &net.UDPAddr{IP: p.src.AsSlice(), Port: int(b[0])<<8 | int(b[1])}
+	dst := &net.UDPAddr{IP: p.dst.AsSlice(), Port: int(b[2])<<8 | int(b[3])}
+	s.opts.HandleUDP(src, dst, append([]byte(nil), b[udpHeaderLen:length]...))
+}
+
+// sendReset answers a segment that belongs to no connection (RFC 793 reset generation).
+func (s *Stack) sendReset(key flowKey, seg tcpSegment) {
+	if seg.flags&flagACK != 0 {
+		s.send(buildTCP(key.local, key.remote, seg.ack, 0, flagRST, 0, 0, nil))
+		return
+	}
+	ack := seg.seq + uint32(len(seg.payload))
+	if seg.flags&flagSYN != 0 {
+		ack++
+	}
+	if seg.flags&flagFIN != 0 {
+		ack++
+	}
+	s.send(buildTCP(key.local, key.remote, 0, ack, flagRST|flagACK, 0, 0, nil))
+}
+
+func (s *Stack) remove(key flowKey, c *tcpConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+}
+
+func (s *Stack) established(c *tcpConn) {
+	if s.opts.HandleTCP == nil {
+		c.Close()
+		return
+	}
+	go s.opts.HandleTCP(c)
+}
+
+func ipHeaderLen(a netip.Addr) int {
+	if a.Is4() {
+		return ipv4HeaderLen
+	}
+	return ipv6HeaderLen
+}
@@ -0,0 +1,216 @@
+package tun
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipeDevice is an in-memory packet device: the test plays the kernel side.
+type pipeDevice struct {
+	in   chan []byte // 测试 -> 协议栈
+	out  chan []byte // 协议栈 -> 测试
+	once sync.Once
+	done chan struct{}
+}
+
+func newPipeDevice() *pipeDevice {
+	return &pipeDevice{in: make(chan []byte, 64), out: make(chan []byte, 64), done: make(chan struct{})}
+}
+
+func (d *pipeDevice) Read(b []byte) (int, error) {
+	select {
+	case p := <-d.in:
+		return copy(b, p), nil
+	case <-d.done:
+		return 0, io.EOF
+	}
+}
+
+func (d *pipeDevice) Write(b []byte) (int, error) {
+	select {
+	case d.out <- append([]byte(nil), b...):
+		return len(b), nil
+	case <-d.done:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (d *pipeDevice) Close() error {
+	d.once.Do(func() { close(d.done) })
+	return nil
+}
+
+func (d *pipeDevice) next(t *testing.T) (ipPacket, tcpSegment) {
+	t.Helper()
+	select {
+	case b := <-d.out:
+		p, ok := parseIP(b)
+		if !ok || !transportValid(p) {
+			t.Fatalf("stack wrote an invalid packet: %x", b)
+		}
+		if p.proto != protoTCP {
+			return p, tcpSegment{}
+		}
+		seg, ok := parseTCP(p.payload)
+		if !ok {
+			t.Fatalf("stack wrote an invalid TCP segment: %x", p.payload)
+		}
+		return p, seg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a packet from the stack")
+	}
+	return ipPacket{}, tcpSegment{}
+}
+
+func startStack(t *testing.T, opts Options) (*Stack, *pipeDevice) {
+	dev := newPipeDevice()
+	s := NewStack(dev, opts)
+	go s.Run()
+	t.Cleanup(func() { s.Close() })
+	return s, dev
+}
+
+func TestTCPHandshakeDataAndClose(t *testing.T) {
+	app := netip.MustParseAddrPort("10.0.0.2:40000")
+	dst := netip.MustParseAddrPort("93.184.216.34:80")
+	accepted := make(chan net.Conn, 1)
+	_, dev := startStack(t, Options{HandleTCP: func(c net.Conn) { accepted <- c }})
+
+	dev.in <- buildTCP(app, dst, 1000, 0, flagSYN, 65535, 1460, nil)
+	_, synAck := dev.next(t)
+	if synAck.flags != flagSYN|flagACK || synAck.ack != 1001 || synAck.mss == 0 {
+		t.Fatalf("unexpected SYN-ACK: %+v", synAck)
+	}
+	iss := synAck.seq
+	dev.in <- buildTCP(app, dst, 1001, iss+1, flagACK, 65535, 0, nil)
+
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection was not handed over")
+	}
+	if got := conn.LocalAddr().String(); got != dst.String() {
+		t.Fatalf("LocalAddr = %s, want original destination %s", got, dst)
+	}
+	if got := conn.RemoteAddr().String(); got != app.String() {
+		t.Fatalf("RemoteAddr = %s, want %s", got, app)
+	}
+
+	// 应用 -> 协议栈
+	dev.in <- buildTCP(app, dst, 1001, iss+1, flagACK|flagPSH, 65535, 0, []byte("ping"))
+	if _, ack := dev.next(t); ack.ack != 1005 {
+		t.Fatalf("data not acknowledged: %+v", ack)
+	}
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("Read = %q, %v", buf[:n], err)
+	}
+	// 重传的旧数据只触发 ACK，不重复交付
+	dev.in <- buildTCP(app, dst, 1001, iss+1, flagACK|flagPSH, 65535, 0, []byte("ping"))
+	if _, ack := dev.next(t); ack.ack != 1005 {
+		t.Fatalf("duplicate not acknowledged: %+v", ack)
+	}
+
+	// 协议栈 -> 应用
+	if _, err := conn.Write([]byte("pong")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	_, data := dev.next(t)
+	if string(data.payload) != "pong" || data.seq != iss+1 {
+		t.Fatalf("unexpected data segment: %+v", data)
+	}
+	dev.in <- buildTCP(app, dst, 1005, iss+5, flagACK, 65535, 0, nil)
+
+	// 应用先关闭：FIN -> ACK；协议栈 Read 到 EOF 后关闭并发出 FIN
+	dev.in <- buildTCP(app, dst, 1005, iss+5, flagFIN|flagACK, 65535, 0, nil)
+	if _, ack := dev.next(t); ack.ack != 1006 {
+		t.Fatalf("FIN not acknowledged: %+v", ack)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(buf); err != io.EOF {
+		t.Fatalf("Read after FIN = %v, want EOF", err)
+	}
+	conn.Close()
+	_, fin := dev.next(t)
+	if fin.flags&flagFIN == 0 || fin.seq != iss+5 {
+		t.Fatalf("expected FIN, got %+v", fin)
+	}
+	dev.in <- buildTCP(app, dst, 1006, iss+6, flagACK, 65535, 0, nil)
+
+	// 连接已移除，后续报文收到 RST
+	time.Sleep(50 * time.Millisecond)
+	dev.in <- buildTCP(app, dst, 1006, iss+6, flagACK, 65535, 0, []byte("late"))
+	if _, rst := dev.next(t); rst.flags&flagRST == 0 {
+		t.Fatalf("expected RST for closed flow, got %+v", rst)
+	}
+}
+
+func TestTCPRetransmitsUnackedData(t *testing.T) {
+	app := netip.MustParseAddrPort("[2001:db8::2]:40001")
+	dst := netip.MustParseAddrPort("[2001:db8::1]:443")
+	accepted := make(chan net.Conn, 1)
+	_, dev := startStack(t, Options{HandleTCP: func(c net.Conn) { accepted <- c }})
+
+	dev.in <- buildTCP(app, dst, 0, 0, flagSYN, 65535, 1440, nil)
+	_, synAck := dev.next(t)
+	iss := synAck.seq
+	dev.in <- buildTCP(app, dst, 1, iss+1, flagACK, 65535, 0, nil)
+	conn := <-accepted
+
+	payload := bytes.Repeat([]byte("x"), 3000)
+	go conn.Write(payload)
+	var got []byte
+	for len(got) < len(payload) {
+		_, seg := dev.next(t)
+		if len(seg.payload) > 1440 {
+			t.Fatalf("segment of %d bytes exceeds MSS", len(seg.payload))
+		}
+		got = append(got, seg.payload...)
+	}
+	// 不确认，等待超时重传从 snd.una 开始
+	_, again := dev.next(t)
+	if again.seq != iss+1 || len(again.payload) == 0 {
+		t.Fatalf("expected retransmission from %d, got %+v", iss+1, again)
+	}
+	dev.in <- buildTCP(app, dst, 1, iss+1+3000, flagACK, 65535, 0, nil)
+	conn.Close()
+}
+
+func TestUDPRoundTripAndReset(t *testing.T) {
+	app := netip.MustParseAddrPort("10.0.0.2:5353")
+	dst := netip.MustParseAddrPort("8.8.8.8:53")
+	type datagram struct {
+		src, dst *net.UDPAddr
+		payload  []byte
+	}
+	got := make(chan datagram, 1)
+	s, dev := startStack(t, Options{HandleUDP: func(src, dst *net.UDPAddr, payload []byte) {
+		got <- datagram{src, dst, payload}
+	}})
+
+	dev.in <- buildUDP(app, dst, []byte("query"))
+	d := <-got
+	if d.src.String() != app.String() || d.dst.String() != dst.String() || string(d.payload) != "query" {
+		t.Fatalf("unexpected datagram %s -> %s %q", d.src, d.dst, d.payload)
+	}
+	if err := s.WriteUDP(d.dst, d.src, []byte("answer")); err != nil {
+		t.Fatalf("WriteUDP failed: %v", err)
+	}
+	p, _ := dev.next(t)
+	if p.proto != protoUDP || p.src != dst.Addr() || p.dst != app.Addr() || string(p.payload[udpHeaderLen:]) != "answer" {
+		t.Fatalf("unexpected reply packet %+v", p)
+	}
+
+	// 无对应连接的 TCP 报文（且非 SYN）应收到 RST
+	dev.in <- buildTCP(app, dst, 7, 42, flagACK, 65535, 0, nil)
+	if _, rst := dev.next(t); rst.flags&flagRST == 0 || rst.seq != 42 {
+		t.Fatalf("expected RST, got %+v", rst)
+	}
+}
@@ -0,0 +1,457 @@
+package tun
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	rcvBufSize    = 64*1024 - 1 // 不协商窗口缩放，通告窗口上限即接收缓冲
+	sndBufSize    = 256 * 1024
+	initialRTO    = 200 * time.Millisecond
+	maxRTO        = 10 * time.Second
+	maxRetries    = 8
+	lingerTimeout = 30 * time.Second // 本端关闭后等待对端 FIN 的最长时间
+)
+
+var (
+	errConnReset   = errors.New("tun: connection reset by peer")
+	errConnTimeout = errors.New("tun: connection timed out")
+)
+
+type tcpState int
+
+const (
+	stateSynReceived tcpState = iota
+	stateEstablished
+	stateClosed
+)
+
+// tcpConn is the stack's end of one TCP connection; it implements net.Conn.
+type tcpConn struct {
+	stack *Stack
+	key   flowKey
+	mss   int
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	state tcpState
+	err   error // 连接异常终止的原因
+
+	// 接收方向
+	rcvNxt  uint32
+	rcvBuf  []byte
+	rcvFin  bool   // 已按序收到对端 FIN
+	lastWnd uint16 // 最近一次通告的窗口
+
+	// 发送方向：sndBuf 从 sndUna 开始，含已发未确认与未发送的数据
+	iss, sndUna, sndNxt uint32
+	sndBuf              []byte
+	sndWnd              uint32
+	finQueued           bool // 应用已关闭，数据发完后发送 FIN
+	finSent             bool
+	finAcked            bool
+
+	rto        time.Duration
+	retries    int
+	timer      *time.Timer
+	timerArmed bool
+	linger     *time.Timer
+
+	appClosed     bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// newTCPConn creates a connection in SYN-RECEIVED for an incoming SYN; start answers it.
+func newTCPConn(s *Stack, key flowKey, syn tcpSegment) *tcpConn {
+	ourMSS := s.opts.MTU - ipHeaderLen(key.local.Addr()) - tcpHeaderLen
+	peerMSS := int(syn.mss)
+	if peerMSS == 0 {
+		peerMSS = 536
+		if !key.local.Addr().Is4() {
+			peerMSS = 1220
+		}
+	}
+	var b [4]byte
+	rand.Read(b[:])
+	iss := binary.BigEndian.Uint32(b[:])
+	c := &tcpConn{
+		stack:  s,
+		key:    key,
+		mss:    min(ourMSS, peerMSS),
+		state:  stateSynReceived,
+		rcvNxt: syn.seq + 1,
+		iss:    iss,
+		sndUna: iss,
+		sndNxt: iss + 1,
+		sndWnd: uint32(syn.window),
+		rto:    initialRTO,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *tcpConn) start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sendSynAck()
+	c.armTimer()
+}
+
+func seqLT(a, b uint32) bool  { return int32(a-b) < 0 }
+func seqLEQ(a, b uint32) bool { return int32(a-b) <= 0 }
+
+func (c *tcpConn) window() uint16 {
+	return uint16(max(rcvBufSize-len(c.rcvBuf), 0))
+}
+
+func (c *tcpConn) sendSegment(seq uint32, flags uint8, payload []byte) {
+	wnd := c.window()
+	c.lastWnd = wnd
+	c.stack.send(buildTCP(c.key.local, c.key.remote, seq, c.rcvNxt, flags, wnd, 0, payload))
+}
+
+func (c *tcpConn) sendSynAck() {
+	wnd := c.window()
+	c.lastWnd = wnd
+	c.stack.send(buildTCP(c.key.local, c.key.remote, c.iss, c.rcvNxt, flagSYN|flagACK, wnd, uint16(c.mss), nil))
+}
+
+func (c *tcpConn) sendAck() {
+	c.sendSegment(c.sndNxt, flagACK, nil)
+}
+
+func (c *tcpConn) handleSegment(seg tcpSegment) {
+	c.mu.Lock()
+	established := false
+	defer func() {
+		c.mu.Unlock()
+		if established {
+			c.stack.established(c)
+		}
+	}()
+
+	if c.state == stateClosed {
+		return
+	}
+	if seg.flags&flagRST != 0 {
+		c.resetLocked(errConnReset, false)
+		return
+	}
+	if c.state == stateSynReceived {
+		if seg.flags&flagSYN != 0 && seg.flags&flagACK == 0 {
+			c.sendSynAck() // SYN 重传
+			return
+		}
+		if seg.flags&flagACK == 0 || seg.ack != c.iss+1 {
+			return
+		}
+		c.state = stateEstablished
+		c.sndUna = seg.ack
+		c.sndWnd = uint32(seg.window)
+		c.retries, c.rto = 0, initialRTO
+		c.stopTimer()
+		established = true
+	}
+
+	if seg.flags&flagACK != 0 {
+		if seqLT(c.sndUna, seg.ack) && seqLEQ(seg.ack, c.sndNxt) {
+			acked := int(seg.ack - c.sndUna)
+			if c.finSent && seg.ack == c.sndNxt {
+				c.finAcked = true
+				acked--
+			}
+			c.sndBuf = c.sndBuf[acked:]
+			c.sndUna = seg.ack
+			c.retries, c.rto = 0, initialRTO
+			c.stopTimer()
+			c.cond.Broadcast()
+		}
+		if seqLEQ(c.sndUna, seg.ack) {
+			c.sndWnd = uint32(seg.window)
+		}
+	}
+
+	needAck := false
+	if len(seg.payload) > 0 {
+		needAck = true
+		payload, seq := seg.payload, seg.seq
+		if seqLT(seq, c.rcvNxt) { // 与已收数据重叠的部分
+			dup := int(c.rcvNxt - seq)
+			if dup >= len(payload) {
+				payload = nil
+			} else {
+				payload, seq = payload[dup:], c.rcvNxt
+			}
+		}
+		// 只接受按序数据，乱序段丢弃等待对端重传
+		if seq == c.rcvNxt && len(payload) > 0 && !c.rcvFin {
+			n := min(len(payload), int(c.window()))
+			if !c.appClosed {
+				c.rcvBuf = append(c.rcvBuf, payload[:n]...)
+			}
+			c.rcvNxt += uint32(n)
+			c.cond.Broadcast()
+		}
+	}
+	if seg.flags&flagFIN != 0 {
+		needAck = true
+		if !c.rcvFin && seg.seq+uint32(len(seg.payload)) == c.rcvNxt {
+			c.rcvFin = true
+			c.rcvNxt++
+			c.cond.Broadcast()
+		}
+	}
+	if needAck {
+		c.sendAck()
+	}
+	c.output(false)
+	c.maybeFinish()
+}
+
+// output sends whatever the peer's window allows, then the FIN once all data is out.
+// probe lets one byte through a zero window (persist probe).
+func (c *tcpConn) output(probe bool) {
+	if c.state != stateEstablished {
+		return
+	}
+	for !c.finSent {
+		off := int(c.sndNxt - c.sndUna)
+		if off >= len(c.sndBuf) {
+			if c.finQueued {
+				c.sendSegment(c.sndNxt, flagFIN|flagACK, nil)
+				c.finSent = true
+				c.sndNxt++
+			}
+			break
+		}
+		wnd := int(c.sndWnd) - off
+		if wnd <= 0 {
+			if !probe || off != 0 {
+				break
+			}
+			wnd = 1
+		}
+		n := min(c.mss, len(c.sndBuf)-off, wnd)
+		c.sendSegment(c.sndNxt, flagACK|flagPSH, c.sndBuf[off:off+n])
+		c.sndNxt += uint32(n)
+		probe = false
+	}
+	if c.sndNxt != c.sndUna || len(c.sndBuf) > 0 {
+		c.armTimer()
+	}
+}
+
+func (c *tcpConn) armTimer() {
+	if c.timerArmed {
+		return
+	}
+	c.timerArmed = true
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.rto, c.onTimeout)
+	} else {
+		c.timer.Reset(c.rto)
+	}
+}
+
+func (c *tcpConn) stopTimer() {
+	if c.timerArmed {
+		c.timer.Stop()
+		c.timerArmed = false
+	}
+}
+
+// onTimeout retransmits everything unacknowledged (go-back-N) or probes a zero window.
+func (c *tcpConn) onTimeout() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.timerArmed || c.state == stateClosed {
+		return
+	}
+	c.timerArmed = false
+
+	zeroWindow := c.sndWnd == 0 && c.sndNxt == c.sndUna
+	if !zeroWindow {
+		if c.retries++; c.retries > maxRetries {
+			c.resetLocked(errConnTimeout, true)
+			return
+		}
+	}
+	c.rto = min(c.rto*2, maxRTO)
+	if c.state == stateSynReceived {
+		c.sendSynAck()
+		c.armTimer()
+		return
+	}
+	c.sndNxt = c.sndUna
+	c.finSent = false
+	c.output(true)
+}
+
+func (c *tcpConn) maybeFinish() {
+	if c.state == stateEstablished && c.finAcked && c.rcvFin {
+		c.state = stateClosed
+		c.stopTimer()
+		if c.linger != nil {
+			c.linger.Stop()
+		}
+		c.cond.Broadcast()
+		c.stack.remove(c.key, c)
+	}
+}
+
+// resetLocked terminates the connection, optionally telling the peer with RST.
+func (c *tcpConn) resetLocked(err error, sendRST bool) {
+	if c.state == stateClosed {
+		return
+	}
+	if sendRST {
+		c.stack.send(buildTCP(c.key.local, c.key.remote, c.sndNxt, c.rcvNxt, flagRST|flagACK, 0, 0, nil))
+	}
+	c.state = stateClosed
+	c.err = err
+	c.stopTimer()
+	if c.linger != nil {
+		c.linger.Stop()
+	}
+	c.cond.Broadcast()
+	c.stack.remove(c.key, c)
+}
+
+func (c *tcpConn) abort(sendRST bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resetLocked(net.ErrClosed, sendRST)
+}
+
+// wait blocks on the condition until woken or deadline passes.
+func (c *tcpConn) wait(deadline time.Time) {
+	if !deadline.IsZero() {
+		t := time.AfterFunc(time.Until(deadline), func() {
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	c.cond.Wait()
+}
+
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+func (c *tcpConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.rcvBuf) == 0 {
+		switch {
+		case c.appClosed:
+			return 0, net.ErrClosed
+		case c.rcvFin:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		case expired(c.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.wait(c.readDeadline)
+	}
+	n := copy(b, c.rcvBuf)
+	c.rcvBuf = c.rcvBuf[n:]
+	if len(c.rcvBuf) == 0 {
+		c.rcvBuf = nil
+	}
+	// 窗口几乎关闭后重新打开时主动通告，避免对端停等
+	if wnd := c.window(); c.state == stateEstablished && int(c.lastWnd) < 2*c.mss && int(wnd) >= 2*c.mss {
+		c.sendAck()
+	}
+	return n, nil
+}
+
+func (c *tcpConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	written := 0
+	for written < len(b) {
+		switch {
+		case c.appClosed || c.finQueued:
+			return written, net.ErrClosed
+		case c.err != nil:
+			return written, c.err
+		case c.state == stateClosed:
+			return written, net.ErrClosed
+		case expired(c.writeDeadline):
+			return written, os.ErrDeadlineExceeded
+		}
+		space := sndBufSize - len(c.sndBuf)
+		if space <= 0 {
+			c.wait(c.writeDeadline)
+			continue
+		}
+		n := min(space, len(b)-written)
+		c.sndBuf = append(c.sndBuf, b[written:written+n]...)
+		written += n
+		c.output(false)
+	}
+	return written, nil
+}
+
+// Close sends FIN after the queued data; the connection lingers until the peer closes too.
+func (c *tcpConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.appClosed {
+		return nil
+	}
+	c.appClosed = true
+	c.rcvBuf = nil
+	c.cond.Broadcast()
+	if c.state == stateClosed {
+		return nil
+	}
+	c.finQueued = true
+	c.output(false)
+	c.linger = time.AfterFunc(lingerTimeout, func() { c.abort(true) })
+	c.maybeFinish()
+	return nil
+}
+
+func (c *tcpConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: c.key.local.Addr().AsSlice(), Port: int(c.key.local.Port())}
+}
+
+func (c *tcpConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: c.key.remote.Addr().AsSlice(), Port: int(c.key.remote.Port())}
+}
+
+func (c *tcpConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *tcpConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *tcpConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	c.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/pkg/crypto"
+)
+
+// ServerAuth authorizes clients by the key identity they sign during the handshake.
+// A nil *ServerAuth accepts every client that knows the shared key.
+type ServerAuth struct {
+	users   map[string]string // identity hex -> user id
+	revoked *RevocationList
+}
+
+// NewServerAuth builds the authorized user table from cfg.Users and the
+// revocation list from cfg.RevokedKeys / cfg.RevocationFile.
+//
+// Revocation requires a users allowlist: an identity is only r·G for a split the
+// client chose itself, so anyone holding a revoked key can re-split the master key
+// into an identity that is not on the list. Only an allowlist rejects those.
+func NewServerAuth(cfg *config.Config) (*ServerAuth, error) {
+	a := &ServerAuth{users: make(map[string]string, len(cfg.Users))}
+	for name, identity := range cfg.Users {
+		raw, err := crypto.ParseIdentity(strings.TrimSpace(identity))
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %w", name, err)
+		}
+		a.users[hex.EncodeToString(raw)] = name
+	}
+	revoked, err := NewRevocationList(cfg.RevokedKeys, cfg.RevocationFile)
+	if err != nil {
+		return nil, err
+	}
+	if revoked.Enabled() && len(a.users) == 0 {
+		return nil, fmt.Errorf("revoked_keys/revocation_file require users: a revoked key can be re-split into an unlisted identity")
+	}
+	a.revoked = revoked
+	return a, nil
+}
+
+// Required reports whether clients must present an identity.
+func (a *ServerAuth) Required() bool {
+	return a != nil && len(a.users) > 0
+}
+
+// Revocations returns the revocation list consulted during the handshake.
+func (a *ServerAuth) Revocations() *RevocationList {
+	if a == nil {
+		return nil
+	}
+	return a.revoked
+}
+
+// authorize resolves the user id for the identity presented in the handshake.
+func (a *ServerAuth) authorize(ext *handshakeExt) (string, error) {
+	if !a.Required() {
+		return "", nil
+	}
+	if ext == nil || ext.identity == nil {
+		return "", fmt.Errorf("missing user identity")
+	}
+	identity := hex.EncodeToString(ext.identity)
+	if a.revoked.IsRevoked(identity) {
+		return "", fmt.Errorf("revoked user identity: %s", identity[:16])
+	}
+	user, ok := a.users[identity]
+	if !ok {
+		return "", fmt.Errorf("unknown user identity: %s", identity[:16])
+	}
+	return user, nil
+}
@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/pkg/crypto"
+	"github.com/saba-futai/sudoku/pkg/obfs/sudoku"
+)
+
+type handshakeOutcome struct {
+	user string
+	err  error
+}
+
+// runAuthHandshake performs one client handshake against a server using auth
+// and returns the server-side outcome.
+func runAuthHandshake(t *testing.T, cfg *config.Config, auth *ServerAuth, privateKey []byte) handshakeOutcome {
+	t.Helper()
+	table := sudoku.NewTable(cfg.Key, cfg.ASCII)
+
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+
+	done := make(chan handshakeOutcome, 1)
+	go func() {
+		conn, user, err := HandshakeAndUpgradeWithAuth(serverSide, cfg, []*sudoku.Table{table}, auth)
+		if conn != nil {
+			conn.Close()
+		}
+		done <- handshakeOutcome{user: user, err: err}
+	}()
+
+	go func() {
+		if conn, err := ClientHandshake(clientSide, cfg, table, 0, privateKey); err == nil {
+			// Drain server output so a fallback writer never blocks the pipe.
+			buf := make([]byte, 1024)
+			for {
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return <-done
+}
+
+func TestServerAuthResolvesUser(t *testing.T) {
+	pair, err := crypto.GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("keygen failed: %v", err)
+	}
+	aliceKey, _ := crypto.SplitPrivateKey(pair.Private)
+	bobKey, _ := crypto.SplitPrivateKey(pair.Private)
+	aliceID, _ := crypto.KeyIdentity(aliceKey)
+
+	cfg := &config.Config{
+		Key:                crypto.EncodePoint(pair.Public),
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		PaddingMin:         5,
+		PaddingMax:         15,
+		EnablePureDownlink: true,
+		DisableHTTPMask:    true,
+		UserAuth:           true,
+		Users:              map[string]string{"alice": aliceID},
+	}
+	auth, err := NewServerAuth(cfg)
+	if err != nil {
+		t.Fatalf("NewServerAuth failed: %v", err)
+	}
+
+	aliceRaw, _ := hex.DecodeString(aliceKey)
+	if out := runAuthHandshake(t, cfg, auth, aliceRaw); out.err != nil || out.user != "alice" {
+		t.Fatalf("alice should be authorized, got user=%q err=%v", out.user, out.err)
+	}
+
+	var suspErr *SuspiciousError
+	bobRaw, _ := hex.DecodeString(bobKey)
+	if out := runAuthHandshake(t, cfg, auth, bobRaw); !errors.As(out.err, &suspErr) {
+		t.Fatalf("unknown user should be suspicious, got %v", out.err)
+	}
+
+	anonymous := *cfg
+	anonymous.UserAuth = false
+	if out := runAuthHandshake(t, &anonymous, auth, aliceRaw); !errors.As(out.err, &suspErr) {
+		t.Fatalf("missing identity should be suspicious, got %v", out.err)
+	}
+
+	// Without configured users, identified and anonymous clients are both accepted.
+	open, _ := NewServerAuth(&config.Config{})
+	if out := runAuthHandshake(t, cfg, open, bobRaw); out.err != nil || out.user != "" {
+		t.Fatalf("open server should accept identified client, got user=%q err=%v", out.user, out.err)
+	}
+}
+
+func TestNewServerAuthRejectsInvalidIdentity(t *testing.T) {
+	cfg := &config.Config{Users: map[string]string{"broken": "not-hex"}}
+	if _, err := NewServerAuth(cfg); err == nil {
+		t.Fatalf("expected error for invalid identity")
+	}
+}
@@ -0,0 +1,187 @@
+package tunnel
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load-balancing strategies accepted by NewBalancedDialer.
+const (
+	BalanceRoundRobin     = "round-robin"
+	BalanceLeastConn      = "least-conn"
+	BalanceLatency        = "latency"
+	BalanceConsistentHash = "consistent-hash"
+)
+
+// hashReplicas is the number of virtual nodes per server on the hash ring.
+const hashReplicas = 64
+
+// BalancedDialer implements Dialer by spreading new connections over several servers.
+// The strategy ranks the servers for every connection; healthy servers are tried
+// first in that order and unhealthy ones only as a last resort, so a server that
+// stops accepting handshakes is skipped until a health probe succeeds again.
+type BalancedDialer struct {
+	upstreamGroup
+	strategy string
+
+	next atomic.Uint64 // round-robin cursor
+	ring []hashNode    // sorted, for consistent hashing
+}
+
+type hashNode struct {
+	hash     uint32
+	upstream int
+}
+
+// trackedConn counts a connection against its server until closed.
+type trackedConn struct {
+	net.Conn
+	upstream *Upstream
+	once     sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.upstream.active.Add(-1) })
+	return c.Conn.Close()
+}
+
+// NewBalancedDialer starts health checks every interval (<= 0 uses the default).
+func NewBalancedDialer(upstreams []*Upstream, strategy string, interval time.Duration) (*BalancedDialer, error) {
+	switch strategy {
+	case BalanceRoundRobin, BalanceLeastConn, BalanceLatency, BalanceConsistentHash:
+	default:
+		return nil, fmt.Errorf("unknown load balance strategy: %q", strategy)
+	}
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("no servers configured")
+	}
+	d := &BalancedDialer{upstreamGroup: newUpstreamGroup(upstreams), strategy: strategy}
+	if strategy == BalanceConsistentHash {
+		d.ring = buildHashRing(upstreams)
+	}
+	go d.healthLoop(interval, d.checkUpstreams)
+	return d, nil
+}
+
+func (d *BalancedDialer) Dial(destAddrStr string) (net.Conn, error) {
+	return d.dialWith(destAddrStr, func(u *Upstream) (net.Conn, error) { return u.Dialer.Dial(destAddrStr) })
+}
+
+// DialUDPOverTCP opens a UoT tunnel. A UDP association has no single destination,
+// so consistent hashing falls back to round-robin for it.
+func (d *BalancedDialer) DialUDPOverTCP() (net.Conn, error) {
+	return d.dialWith("", (*Upstream).dialUoT)
+}
+
+// DialBind forwards a SOCKS5 BIND; consistent hashing keys on the expected peer.
+func (d *BalancedDialer) DialBind(peerAddr string) (net.Conn, error) {
+	return d.dialWith(peerAddr, func(u *Upstream) (net.Conn, error) { return u.dialBind(peerAddr) })
+}
+
+func (d *BalancedDialer) dialWith(dest string, dial func(*Upstream) (net.Conn, error)) (net.Conn, error) {
+	conn, i, err := d.dialInOrder(d.order(dest), dial)
+	if err != nil {
+		return nil, err
+	}
+	u := d.upstreams[i]
+	u.active.Add(1)
+	return &trackedConn{Conn: conn, upstream: u}, nil
+}
+
+// order ranks all servers for one connection, healthy ones first.
+func (d *BalancedDialer) order(dest string) []int {
+	var ranked []int
+	switch {
+	case d.strategy == BalanceConsistentHash && dest != "":
+		ranked = d.ringOrder(hostOf(dest))
+	case d.strategy == BalanceLeastConn:
+		ranked = d.rotated()
+		sort.SliceStable(ranked, func(a, b int) bool {
+			return d.upstreams[ranked[a]].ActiveConns() < d.upstreams[ranked[b]].ActiveConns()
+		})
+	case d.strategy == BalanceLatency:
+		ranked = d.rotated()
+		sort.SliceStable(ranked, func(a, b int) bool {
+			return latencyRank(d.upstreams[ranked[a]]) < latencyRank(d.upstreams[ranked[b]])
+		})
+	default:
+		ranked = d.rotated()
+	}
+
+	healthy := make([]int, 0, len(ranked))
+	var unhealthy []int
+	for _, i := range ranked {
+		if d.upstreams[i].Healthy() {
+			healthy = append(healthy, i)
+		} else {
+			unhealthy = append(unhealthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// rotated returns all indexes starting at the round-robin cursor, which also
+// spreads ties for the other strategies.
+func (d *BalancedDialer) rotated() []int {
+	n := len(d.upstreams)
+	start := int(d.next.Add(1) % uint64(n))
+	out := make([]int, n)
+	for i := range out {
+		out[i] = (start + i) % n
+	}
+	return out
+}
+
+func latencyRank(u *Upstream) time.Duration {
+	if l := u.Latency(); l > 0 {
+		return l
+	}
+	return time.Duration(1<<63 - 1) // not measured yet
+}
+
+func buildHashRing(upstreams []*Upstream) []hashNode {
+	ring := make([]hashNode, 0, len(upstreams)*hashReplicas)
+	for i, u := range upstreams {
+		for r := 0; r < hashReplicas; r++ {
+			ring = append(ring, hashNode{hash: hashKey(u.Address + "#" + strconv.Itoa(r)), upstream: i})
+		}
+	}
+	sort.Slice(ring, func(a, b int) bool { return ring[a].hash < ring[b].hash })
+	return ring
+}
+
+// ringOrder walks the ring clockwise from the key and lists each server once,
+// so the same host keeps landing on the same server while it is healthy.
+func (d *BalancedDialer) ringOrder(key string) []int {
+	h := hashKey(key)
+	start := sort.Search(len(d.ring), func(i int) bool { return d.ring[i].hash >= h })
+	seen := make([]bool, len(d.upstreams))
+	out := make([]int, 0, len(d.upstreams))
+	for i := 0; i < len(d.ring) && len(out) < len(d.upstreams); i++ {
+		node := d.ring[(start+i)%len(d.ring)]
+		if !seen[node.upstream] {
+			seen[node.upstream] = true
+			out = append(out, node.upstream)
+		}
+	}
+	return out
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
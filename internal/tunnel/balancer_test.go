@@ -0,0 +1,136 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newFakeUpstreams(names ...string) ([]*Upstream, map[string]*fakeServerDialer) {
+	fakes := make(map[string]*fakeServerDialer)
+	var ups []*Upstream
+	for _, n := range names {
+		f := &fakeServerDialer{name: n}
+		fakes[n] = f
+		ups = append(ups, NewUpstream(n, f))
+	}
+	return ups, fakes
+}
+
+func TestBalancedDialerRoundRobin(t *testing.T) {
+	ups, fakes := newFakeUpstreams("a", "b", "c")
+	d, err := NewBalancedDialer(ups, BalanceRoundRobin, time.Hour)
+	if err != nil {
+		t.Fatalf("NewBalancedDialer failed: %v", err)
+	}
+	defer d.Close()
+
+	for i := 0; i < 9; i++ {
+		c, err := d.Dial("example.com:443")
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		c.Close()
+	}
+	for name, f := range fakes {
+		if f.dials.Load() != 3 {
+			t.Fatalf("%s got %d dials, want 3", name, f.dials.Load())
+		}
+	}
+
+	// A failing server is skipped and the connection still succeeds.
+	fakes["b"].down.Store(true)
+	for i := 0; i < 6; i++ {
+		if _, err := d.Dial("example.com:443"); err != nil {
+			t.Fatalf("dial should skip the failed server: %v", err)
+		}
+	}
+	if got := fakes["b"].dials.Load(); got > 4 {
+		t.Fatalf("failed server should be tried at most once before being marked down, got %d", got)
+	}
+}
+
+func TestBalancedDialerLeastConn(t *testing.T) {
+	ups, _ := newFakeUpstreams("a", "b")
+	d, _ := NewBalancedDialer(ups, BalanceLeastConn, time.Hour)
+	defer d.Close()
+
+	var held []net.Conn
+	for i := 0; i < 4; i++ {
+		c, _ := d.Dial("example.com:443")
+		held = append(held, c)
+	}
+	if ups[0].ActiveConns() != 2 || ups[1].ActiveConns() != 2 {
+		t.Fatalf("connections not spread: %d/%d", ups[0].ActiveConns(), ups[1].ActiveConns())
+	}
+	// Free both slots on a; the next two connections must go there.
+	for _, c := range held {
+		if c.(*trackedConn).upstream == ups[0] {
+			c.Close()
+		}
+	}
+	d.Dial("example.com:443")
+	d.Dial("example.com:443")
+	if ups[0].ActiveConns() != 2 || ups[1].ActiveConns() != 2 {
+		t.Fatalf("least-conn did not refill the idle server: %d/%d", ups[0].ActiveConns(), ups[1].ActiveConns())
+	}
+}
+
+func TestBalancedDialerLatency(t *testing.T) {
+	ups, fakes := newFakeUpstreams("slow", "fast")
+	fakes["slow"].rtt = 80 * time.Millisecond
+	fakes["fast"].rtt = 10 * time.Millisecond
+	d, _ := NewBalancedDialer(ups, BalanceLatency, time.Hour)
+	defer d.Close()
+	d.checkUpstreams()
+
+	for i := 0; i < 4; i++ {
+		d.Dial("example.com:443")
+	}
+	if fakes["fast"].dials.Load() != 4 {
+		t.Fatalf("lowest latency server should take every connection")
+	}
+}
+
+func TestBalancedDialerConsistentHash(t *testing.T) {
+	ups, fakes := newFakeUpstreams("a:1", "b:1", "c:1")
+	d, _ := NewBalancedDialer(ups, BalanceConsistentHash, time.Hour)
+	defer d.Close()
+
+	pick := func(dest string) *Upstream {
+		c, err := d.Dial(dest)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		defer c.Close()
+		return c.(*trackedConn).upstream
+	}
+
+	first := pick("video.example.com:443")
+	for i := 0; i < 5; i++ {
+		if pick("video.example.com:80") != first {
+			t.Fatalf("same host must map to the same server regardless of port")
+		}
+	}
+
+	spread := make(map[*Upstream]bool)
+	for i := 0; i < 50; i++ {
+		spread[pick("host"+string(rune('a'+i%26))+".example:443")] = true
+	}
+	if len(spread) < 2 {
+		t.Fatalf("hashing should spread hosts over servers")
+	}
+
+	fakes[first.Address].down.Store(true)
+	first.markFailed()
+	if pick("video.example.com:443") == first {
+		t.Fatalf("unhealthy server must be skipped")
+	}
+}
+
+func TestBalancedDialerRejectsUnknownStrategy(t *testing.T) {
+	ups, _ := newFakeUpstreams("a")
+	if _, err := NewBalancedDialer(ups, "random", time.Hour); err == nil {
+		t.Fatalf("expected error for unknown strategy")
+	}
+}
@@ -0,0 +1,200 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/protocol"
+)
+
+// BindMagicByte marks a Sudoku tunnel connection that carries a SOCKS5 BIND request.
+// The client sends the version and the expected peer address after it; the server answers
+// with two replies (listening address, then the accepted peer) and then relays raw bytes.
+const BindMagicByte byte = 0xEB
+
+const bindVersion = 0x01
+
+// BindAcceptTimeout bounds how long a BIND listener waits for its peer.
+const BindAcceptTimeout = 2 * time.Minute
+
+// BIND reply status codes.
+const (
+	BindSucceeded byte = 0x00
+	BindFailed    byte = 0x01
+)
+
+// BindDialer extends Dialer with SOCKS5 BIND: a listening socket opened on the server.
+type BindDialer interface {
+	Dialer
+	DialBind(peerAddr string) (net.Conn, error)
+}
+
+// WriteBindRequest writes the BIND marker, version and the address of the expected peer.
+func WriteBindRequest(w io.Writer, peerAddr string) error {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{BindMagicByte, bindVersion})
+	if err := protocol.WriteAddress(buf, peerAddr); err != nil {
+		return fmt.Errorf("encode address: %w", err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteBindReply writes one BIND reply: status followed by an address.
+func WriteBindReply(w io.Writer, status byte, addr string) error {
+	if addr == "" {
+		addr = "0.0.0.0:0"
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteByte(status)
+	if err := protocol.WriteAddress(buf, addr); err != nil {
+		return fmt.Errorf("encode address: %w", err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadBindReply reads one BIND reply.
+func ReadBindReply(r io.Reader) (byte, string, error) {
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(r, status); err != nil {
+		return 0, "", err
+	}
+	addr, _, _, err := protocol.ReadAddress(r)
+	if err != nil {
+		return 0, "", fmt.Errorf("decode address: %w", err)
+	}
+	return status[0], addr, nil
+}
+
+// BindListener waits for the single inbound connection of a BIND request.
+type BindListener struct {
+	l       *net.TCPListener
+	addr    *net.TCPAddr
+	allowed []net.IP
+}
+
+// ListenBind opens a listener on all interfaces and advertises it on advertise (if set).
+// When peerAddr names a host, only connections from its addresses are accepted; a host that
+// cannot be resolved is an error rather than a listener open to everyone.
+func ListenBind(peerAddr string, advertise net.IP) (*BindListener, error) {
+	host, _, err := net.SplitHostPort(peerAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer address %q: %w", peerAddr, err)
+	}
+	var allowed []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		if !ip.IsUnspecified() {
+			allowed = []net.IP{ip}
+		}
+	} else {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return nil, fmt.Errorf("resolve peer %s: %w", host, err)
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("resolve peer %s: no addresses", host)
+		}
+		allowed = ips
+	}
+
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	addr := &net.TCPAddr{IP: advertise, Port: l.Addr().(*net.TCPAddr).Port}
+	if advertise == nil || advertise.IsUnspecified() {
+		addr.IP = net.IPv4zero
+	}
+	return &BindListener{l: l, addr: addr, allowed: allowed}, nil
+}
+
+// Addr is the address to report in the first BIND reply.
+func (b *BindListener) Addr() *net.TCPAddr { return b.addr }
+
+// Accept waits up to BindAcceptTimeout for the expected peer, then closes the listener.
+func (b *BindListener) Accept() (net.Conn, error) {
+	defer b.l.Close()
+	b.l.SetDeadline(time.Now().Add(BindAcceptTimeout))
+	for {
+		c, err := b.l.AcceptTCP()
+		if err != nil {
+			return nil, err
+		}
+		if b.permitted(c.RemoteAddr().(*net.TCPAddr).IP) {
+			return c, nil
+		}
+		c.Close()
+	}
+}
+
+func (b *BindListener) permitted(ip net.IP) bool {
+	if len(b.allowed) == 0 {
+		return true
+	}
+	for _, a := range b.allowed {
+		if a.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close releases the listener.
+func (b *BindListener) Close() error { return b.l.Close() }
+
+// ErrBindDisabled is returned by HandleBindServer when the server does not allow BIND.
+var ErrBindDisabled = errors.New("bind disabled on this server")
+
+// HandleBindServer serves a BIND request whose magic byte has already been consumed.
+// It returns the accepted peer after both replies have been sent; the caller relays the data.
+// When allow is false the request is answered with BindFailed. advertise overrides the
+// address reported in the first reply; nil uses the local address of conn, which is wrong
+// behind NAT or a reverse proxy.
+func HandleBindServer(conn net.Conn, allow bool, advertise net.IP) (net.Conn, error) {
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return nil, fmt.Errorf("read bind version: %w", err)
+	}
+	if version[0] != bindVersion {
+		return nil, fmt.Errorf("unsupported bind version: %d", version[0])
+	}
+	peerAddr, _, _, err := protocol.ReadAddress(conn)
+	if err != nil {
+		return nil, fmt.Errorf("read bind address: %w", err)
+	}
+
+	if !allow {
+		WriteBindReply(conn, BindFailed, "")
+		return nil, ErrBindDisabled
+	}
+	// 未配置通告地址时，客户端连进来的本地地址即对外可达的地址
+	if advertise == nil {
+		if la, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+			advertise = la.IP
+		}
+	}
+	bl, err := ListenBind(peerAddr, advertise)
+	if err != nil {
+		WriteBindReply(conn, BindFailed, "")
+		return nil, fmt.Errorf("listen for bind: %w", err)
+	}
+	if err := WriteBindReply(conn, BindSucceeded, bl.Addr().String()); err != nil {
+		bl.Close()
+		return nil, err
+	}
+	peer, err := bl.Accept()
+	if err != nil {
+		WriteBindReply(conn, BindFailed, "")
+		return nil, fmt.Errorf("accept bind peer: %w", err)
+	}
+	if err := WriteBindReply(conn, BindSucceeded, peer.RemoteAddr().String()); err != nil {
+		peer.Close()
+		return nil, err
+	}
+	return peer, nil
+}
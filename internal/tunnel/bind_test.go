@@ -0,0 +1,54 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenBindRejectsUnresolvablePeer(t *testing.T) {
+	// 无法解析期望的对端时必须失败，而不是接受任意来源
+	if bl, err := ListenBind("peer.invalid:21", nil); err == nil {
+		bl.Close()
+		t.Fatalf("expected error for unresolvable peer")
+	}
+}
+
+func TestHandleBindServerDisabled(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := HandleBindServer(server, false, nil)
+		done <- err
+	}()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	// WriteBindRequest 含魔数字节，服务端入口已经读掉了它
+	if err := WriteBindRequest(&skipFirst{w: client}, "127.0.0.1:21"); err != nil {
+		t.Fatalf("write request: %v", err)
+	}
+	status, _, err := ReadBindReply(client)
+	if err != nil || status != BindFailed {
+		t.Fatalf("disabled server replied status=%d err=%v", status, err)
+	}
+	if err := <-done; err != ErrBindDisabled {
+		t.Fatalf("HandleBindServer = %v, want ErrBindDisabled", err)
+	}
+}
+
+// skipFirst drops the first byte written, standing in for the dispatcher that consumes the magic byte.
+type skipFirst struct {
+	w       net.Conn
+	skipped bool
+}
+
+func (s *skipFirst) Write(p []byte) (int, error) {
+	if !s.skipped && len(p) > 0 {
+		s.skipped = true
+		n, err := s.w.Write(p[1:])
+		return n + 1, err
+	}
+	return s.w.Write(p)
+}
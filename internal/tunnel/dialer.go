@@ -3,10 +3,9 @@ package tunnel
 import (
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/binary"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/saba-futai/sudoku/internal/config"
@@ -27,6 +26,26 @@ type BaseDialer struct {
 	Config     *config.Config
 	Tables     []*sudoku.Table
 	PrivateKey []byte
+
+	// Pool, when set, supplies pre-handshaked connections (see EnablePool).
+	Pool *ConnPool
+}
+
+// EnablePool keeps up to size handshaked connections ready for Dial,
+// each retired after roughly maxIdle.
+func (d *BaseDialer) EnablePool(size int, maxIdle time.Duration) {
+	if size <= 0 {
+		return
+	}
+	d.Pool = NewConnPool(d.dialNew, size, maxIdle)
+}
+
+// Close stops the connection pool, if one was enabled.
+func (d *BaseDialer) Close() error {
+	if d.Pool != nil {
+		return d.Pool.Close()
+	}
+	return nil
 }
 
 func (d *BaseDialer) pickTable() (byte, *sudoku.Table, error) {
@@ -46,6 +65,15 @@ func (d *BaseDialer) pickTable() (byte, *sudoku.Table, error) {
 }
 
 func (d *BaseDialer) dialBase() (net.Conn, error) {
+	if d.Pool != nil {
+		if conn, ok := d.Pool.Get(); ok {
+			return conn, nil
+		}
+	}
+	return d.dialNew()
+}
+
+func (d *BaseDialer) dialNew() (net.Conn, error) {
 	// Resolve server address with DNS concurrency and optimistic cache.
 	resolveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -94,32 +122,39 @@ func ClientHandshake(conn net.Conn, cfg *config.Config, table *sudoku.Table, tab
 	}
 
 	// 5. Handshake
-	handshake := make([]byte, 16)
-	binary.BigEndian.PutUint64(handshake[:8], uint64(time.Now().Unix()))
-
-	if len(privateKey) > 0 {
-		// Use deterministic nonce from Private Key
-		hash := sha256.Sum256(privateKey)
-		copy(handshake[8:], hash[:8])
-	} else {
-		// Fallback to random if no private key (legacy/server mode)
-		if _, err := rand.Read(handshake[8:]); err != nil {
-			return nil, fmt.Errorf("generate nonce failed: %w", err)
-		}
+	handshake, err := newHandshakeHeader(tableID)
+	if err != nil {
+		return nil, err
 	}
-	handshake[8] = tableID
 
 	if _, err := cConn.Write(handshake); err != nil {
 		cConn.Close()
 		return nil, fmt.Errorf("handshake failed: %w", err)
 	}
 
-	modeByte := []byte{downlinkModeByte(cfg)}
-	if _, err := cConn.Write(modeByte); err != nil {
+	mode := downlinkModeByte(cfg)
+	extFlags := clientExtFlags(cfg, privateKey)
+	if extFlags != 0 {
+		mode |= handshakeFlagExtended
+	}
+	if _, err := cConn.Write([]byte{mode}); err != nil {
 		cConn.Close()
 		return nil, fmt.Errorf("write downlink mode failed: %w", err)
 	}
 
+	// 6. Optional extensions (user identity, ephemeral key exchange)
+	if extFlags != 0 {
+		ext, err := writeHandshakeExt(cConn, handshake, mode, extFlags, privateKey)
+		if err != nil {
+			cConn.Close()
+			return nil, fmt.Errorf("write handshake extensions failed: %w", err)
+		}
+		if err := finishKeyExchange(cConn, cfg, ext); err != nil {
+			cConn.Close()
+			return nil, fmt.Errorf("key exchange failed: %w", err)
+		}
+	}
+
 	return cConn, nil
 }
 
@@ -135,6 +170,18 @@ func (d *BaseDialer) dialUoT() (net.Conn, error) {
 	return conn, nil
 }
 
+func (d *BaseDialer) dialBind(peerAddr string) (net.Conn, error) {
+	conn, err := d.dialBase()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteBindRequest(conn, peerAddr); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("bind request failed: %w", err)
+	}
+	return conn, nil
+}
+
 // StandardDialer implements Dialer for standard Sudoku mode.
 type StandardDialer struct {
 	BaseDialer
@@ -159,3 +206,100 @@ func (d *StandardDialer) Dial(destAddrStr string) (net.Conn, error) {
 func (d *StandardDialer) DialUDPOverTCP() (net.Conn, error) {
 	return d.dialUoT()
 }
+
+// DialBind asks the server to listen for peerAddr on behalf of a SOCKS5 BIND.
+func (d *StandardDialer) DialBind(peerAddr string) (net.Conn, error) {
+	return d.dialBind(peerAddr)
+}
+
+// MuxDialer implements Dialer by opening streams on one shared, multiplexed tunnel.
+// The tunnel is (re)established on demand when the previous one has failed.
+type MuxDialer struct {
+	BaseDialer
+
+	mu      sync.Mutex
+	session *MuxSession
+}
+
+func (d *MuxDialer) getSession() (*MuxSession, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session != nil && !d.session.IsClosed() {
+		return d.session, nil
+	}
+	conn, err := d.dialBase()
+	if err != nil {
+		return nil, err
+	}
+	session, err := NewMuxClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	d.session = session
+	return session, nil
+}
+
+func (d *MuxDialer) openStream() (net.Conn, error) {
+	session, err := d.getSession()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := session.OpenStream()
+	if err != nil {
+		// The session broke underneath us; retry once on a fresh tunnel.
+		if session, err = d.getSession(); err != nil {
+			return nil, err
+		}
+		return session.OpenStream()
+	}
+	return stream, nil
+}
+
+func (d *MuxDialer) Dial(destAddrStr string) (net.Conn, error) {
+	stream, err := d.openStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := protocol.WriteAddress(stream, destAddrStr); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("write address failed: %w", err)
+	}
+	return stream, nil
+}
+
+// DialUDPOverTCP opens a UoT session as a stream of the shared tunnel.
+func (d *MuxDialer) DialUDPOverTCP() (net.Conn, error) {
+	stream, err := d.openStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteUoTPreface(stream); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("uot preface failed: %w", err)
+	}
+	return stream, nil
+}
+
+// DialBind sends a BIND request on a new stream of the shared tunnel.
+func (d *MuxDialer) DialBind(peerAddr string) (net.Conn, error) {
+	stream, err := d.openStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteBindRequest(stream, peerAddr); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("bind request failed: %w", err)
+	}
+	return stream, nil
+}
+
+// Close shuts down the shared tunnel and all streams on it.
+func (d *MuxDialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.session != nil {
+		return d.session.Close()
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// FailoverDialer implements Dialer over an ordered list of servers.
+// New connections go to the active server, which is the first healthy one in
+// configuration order. A failed dial marks the server unhealthy and moves on to
+// the next, and periodic probes bring recovered servers back.
+type FailoverDialer struct {
+	upstreamGroup
+
+	mu     sync.Mutex
+	active int
+}
+
+// NewFailoverDialer starts health checks every interval (<= 0 uses the default).
+func NewFailoverDialer(upstreams []*Upstream, interval time.Duration) (*FailoverDialer, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("no servers configured")
+	}
+	d := &FailoverDialer{upstreamGroup: newUpstreamGroup(upstreams)}
+	go d.healthLoop(interval, d.checkAll)
+	return d, nil
+}
+
+// Active returns the server currently receiving new connections.
+func (d *FailoverDialer) Active() *Upstream {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.upstreams[d.active]
+}
+
+func (d *FailoverDialer) Dial(destAddrStr string) (net.Conn, error) {
+	return d.dialWith(func(u *Upstream) (net.Conn, error) { return u.Dialer.Dial(destAddrStr) })
+}
+
+// DialUDPOverTCP opens a UoT tunnel on the active server, failing over like Dial.
+func (d *FailoverDialer) DialUDPOverTCP() (net.Conn, error) {
+	return d.dialWith((*Upstream).dialUoT)
+}
+
+// DialBind forwards a SOCKS5 BIND to the active server, failing over like Dial.
+func (d *FailoverDialer) DialBind(peerAddr string) (net.Conn, error) {
+	return d.dialWith(func(u *Upstream) (net.Conn, error) { return u.dialBind(peerAddr) })
+}
+
+// candidates lists the active server first, then the other healthy servers,
+// then the unhealthy ones as a last resort, each group in configuration order.
+func (d *FailoverDialer) candidates() []int {
+	d.mu.Lock()
+	active := d.active
+	d.mu.Unlock()
+
+	order := []int{active}
+	var unhealthy []int
+	for i, u := range d.upstreams {
+		if i == active {
+			continue
+		}
+		if u.Healthy() {
+			order = append(order, i)
+		} else {
+			unhealthy = append(unhealthy, i)
+		}
+	}
+	return append(order, unhealthy...)
+}
+
+func (d *FailoverDialer) dialWith(dial func(*Upstream) (net.Conn, error)) (net.Conn, error) {
+	conn, i, err := d.dialInOrder(d.candidates(), dial)
+	if err != nil {
+		return nil, err
+	}
+	d.setActive(i)
+	return conn, nil
+}
+
+func (d *FailoverDialer) setActive(i int) {
+	d.mu.Lock()
+	prev := d.active
+	d.active = i
+	d.mu.Unlock()
+	if prev != i {
+		log.Printf("[Failover] Active server: %s -> %s", d.upstreams[prev].Address, d.upstreams[i].Address)
+	}
+}
+
+func (d *FailoverDialer) checkAll() {
+	d.checkUpstreams()
+
+	// Prefer the first healthy server; this also fails back to a recovered primary.
+	for i, u := range d.upstreams {
+		if u.Healthy() {
+			d.setActive(i)
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeServerDialer struct {
+	name  string
+	rtt   time.Duration
+	down  atomic.Bool
+	dials atomic.Int32
+}
+
+func (f *fakeServerDialer) Dial(string) (net.Conn, error) {
+	f.dials.Add(1)
+	if f.down.Load() {
+		return nil, errors.New(f.name + " unreachable")
+	}
+	c, _ := net.Pipe()
+	return c, nil
+}
+
+func (f *fakeServerDialer) Probe() (time.Duration, error) {
+	if f.down.Load() {
+		return 0, errors.New(f.name + " unreachable")
+	}
+	if f.rtt > 0 {
+		return f.rtt, nil
+	}
+	return time.Millisecond, nil
+}
+
+func TestFailoverDialerSwitchesAndFailsBack(t *testing.T) {
+	primary := &fakeServerDialer{name: "primary"}
+	backup := &fakeServerDialer{name: "backup"}
+	d, err := NewFailoverDialer([]*Upstream{
+		NewUpstream("primary", primary),
+		NewUpstream("backup", backup),
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFailoverDialer failed: %v", err)
+	}
+	defer d.Close()
+
+	if _, err := d.Dial("example.com:80"); err != nil || d.Active().Address != "primary" {
+		t.Fatalf("expected primary, got %s (%v)", d.Active().Address, err)
+	}
+
+	primary.down.Store(true)
+	if _, err := d.Dial("example.com:80"); err != nil {
+		t.Fatalf("dial should fail over: %v", err)
+	}
+	if d.Active().Address != "backup" {
+		t.Fatalf("expected backup to become active")
+	}
+
+	// Unhealthy servers are tried last, so the next dial goes straight to the backup.
+	before := primary.dials.Load()
+	d.Dial("example.com:80")
+	if primary.dials.Load() != before {
+		t.Fatalf("active backup should be used without retrying the primary")
+	}
+
+	primary.down.Store(false)
+	d.checkAll()
+	if d.Active().Address != "primary" || !d.upstreams[0].Healthy() {
+		t.Fatalf("recovered primary should become active again")
+	}
+}
+
+func TestFailoverDialerAllDown(t *testing.T) {
+	a := &fakeServerDialer{name: "a"}
+	a.down.Store(true)
+	d, err := NewFailoverDialer([]*Upstream{NewUpstream("a", a)}, time.Hour)
+	if err != nil {
+		t.Fatalf("NewFailoverDialer failed: %v", err)
+	}
+	defer d.Close()
+	if _, err := d.Dial("example.com:80"); err == nil {
+		t.Fatalf("expected error when every server is down")
+	}
+}
+
+func TestFailoverDialerRejectsEmptyList(t *testing.T) {
+	if d, err := NewFailoverDialer(nil, time.Hour); err == nil {
+		d.Close()
+		t.Fatalf("expected an error for an empty server list")
+	}
+}
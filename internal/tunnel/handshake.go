@@ -0,0 +1,203 @@
+package tunnel
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/pkg/crypto"
+)
+
+// The downlink mode byte that follows the 16-byte handshake header carries the
+// downlink mode in its low nibble. Its high bit announces an extension block,
+// so servers that predate the extensions reject such clients instead of
+// misreading the block as a target address.
+const (
+	handshakeHeaderSize         = 16
+	downlinkModeMask       byte = 0x0F
+	handshakeFlagExtended  byte = 0x80
+	extFlagUserAuth        byte = 0x01
+	extFlagKeyExchange     byte = 0x02
+	extFlagCounterNonce    byte = 0x04 // requires extFlagKeyExchange: counters restart with every session key
+	knownHandshakeExtFlags      = extFlagUserAuth | extFlagKeyExchange | extFlagCounterNonce
+)
+
+// handshakeExt holds the optional extension block sent by the client:
+//
+//	flags(1) | [ephemeral public key(32)] | [identity(32) | signature(64)]
+//
+// The signature covers every handshake byte preceding it, including the
+// ephemeral key, so an authenticated session cannot be hijacked mid-exchange.
+// When a key exchange is requested the server answers with its own ephemeral
+// public key and both sides switch to the derived session key.
+type handshakeExt struct {
+	flags    byte
+	identity []byte
+
+	peerPublic []byte           // client ephemeral key (server side)
+	ephemeral  *ecdh.PrivateKey // our ephemeral key (client side)
+	transcript []byte           // header | mode | flags | [ephemeral public key]
+}
+
+func newHandshakeHeader(tableID byte) ([]byte, error) {
+	header := make([]byte, handshakeHeaderSize)
+	binary.BigEndian.PutUint64(header[:8], uint64(time.Now().Unix()))
+	if _, err := rand.Read(header[8:]); err != nil {
+		return nil, fmt.Errorf("generate nonce failed: %w", err)
+	}
+	header[8] = tableID
+	return header, nil
+}
+
+func clientExtFlags(cfg *config.Config, privateKey []byte) byte {
+	var flags byte
+	if cfg.UserAuth && len(privateKey) > 0 {
+		flags |= extFlagUserAuth
+	}
+	// Without AEAD there is nothing to key.
+	if (cfg.KeyExchange || cfg.CounterNonce) && cfg.AEAD != "none" {
+		flags |= extFlagKeyExchange
+		if cfg.CounterNonce {
+			flags |= extFlagCounterNonce
+		}
+	}
+	return flags
+}
+
+// writeHandshakeExt writes the extension block announced by the mode byte.
+func writeHandshakeExt(w io.Writer, header []byte, mode byte, flags byte, privateKey []byte) (*handshakeExt, error) {
+	ext := &handshakeExt{flags: flags}
+	transcript := make([]byte, 0, len(header)+2+crypto.EphemeralKeySize)
+	transcript = append(transcript, header...)
+	transcript = append(transcript, mode, flags)
+
+	if flags&extFlagKeyExchange != 0 {
+		priv, err := crypto.GenerateEphemeralKey()
+		if err != nil {
+			return nil, fmt.Errorf("generate ephemeral key failed: %w", err)
+		}
+		ext.ephemeral = priv
+		transcript = append(transcript, priv.PublicKey().Bytes()...)
+	}
+	ext.transcript = transcript
+
+	block := append([]byte(nil), transcript[len(header)+1:]...)
+	if flags&extFlagUserAuth != 0 {
+		identity, sig, err := crypto.SignWithIdentity(privateKey, transcript)
+		if err != nil {
+			return nil, fmt.Errorf("sign identity failed: %w", err)
+		}
+		block = append(block, identity...)
+		block = append(block, sig...)
+	}
+	if _, err := w.Write(block); err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// readHandshakeExt parses and verifies the extension block sent by the client.
+func readHandshakeExt(r io.Reader, header []byte, mode byte) (*handshakeExt, error) {
+	flagBuf := []byte{0}
+	if _, err := io.ReadFull(r, flagBuf); err != nil {
+		return nil, fmt.Errorf("read handshake extensions failed: %w", err)
+	}
+	ext := &handshakeExt{flags: flagBuf[0]}
+	if ext.flags&^knownHandshakeExtFlags != 0 {
+		return nil, fmt.Errorf("unknown handshake extensions: %#x", ext.flags)
+	}
+	if ext.flags&extFlagCounterNonce != 0 && ext.flags&extFlagKeyExchange == 0 {
+		return nil, fmt.Errorf("counter nonces without key exchange")
+	}
+
+	transcript := make([]byte, 0, len(header)+2+crypto.EphemeralKeySize)
+	transcript = append(transcript, header...)
+	transcript = append(transcript, mode, ext.flags)
+
+	if ext.flags&extFlagKeyExchange != 0 {
+		pub := make([]byte, crypto.EphemeralKeySize)
+		if _, err := io.ReadFull(r, pub); err != nil {
+			return nil, fmt.Errorf("read ephemeral key failed: %w", err)
+		}
+		ext.peerPublic = pub
+		transcript = append(transcript, pub...)
+	}
+	ext.transcript = transcript
+
+	if ext.flags&extFlagUserAuth != 0 {
+		buf := make([]byte, crypto.IdentitySize+crypto.IdentitySignatureSize)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("read user identity failed: %w", err)
+		}
+		identity, sig := buf[:crypto.IdentitySize], buf[crypto.IdentitySize:]
+		if !crypto.VerifyIdentity(identity, transcript, sig) {
+			return nil, fmt.Errorf("invalid user identity signature")
+		}
+		ext.identity = identity
+	}
+	return ext, nil
+}
+
+// acceptKeyExchange answers a client key exchange with a fresh server ephemeral key
+// and switches conn to the derived session key. It is a no-op if none was requested.
+func acceptKeyExchange(conn *crypto.AEADConn, cfg *config.Config, ext *handshakeExt) error {
+	if ext == nil || ext.flags&extFlagKeyExchange == 0 {
+		return nil
+	}
+	priv, err := crypto.GenerateEphemeralKey()
+	if err != nil {
+		return fmt.Errorf("generate ephemeral key failed: %w", err)
+	}
+	pub := priv.PublicKey().Bytes()
+	sessionKey, err := crypto.DeriveSessionKey(priv, ext.peerPublic, cfg.Key, append(ext.transcript, pub...))
+	if err != nil {
+		return err
+	}
+	// The reply still travels under the pre-shared key; everything after it uses the session key.
+	if _, err := conn.Write(pub); err != nil {
+		return fmt.Errorf("write ephemeral key failed: %w", err)
+	}
+	return installSessionKey(conn, cfg, ext.flags, sessionKey, false)
+}
+
+// finishKeyExchange reads the server ephemeral key and switches conn to the session key.
+func finishKeyExchange(conn *crypto.AEADConn, cfg *config.Config, ext *handshakeExt) error {
+	if ext == nil || ext.flags&extFlagKeyExchange == 0 {
+		return nil
+	}
+	pub := make([]byte, crypto.EphemeralKeySize)
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	if _, err := io.ReadFull(conn, pub); err != nil {
+		return fmt.Errorf("read server ephemeral key failed: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	sessionKey, err := crypto.DeriveSessionKey(ext.ephemeral, pub, cfg.Key, append(ext.transcript, pub...))
+	if err != nil {
+		return err
+	}
+	return installSessionKey(conn, cfg, ext.flags, sessionKey, true)
+}
+
+// installSessionKey keys conn for the negotiated framing.
+func installSessionKey(conn *crypto.AEADConn, cfg *config.Config, flags byte, sessionKey []byte, client bool) error {
+	if flags&extFlagCounterNonce == 0 {
+		return conn.SetSessionKey(sessionKey)
+	}
+	c2s, s2c, err := crypto.DirectionKeys(sessionKey)
+	if err != nil {
+		return err
+	}
+	sendKey, recvKey := s2c, c2s
+	if client {
+		sendKey, recvKey = c2s, s2c
+	}
+	if err := conn.EnableCounterNonce(sendKey, recvKey); err != nil {
+		return err
+	}
+	conn.SetRekeyPolicy(cfg.RekeyBytes, time.Duration(cfg.RekeyInterval)*time.Second)
+	return nil
+}
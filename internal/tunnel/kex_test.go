@@ -0,0 +1,75 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/pkg/crypto"
+	"github.com/saba-futai/sudoku/pkg/obfs/sudoku"
+)
+
+func TestKeyExchangeRoundTrip(t *testing.T) {
+	pair, _ := crypto.GenerateMasterKey()
+	key, _ := crypto.SplitPrivateKey(pair.Private)
+	id, _ := crypto.KeyIdentity(key)
+	raw, _ := hex.DecodeString(key)
+
+	for _, tc := range []struct{ pure, counter bool }{{true, false}, {false, false}, {true, true}, {false, true}} {
+		pure := tc.pure
+		cfg := &config.Config{
+			Key:                crypto.EncodePoint(pair.Public),
+			AEAD:               "chacha20-poly1305",
+			ASCII:              "prefer_entropy",
+			PaddingMin:         5,
+			PaddingMax:         15,
+			EnablePureDownlink: pure,
+			DisableHTTPMask:    true,
+			KeyExchange:        !tc.counter,
+			CounterNonce:       tc.counter,
+			RekeyBytes:         4096,
+			UserAuth:           true,
+			Users:              map[string]string{"dave": id},
+		}
+		auth, err := NewServerAuth(cfg)
+		if err != nil {
+			t.Fatalf("NewServerAuth failed: %v", err)
+		}
+		table := sudoku.NewTable(cfg.Key, cfg.ASCII)
+		clientSide, serverSide := net.Pipe()
+
+		go func() {
+			conn, _, err := HandshakeAndUpgradeWithAuth(serverSide, cfg, []*sudoku.Table{table}, auth)
+			if err != nil {
+				t.Errorf("server handshake failed: %v", err)
+				serverSide.Close()
+				return
+			}
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+
+		conn, err := ClientHandshake(clientSide, cfg, table, 0, raw)
+		if err != nil {
+			t.Fatalf("client handshake failed (pure=%v counter=%v): %v", pure, tc.counter, err)
+		}
+		msg := bytes.Repeat([]byte("forward secret payload "), 2000)
+		go conn.Write(msg)
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != string(msg) {
+			t.Fatalf("echo mismatch (pure=%v counter=%v): %q %v", pure, tc.counter, buf, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestCounterNonceRequiresKeyExchange(t *testing.T) {
+	header := make([]byte, handshakeHeaderSize)
+	block := []byte{extFlagCounterNonce}
+	if _, err := readHandshakeExt(bytes.NewReader(block), header, DownlinkModePure|handshakeFlagExtended); err == nil {
+		t.Fatalf("counter nonces without key exchange must be rejected")
+	}
+}
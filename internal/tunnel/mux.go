@@ -0,0 +1,504 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/saba-futai/sudoku/pkg/crypto"
+)
+
+const (
+	// MuxMagicByte marks a Sudoku tunnel connection that carries multiplexed streams.
+	MuxMagicByte byte = 0xED
+	muxVersion        = 0x01
+
+	// Frame: cmd(1) | stream id(4) | length(2) | payload
+	muxHeaderSize = 7
+	// Keep one mux frame inside one counter-nonce AEAD frame.
+	maxMuxPayload = crypto.MaxFramePayload - muxHeaderSize
+	// muxWindow is the per-stream receive window; a sender never has more
+	// unacknowledged bytes in flight on one stream.
+	muxWindow = 256 * 1024
+	// muxAcceptBacklog bounds streams opened by the peer but not yet accepted.
+	muxAcceptBacklog = 256
+)
+
+const (
+	muxCmdSYN byte = 0x01 // open stream
+	muxCmdPSH byte = 0x02 // data
+	muxCmdUPD byte = 0x03 // window update, payload: credit(4)
+	muxCmdFIN byte = 0x04 // sender finished writing
+	muxCmdRST byte = 0x05 // abort stream
+)
+
+var (
+	ErrMuxSessionClosed = errors.New("mux session closed")
+	errMuxStreamReset   = errors.New("mux stream reset by peer")
+)
+
+// WriteMuxPreface writes the multiplexing marker and version.
+func WriteMuxPreface(w io.Writer) error {
+	_, err := w.Write([]byte{MuxMagicByte, muxVersion})
+	return err
+}
+
+// MuxSession carries many logical streams over one handshaked tunnel connection.
+// Only the client side opens streams; the server accepts them.
+type MuxSession struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*muxStream
+	nextID  uint32
+
+	accept    chan *muxStream
+	closed    chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// NewMuxClient writes the mux preface on conn and returns a session that opens streams.
+func NewMuxClient(conn net.Conn) (*MuxSession, error) {
+	if err := WriteMuxPreface(conn); err != nil {
+		return nil, fmt.Errorf("mux preface failed: %w", err)
+	}
+	return newMuxSession(conn), nil
+}
+
+func newMuxSession(conn net.Conn) *MuxSession {
+	s := &MuxSession{
+		conn:    conn,
+		streams: make(map[uint32]*muxStream),
+		nextID:  1,
+		accept:  make(chan *muxStream, muxAcceptBacklog),
+		closed:  make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// HandleMuxServer serves a mux session whose magic byte has already been consumed,
+// running handle for every stream the client opens. It returns when the session ends.
+func HandleMuxServer(conn net.Conn, handle func(net.Conn)) error {
+	versionBuf := make([]byte, 1)
+	if _, err := io.ReadFull(conn, versionBuf); err != nil {
+		return fmt.Errorf("read mux version: %w", err)
+	}
+	if versionBuf[0] != muxVersion {
+		return fmt.Errorf("unsupported mux version: %d", versionBuf[0])
+	}
+
+	s := newMuxSession(conn)
+	defer s.Close()
+	for {
+		stream, err := s.Accept()
+		if err != nil {
+			return err
+		}
+		go handle(stream)
+	}
+}
+
+// OpenStream opens a new logical stream.
+func (s *MuxSession) OpenStream() (net.Conn, error) {
+	s.mu.Lock()
+	if s.IsClosed() {
+		s.mu.Unlock()
+		return nil, ErrMuxSessionClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newMuxStream(s, id)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	if err := s.writeFrame(muxCmdSYN, id, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept waits for the next stream opened by the peer.
+func (s *MuxSession) Accept() (net.Conn, error) {
+	select {
+	case stream := <-s.accept:
+		return stream, nil
+	case <-s.closed:
+		return nil, s.closeErr()
+	}
+}
+
+// NumStreams returns the number of open streams.
+func (s *MuxSession) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed reports whether the underlying tunnel is gone.
+func (s *MuxSession) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close tears down the session and every stream on it.
+func (s *MuxSession) Close() error {
+	s.closeWithError(ErrMuxSessionClosed)
+	return nil
+}
+
+func (s *MuxSession) closeWithError(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		streams := s.streams
+		s.streams = make(map[uint32]*muxStream)
+		s.mu.Unlock()
+
+		close(s.closed)
+		_ = s.conn.Close()
+		for _, stream := range streams {
+			stream.fail(err)
+		}
+	})
+}
+
+func (s *MuxSession) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		return ErrMuxSessionClosed
+	}
+	return s.err
+}
+
+func (s *MuxSession) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *MuxSession) writeFrame(cmd byte, id uint32, payload []byte) error {
+	frame := make([]byte, muxHeaderSize+len(payload))
+	frame[0] = cmd
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	binary.BigEndian.PutUint16(frame[5:7], uint16(len(payload)))
+	copy(frame[muxHeaderSize:], payload)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.IsClosed() {
+		return s.closeErr()
+	}
+	if _, err := s.conn.Write(frame); err != nil {
+		s.closeWithError(err)
+		return err
+	}
+	return nil
+}
+
+func (s *MuxSession) recvLoop() {
+	header := make([]byte, muxHeaderSize)
+	for {
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			s.closeWithError(err)
+			return
+		}
+		cmd := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		payload := make([]byte, binary.BigEndian.Uint16(header[5:7]))
+		if _, err := io.ReadFull(s.conn, payload); err != nil {
+			s.closeWithError(err)
+			return
+		}
+
+		s.mu.Lock()
+		stream := s.streams[id]
+		s.mu.Unlock()
+
+		switch cmd {
+		case muxCmdSYN:
+			if stream != nil || id%2 == 0 {
+				s.closeWithError(fmt.Errorf("mux: invalid stream open %d", id))
+				return
+			}
+			stream = newMuxStream(s, id)
+			s.mu.Lock()
+			s.streams[id] = stream
+			s.mu.Unlock()
+			select {
+			case s.accept <- stream:
+			default:
+				s.removeStream(id)
+				go s.writeFrame(muxCmdRST, id, nil)
+			}
+		case muxCmdPSH:
+			if stream == nil {
+				go s.writeFrame(muxCmdRST, id, nil)
+				continue
+			}
+			stream.deliver(payload)
+		case muxCmdUPD:
+			if stream != nil && len(payload) == 4 {
+				stream.credit(binary.BigEndian.Uint32(payload))
+			}
+		case muxCmdFIN:
+			if stream != nil {
+				stream.remoteFinished()
+			}
+		case muxCmdRST:
+			if stream != nil {
+				s.removeStream(id)
+				stream.fail(errMuxStreamReset)
+			}
+		default:
+			s.closeWithError(fmt.Errorf("mux: unknown command %#x", cmd))
+			return
+		}
+	}
+}
+
+// muxStream is one logical connection inside a MuxSession.
+type muxStream struct {
+	id      uint32
+	session *MuxSession
+	writeMu sync.Mutex // keeps concurrent Writes from interleaving
+
+	mu            sync.Mutex
+	buf           bytes.Buffer
+	consumed      uint32 // read since the last window update
+	sendWindow    uint32
+	readErr       error // io.EOF after FIN, or the failure
+	writeErr      error
+	localClosed   bool
+	remoteClosed  bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readReady  chan struct{}
+	writeReady chan struct{}
+}
+
+func newMuxStream(s *MuxSession, id uint32) *muxStream {
+	return &muxStream{
+		id:         id,
+		session:    s,
+		sendWindow: muxWindow,
+		readReady:  make(chan struct{}, 1),
+		writeReady: make(chan struct{}, 1),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func waitReady(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (st *muxStream) deliver(data []byte) {
+	st.mu.Lock()
+	if st.localClosed {
+		// Nobody will read it; tell the peer to stop sending.
+		st.mu.Unlock()
+		st.session.removeStream(st.id)
+		go st.session.writeFrame(muxCmdRST, st.id, nil)
+		return
+	}
+	if st.buf.Len()+len(data) > muxWindow {
+		st.mu.Unlock()
+		st.session.removeStream(st.id)
+		st.fail(fmt.Errorf("mux: stream %d exceeded its window", st.id))
+		go st.session.writeFrame(muxCmdRST, st.id, nil)
+		return
+	}
+	st.buf.Write(data)
+	st.mu.Unlock()
+	notify(st.readReady)
+}
+
+func (st *muxStream) credit(n uint32) {
+	st.mu.Lock()
+	st.sendWindow += n
+	st.mu.Unlock()
+	notify(st.writeReady)
+}
+
+func (st *muxStream) remoteFinished() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	if st.readErr == nil {
+		st.readErr = io.EOF
+	}
+	done := st.localClosed
+	st.mu.Unlock()
+	if done {
+		st.session.removeStream(st.id)
+	}
+	notify(st.readReady)
+}
+
+func (st *muxStream) fail(err error) {
+	st.mu.Lock()
+	if st.readErr == nil || st.readErr == io.EOF {
+		st.readErr = err
+	}
+	if st.writeErr == nil {
+		st.writeErr = err
+	}
+	st.mu.Unlock()
+	notify(st.readReady)
+	notify(st.writeReady)
+}
+
+func (st *muxStream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.buf.Len() > 0 {
+			n, _ := st.buf.Read(p)
+			st.consumed += uint32(n)
+			var update uint32
+			if st.consumed >= muxWindow/2 {
+				update, st.consumed = st.consumed, 0
+			}
+			st.mu.Unlock()
+			if update > 0 {
+				credit := make([]byte, 4)
+				binary.BigEndian.PutUint32(credit, update)
+				st.session.writeFrame(muxCmdUPD, st.id, credit)
+			}
+			return n, nil
+		}
+		if st.readErr != nil {
+			err := st.readErr
+			st.mu.Unlock()
+			return 0, err
+		}
+		if st.localClosed {
+			st.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+		if err := waitReady(st.readReady, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (st *muxStream) Write(p []byte) (int, error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		st.mu.Lock()
+		if st.writeErr != nil {
+			err := st.writeErr
+			st.mu.Unlock()
+			return written, err
+		}
+		if st.localClosed {
+			st.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := waitReady(st.writeReady, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := min(len(p), int(st.sendWindow), maxMuxPayload)
+		st.sendWindow -= uint32(n)
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(muxCmdPSH, st.id, p[:n]); err != nil {
+			return written, err
+		}
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close finishes the stream in both directions. Data still arriving from the
+// peer is answered with a reset so its writer does not wait for window credit.
+func (st *muxStream) Close() error {
+	st.mu.Lock()
+	if st.localClosed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	failed := st.writeErr != nil
+	done := st.remoteClosed || failed
+	st.mu.Unlock()
+
+	notify(st.readReady)
+	notify(st.writeReady)
+	if done {
+		st.session.removeStream(st.id)
+	}
+	if failed {
+		return nil
+	}
+	return st.session.writeFrame(muxCmdFIN, st.id, nil)
+}
+
+func (st *muxStream) LocalAddr() net.Addr  { return st.session.conn.LocalAddr() }
+func (st *muxStream) RemoteAddr() net.Addr { return st.session.conn.RemoteAddr() }
+
+func (st *muxStream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+func (st *muxStream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	notify(st.readReady)
+	return nil
+}
+
+func (st *muxStream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	notify(st.writeReady)
+	return nil
+}
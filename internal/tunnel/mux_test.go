@@ -0,0 +1,134 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMuxPair(t *testing.T, handle func(net.Conn)) *MuxSession {
+	t.Helper()
+	clientSide, serverSide := net.Pipe()
+	go func() {
+		magic := make([]byte, 1)
+		if _, err := io.ReadFull(serverSide, magic); err != nil || magic[0] != MuxMagicByte {
+			serverSide.Close()
+			return
+		}
+		HandleMuxServer(serverSide, handle)
+	}()
+	client, err := NewMuxClient(clientSide)
+	if err != nil {
+		t.Fatalf("NewMuxClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func echoHandler(c net.Conn) {
+	defer c.Close()
+	io.Copy(c, c)
+}
+
+func TestMuxConcurrentStreams(t *testing.T) {
+	client := newMuxPair(t, echoHandler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			stream, err := client.OpenStream()
+			if err != nil {
+				t.Errorf("open %d failed: %v", id, err)
+				return
+			}
+			defer stream.Close()
+			msg := bytes.Repeat([]byte(fmt.Sprintf("stream-%d;", id)), 3000)
+			go stream.Write(msg)
+			got := make([]byte, len(msg))
+			if _, err := io.ReadFull(stream, got); err != nil || !bytes.Equal(got, msg) {
+				t.Errorf("echo %d mismatch: %v", id, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestMuxFlowControlIsPerStream(t *testing.T) {
+	sink := make(chan net.Conn, 2)
+	client := newMuxPair(t, func(c net.Conn) { sink <- c })
+
+	slow, _ := client.OpenStream()
+	slowServer := <-sink
+	fast, _ := client.OpenStream()
+	fastServer := <-sink
+
+	// Fill the slow stream's window; its writer must block without stalling the other stream.
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := slow.Write(make([]byte, muxWindow+1024))
+		blocked <- err
+	}()
+	select {
+	case <-blocked:
+		t.Fatalf("write beyond the window should block until the peer reads")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	go fast.Write([]byte("still flowing"))
+	buf := make([]byte, len("still flowing"))
+	if _, err := io.ReadFull(fastServer, buf); err != nil || string(buf) != "still flowing" {
+		t.Fatalf("other stream stalled: %q %v", buf, err)
+	}
+
+	if _, err := io.ReadFull(slowServer, make([]byte, muxWindow+1024)); err != nil {
+		t.Fatalf("draining slow stream failed: %v", err)
+	}
+	if err := <-blocked; err != nil {
+		t.Fatalf("blocked write failed: %v", err)
+	}
+}
+
+func TestMuxStreamCloseAndDeadline(t *testing.T) {
+	client := newMuxPair(t, func(c net.Conn) {
+		defer c.Close()
+		cmd := make([]byte, 1)
+		if _, err := io.ReadFull(c, cmd); err != nil {
+			return
+		}
+		if cmd[0] == 'q' {
+			c.Write([]byte("bye"))
+			return
+		}
+		io.Copy(io.Discard, c)
+	})
+
+	stream, _ := client.OpenStream()
+	stream.Write([]byte("q"))
+	got, err := io.ReadAll(stream)
+	if err != nil || string(got) != "bye" {
+		t.Fatalf("expected data then EOF, got %q %v", got, err)
+	}
+	stream.Close()
+
+	idle, _ := client.OpenStream()
+	idle.Write([]byte("w"))
+	idle.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := idle.Read(make([]byte, 1)); err != os.ErrDeadlineExceeded {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+
+	client.Close()
+	if _, err := idle.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("streams must fail once the session is closed")
+	}
+	if _, err := client.OpenStream(); err == nil {
+		t.Fatalf("open on a closed session must fail")
+	}
+}
@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPoolMaxIdle is how long a pre-handshaked connection may wait in the pool.
+const DefaultPoolMaxIdle = 30 * time.Second
+
+type pooledConn struct {
+	net.Conn
+	expiresAt time.Time
+}
+
+// ConnPool keeps a few handshaked tunnel connections ready so that dialing a
+// target does not pay DNS, TCP connect and the handshake on the request path.
+// Idle connections are retired after a jittered max-idle age, so the pool does
+// not hold long idle sockets or recycle them on a fixed period.
+type ConnPool struct {
+	dial    func() (net.Conn, error)
+	size    int
+	maxIdle time.Duration
+
+	mu     sync.Mutex
+	idle   []pooledConn
+	refill chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewConnPool starts a pool holding up to size connections created by dial.
+func NewConnPool(dial func() (net.Conn, error), size int, maxIdle time.Duration) *ConnPool {
+	if maxIdle <= 0 {
+		maxIdle = DefaultPoolMaxIdle
+	}
+	p := &ConnPool{
+		dial:    dial,
+		size:    size,
+		maxIdle: maxIdle,
+		refill:  make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Get takes a ready connection from the pool, or reports false if none is available.
+func (p *ConnPool) Get() (net.Conn, bool) {
+	defer p.kick()
+
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.idle) > 0 {
+		// Newest first: it has the most idle budget left.
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if now.Before(pc.expiresAt) {
+			return pc.Conn, true
+		}
+		pc.Conn.Close()
+	}
+	return nil, false
+}
+
+// Len returns the number of ready connections.
+func (p *ConnPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// Close stops refilling and closes every idle connection.
+func (p *ConnPool) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+		p.mu.Lock()
+		for _, pc := range p.idle {
+			pc.Conn.Close()
+		}
+		p.idle = nil
+		p.mu.Unlock()
+	})
+	return nil
+}
+
+func (p *ConnPool) kick() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+func (p *ConnPool) run() {
+	ticker := time.NewTicker(p.maxIdle / 4)
+	defer ticker.Stop()
+	backoff := time.Duration(0)
+
+	for {
+		p.expire()
+		if err := p.fill(); err != nil {
+			backoff = min(max(2*backoff, time.Second), 30*time.Second)
+			log.Printf("[Pool] Refill failed: %v (retry in %v)", err, backoff)
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-p.closed:
+				return
+			}
+		}
+		backoff = 0
+
+		select {
+		case <-p.refill:
+		case <-ticker.C:
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+func (p *ConnPool) expire() {
+	now := time.Now()
+	p.mu.Lock()
+	kept := p.idle[:0]
+	for _, pc := range p.idle {
+		if now.Before(pc.expiresAt) {
+			kept = append(kept, pc)
+		} else {
+			pc.Conn.Close()
+		}
+	}
+	p.idle = kept
+	p.mu.Unlock()
+}
+
+func (p *ConnPool) fill() error {
+	for p.Len() < p.size {
+		conn, err := p.dial()
+		if err != nil {
+			return err
+		}
+		// 70%..100% of maxIdle
+		ttl := p.maxIdle - time.Duration(rand.Int64N(int64(p.maxIdle)*3/10+1))
+		p.mu.Lock()
+		select {
+		case <-p.closed:
+			p.mu.Unlock()
+			conn.Close()
+			return nil
+		default:
+		}
+		p.idle = append(p.idle, pooledConn{Conn: conn, expiresAt: time.Now().Add(ttl)})
+		p.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConnPoolServesAndRefills(t *testing.T) {
+	var dials atomic.Int32
+	pool := NewConnPool(func() (net.Conn, error) {
+		dials.Add(1)
+		c, _ := net.Pipe()
+		return c, nil
+	}, 2, time.Minute)
+	defer pool.Close()
+
+	waitFor(t, "initial fill", func() bool { return pool.Len() == 2 })
+
+	conn, ok := pool.Get()
+	if !ok || conn == nil {
+		t.Fatalf("expected a pooled connection")
+	}
+	conn.Close()
+	waitFor(t, "refill", func() bool { return pool.Len() == 2 })
+	if got := dials.Load(); got != 3 {
+		t.Fatalf("expected 3 dials, got %d", got)
+	}
+}
+
+func TestConnPoolRetiresIdleConnections(t *testing.T) {
+	var dials atomic.Int32
+	pool := NewConnPool(func() (net.Conn, error) {
+		dials.Add(1)
+		c, _ := net.Pipe()
+		return c, nil
+	}, 1, 40*time.Millisecond)
+	defer pool.Close()
+
+	waitFor(t, "initial fill", func() bool { return pool.Len() == 1 })
+	// Expired connections are replaced in the background without any Get.
+	waitFor(t, "replacement", func() bool { return dials.Load() >= 3 })
+}
+
+func TestConnPoolDialFailureFallsThrough(t *testing.T) {
+	pool := NewConnPool(func() (net.Conn, error) {
+		return nil, errors.New("server down")
+	}, 2, time.Minute)
+	defer pool.Close()
+
+	if _, ok := pool.Get(); ok {
+		t.Fatalf("empty pool must report no connection")
+	}
+}
+
+func TestGroupCloseStopsMemberPools(t *testing.T) {
+	var pools []*ConnPool
+	var ups []*Upstream
+	for _, name := range []string{"a", "b"} {
+		d := &StandardDialer{}
+		d.Pool = NewConnPool(func() (net.Conn, error) {
+			c, _ := net.Pipe()
+			return c, nil
+		}, 1, time.Minute)
+		pools = append(pools, d.Pool)
+		ups = append(ups, NewUpstream(name, d))
+	}
+	g := newUpstreamGroup(ups)
+	for _, p := range pools {
+		waitFor(t, "initial fill", func() bool { return p.Len() == 1 })
+	}
+
+	g.Close()
+	for _, p := range pools {
+		if p.Len() != 0 {
+			t.Fatalf("pool still holds %d connections after Close", p.Len())
+		}
+		if _, ok := p.Get(); ok {
+			t.Fatalf("closed pool handed out a connection")
+		}
+	}
+}
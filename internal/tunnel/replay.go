@@ -0,0 +1,171 @@
+package tunnel
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+)
+
+const (
+	// DefaultMaxTimeSkew is the accepted clock difference when the config leaves it unset.
+	DefaultMaxTimeSkew = 60 * time.Second
+	// DefaultReplayCacheSize bounds the number of remembered handshakes.
+	DefaultReplayCacheSize = 1 << 16
+
+	// replayWarnInterval rate-limits the warning about live fingerprints dropped at capacity.
+	replayWarnInterval = 60 // seconds
+
+	// handshakeFingerprintSize is how many decoded bytes identify a handshake.
+	// With AEAD this covers the frame length, the random per-frame nonce and the start of
+	// the ciphertext, so two honest handshakes never collide even when their plaintext
+	// is identical, while a verbatim replay always does.
+	handshakeFingerprintSize = 32
+)
+
+// handshakeReplayCache is shared by every server in the process; fingerprints carry a
+// random AEAD nonce, so sharing cannot cause false positives between listeners.
+// Each handshake reserves the size its config asks for, so the largest one wins.
+var handshakeReplayCache = NewReplayCache(DefaultReplayCacheSize)
+
+type replayEntry struct {
+	key       string
+	expiresAt int64
+}
+
+// ReplayCache is a bounded, time-windowed set of handshake fingerprints.
+type ReplayCache struct {
+	mu       sync.Mutex
+	seen     map[string]int64
+	order    []replayEntry // insertion order, used for expiry and eviction
+	head     int
+	capacity int
+
+	dropped  int   // unexpired fingerprints evicted at capacity since the last warning
+	lastWarn int64 // unix seconds
+}
+
+// NewReplayCache creates a cache remembering at most capacity handshakes.
+func NewReplayCache(capacity int) *ReplayCache {
+	if capacity <= 0 {
+		capacity = DefaultReplayCacheSize
+	}
+	return &ReplayCache{
+		seen:     make(map[string]int64),
+		capacity: capacity,
+	}
+}
+
+// Reserve raises the capacity to at least capacity (<= 0 uses the default). It never shrinks
+// the cache, so listeners sharing it keep the largest configured size.
+func (c *ReplayCache) Reserve(capacity int) {
+	if capacity <= 0 {
+		capacity = DefaultReplayCacheSize
+	}
+	c.mu.Lock()
+	if capacity > c.capacity {
+		c.capacity = capacity
+	}
+	c.mu.Unlock()
+}
+
+// Check records fingerprint until expiresAt and reports whether it is fresh.
+// It returns false if the same fingerprint was recorded earlier and has not expired.
+func (c *ReplayCache) Check(fingerprint []byte, expiresAt time.Time) bool {
+	now := time.Now().Unix()
+	key := string(fingerprint)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictLocked(now)
+	if exp, ok := c.seen[key]; ok && exp >= now {
+		return false
+	}
+	c.seen[key] = expiresAt.Unix()
+	c.order = append(c.order, replayEntry{key: key, expiresAt: expiresAt.Unix()})
+	return true
+}
+
+// Len returns the number of remembered fingerprints.
+func (c *ReplayCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.seen)
+}
+
+// evictLocked drops expired fingerprints and, at capacity, the oldest live ones.
+// A live fingerprint that is dropped could be replayed undetected, so that is logged.
+func (c *ReplayCache) evictLocked(now int64) {
+	for c.head < len(c.order) {
+		e := c.order[c.head]
+		if e.expiresAt >= now && len(c.seen) < c.capacity {
+			break
+		}
+		if exp, ok := c.seen[e.key]; ok && exp == e.expiresAt {
+			delete(c.seen, e.key)
+			if exp >= now {
+				c.dropped++
+			}
+		}
+		c.order[c.head] = replayEntry{}
+		c.head++
+	}
+	if c.dropped > 0 && now-c.lastWarn >= replayWarnInterval {
+		log.Printf("[Security] Replay cache full (%d entries): evicted %d unexpired handshakes, "+
+			"replays of them are no longer detected; raise the replay cache size", c.capacity, c.dropped)
+		c.dropped = 0
+		c.lastWarn = now
+	}
+	// Compact the queue once the consumed prefix dominates.
+	if c.head > 1024 && c.head*2 >= len(c.order) {
+		c.order = append(c.order[:0:0], c.order[c.head:]...)
+		c.head = 0
+	}
+}
+
+// maxTimeSkew returns the configured handshake timestamp tolerance.
+func maxTimeSkew(cfg *config.Config) time.Duration {
+	if cfg.MaxTimeSkew > 0 {
+		return time.Duration(cfg.MaxTimeSkew) * time.Second
+	}
+	return DefaultMaxTimeSkew
+}
+
+// replayCacheSize returns the configured replay cache capacity.
+func replayCacheSize(cfg *config.Config) int {
+	if cfg.ReplayCacheSize > 0 {
+		return cfg.ReplayCacheSize
+	}
+	return DefaultReplayCacheSize
+}
+
+// HandshakeTap records the first decoded bytes of a handshake for replay detection.
+// It is read by the same goroutine that performs the handshake, so it needs no locking.
+type HandshakeTap struct {
+	net.Conn
+	buf []byte
+}
+
+// NewHandshakeTap wraps the decoded (post-obfuscation, pre-AEAD) stream.
+func NewHandshakeTap(conn net.Conn) *HandshakeTap {
+	return &HandshakeTap{Conn: conn, buf: make([]byte, 0, handshakeFingerprintSize)}
+}
+
+func (t *HandshakeTap) Read(p []byte) (int, error) {
+	n, err := t.Conn.Read(p)
+	if room := handshakeFingerprintSize - len(t.buf); n > 0 && room > 0 {
+		if room > n {
+			room = n
+		}
+		t.buf = append(t.buf, p[:room]...)
+	}
+	return n, err
+}
+
+// Fingerprint returns the bytes recorded so far.
+func (t *HandshakeTap) Fingerprint() []byte {
+	return append([]byte(nil), t.buf...)
+}
@@ -0,0 +1,121 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/pkg/obfs/sudoku"
+)
+
+func TestReplayCacheRejectsDuplicates(t *testing.T) {
+	c := NewReplayCache(8)
+	exp := time.Now().Add(time.Minute)
+
+	if !c.Check([]byte("nonce-a"), exp) {
+		t.Fatalf("first sighting must be fresh")
+	}
+	if c.Check([]byte("nonce-a"), exp) {
+		t.Fatalf("duplicate must be rejected")
+	}
+	if !c.Check([]byte("nonce-b"), exp) {
+		t.Fatalf("different nonce must be fresh")
+	}
+
+	// Expired entries no longer block and are dropped.
+	if !c.Check([]byte("old"), time.Now().Add(-2*time.Second)) {
+		t.Fatalf("first sighting must be fresh")
+	}
+	if !c.Check([]byte("old"), exp) {
+		t.Fatalf("expired fingerprint should be accepted again")
+	}
+}
+
+func TestReplayCacheIsBounded(t *testing.T) {
+	c := NewReplayCache(4)
+	exp := time.Now().Add(time.Minute)
+	for i := 0; i < 100; i++ {
+		c.Check([]byte(fmt.Sprintf("n-%d", i)), exp)
+	}
+	if c.Len() > 4 {
+		t.Fatalf("cache grew beyond capacity: %d", c.Len())
+	}
+}
+
+func TestReplayCacheLogsLiveEvictions(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := NewReplayCache(2)
+	c.Reserve(1) // never shrinks
+	past := time.Now().Add(-2 * time.Second)
+	exp := time.Now().Add(time.Minute)
+	c.Check([]byte("expired-a"), past)
+	c.Check([]byte("expired-b"), past)
+	c.Check([]byte("live-a"), exp)
+	if buf.Len() != 0 {
+		t.Fatalf("dropping expired fingerprints should not warn: %s", buf.String())
+	}
+	c.Check([]byte("live-b"), exp)
+	c.Check([]byte("live-c"), exp)
+	if !strings.Contains(buf.String(), "evicted 1 unexpired") {
+		t.Fatalf("capacity eviction of a live fingerprint not logged: %q", buf.String())
+	}
+
+	c.Reserve(8)
+	for i := 0; i < 8; i++ {
+		c.Check([]byte(fmt.Sprintf("n-%d", i)), exp)
+	}
+	if c.Len() != 8 {
+		t.Fatalf("reserved capacity not used: len=%d", c.Len())
+	}
+}
+
+func TestHandshakeReplayGoesToFallback(t *testing.T) {
+	cfg := &config.Config{
+		Key:                "replay-key",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_ascii",
+		PaddingMin:         5,
+		PaddingMax:         10,
+		EnablePureDownlink: true,
+		DisableHTTPMask:    true,
+	}
+	table := sudoku.NewTable(cfg.Key, cfg.ASCII)
+
+	// Capture the bytes of one client handshake.
+	capture := newMockConn(nil)
+	if _, err := ClientHandshake(capture, cfg, table, 0, nil); err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	recorded := capture.writeBuf.Bytes()
+
+	first := newMockConn(append([]byte(nil), recorded...))
+	if _, err := HandshakeAndUpgrade(first, cfg, table); err != nil {
+		t.Fatalf("original handshake rejected: %v", err)
+	}
+
+	replayed := newMockConn(append([]byte(nil), recorded...))
+	_, err := HandshakeAndUpgrade(replayed, cfg, table)
+	var suspErr *SuspiciousError
+	if !errors.As(err, &suspErr) {
+		t.Fatalf("replayed handshake should be suspicious, got %v", err)
+	}
+}
+
+func TestHandshakeRespectsConfiguredSkew(t *testing.T) {
+	cfg := &config.Config{MaxTimeSkew: 5}
+	if maxTimeSkew(cfg) != 5*time.Second {
+		t.Fatalf("configured skew ignored")
+	}
+	if maxTimeSkew(&config.Config{}) != DefaultMaxTimeSkew {
+		t.Fatalf("default skew not applied")
+	}
+}
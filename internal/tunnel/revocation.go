@@ -0,0 +1,151 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saba-futai/sudoku/pkg/crypto"
+)
+
+// RevocationList tracks revoked key identities from the config and an optional file.
+// The file holds one identity per line ("#" starts a comment) and is re-read when
+// its modification time changes, so a leaked split key can be revoked without a restart.
+type RevocationList struct {
+	path   string
+	static map[string]struct{}
+
+	mu       sync.RWMutex
+	fromFile map[string]struct{}
+	modTime  time.Time
+	size     int64
+}
+
+// NewRevocationList builds a revocation list from inline identities and an optional file.
+func NewRevocationList(identities []string, path string) (*RevocationList, error) {
+	rl := &RevocationList{
+		path:   path,
+		static: make(map[string]struct{}, len(identities)),
+	}
+	for _, id := range identities {
+		raw, err := crypto.ParseIdentity(strings.TrimSpace(id))
+		if err != nil {
+			return nil, fmt.Errorf("revoked key %q: %w", id, err)
+		}
+		rl.static[hex.EncodeToString(raw)] = struct{}{}
+	}
+	if path != "" {
+		if _, err := rl.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return rl, nil
+}
+
+// Enabled reports whether any revocation source is configured.
+func (rl *RevocationList) Enabled() bool {
+	return rl != nil && (len(rl.static) > 0 || rl.path != "")
+}
+
+// IsRevoked reports whether the hex encoded identity has been revoked.
+func (rl *RevocationList) IsRevoked(identity string) bool {
+	if rl == nil {
+		return false
+	}
+	if _, ok := rl.static[identity]; ok {
+		return true
+	}
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	_, ok := rl.fromFile[identity]
+	return ok
+}
+
+// Reload re-reads the revocation file if it changed since the last load.
+// It reports whether a new list was installed. On parse errors the previous list is kept.
+func (rl *RevocationList) Reload() (bool, error) {
+	if rl == nil || rl.path == "" {
+		return false, nil
+	}
+	info, err := os.Stat(rl.path)
+	if err != nil {
+		return false, fmt.Errorf("stat revocation file: %w", err)
+	}
+
+	rl.mu.RLock()
+	unchanged := rl.fromFile != nil && info.ModTime().Equal(rl.modTime) && info.Size() == rl.size
+	rl.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(rl.path)
+	if err != nil {
+		return false, fmt.Errorf("read revocation file: %w", err)
+	}
+	revoked, err := parseRevocationFile(data)
+	if err != nil {
+		return false, fmt.Errorf("parse revocation file %s: %w", rl.path, err)
+	}
+
+	rl.mu.Lock()
+	rl.fromFile = revoked
+	rl.modTime = info.ModTime()
+	rl.size = info.Size()
+	rl.mu.Unlock()
+	return true, nil
+}
+
+// Watch polls the revocation file and reloads it on change. It never returns.
+func (rl *RevocationList) Watch(interval time.Duration) {
+	if rl == nil || rl.path == "" {
+		return
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed, err := rl.Reload()
+		if err != nil {
+			log.Printf("[Auth] Revocation reload failed: %v", err)
+			continue
+		}
+		if changed {
+			rl.mu.RLock()
+			n := len(rl.fromFile)
+			rl.mu.RUnlock()
+			log.Printf("[Auth] Revocation list reloaded: %d revoked keys", n)
+		}
+	}
+}
+
+func parseRevocationFile(data []byte) (map[string]struct{}, error) {
+	revoked := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		raw, err := crypto.ParseIdentity(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		revoked[hex.EncodeToString(raw)] = struct{}{}
+	}
+	return revoked, scanner.Err()
+}
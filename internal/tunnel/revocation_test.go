@@ -0,0 +1,149 @@
+package tunnel
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/edwards25519"
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/pkg/crypto"
+)
+
+func TestRevocationListReloadsFile(t *testing.T) {
+	pair, _ := crypto.GenerateMasterKey()
+	keyA, _ := crypto.SplitPrivateKey(pair.Private)
+	keyB, _ := crypto.SplitPrivateKey(pair.Private)
+	idA, _ := crypto.KeyIdentity(keyA)
+	idB, _ := crypto.KeyIdentity(keyB)
+
+	path := filepath.Join(t.TempDir(), "revoked.txt")
+	if err := os.WriteFile(path, []byte("# leaked laptop\n"+idA+"\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	rl, err := NewRevocationList(nil, path)
+	if err != nil {
+		t.Fatalf("NewRevocationList failed: %v", err)
+	}
+	if !rl.IsRevoked(idA) || rl.IsRevoked(idB) {
+		t.Fatalf("initial revocation state wrong")
+	}
+
+	if err := os.WriteFile(path, []byte(idA+"\n"+idB+" # second leak\n"), 0o644); err != nil {
+		t.Fatalf("rewrite file: %v", err)
+	}
+	future := time.Now().Add(time.Second)
+	os.Chtimes(path, future, future)
+
+	changed, err := rl.Reload()
+	if err != nil || !changed {
+		t.Fatalf("reload should pick up change, changed=%v err=%v", changed, err)
+	}
+	if !rl.IsRevoked(idB) {
+		t.Fatalf("reloaded identity not revoked")
+	}
+
+	// A broken file keeps the previous list.
+	os.WriteFile(path, []byte("garbage\n"), 0o644)
+	future = future.Add(time.Second)
+	os.Chtimes(path, future, future)
+	if _, err := rl.Reload(); err == nil {
+		t.Fatalf("expected parse error")
+	}
+	if !rl.IsRevoked(idA) || !rl.IsRevoked(idB) {
+		t.Fatalf("previous list should survive a failed reload")
+	}
+}
+
+func TestServerAuthRejectsRevokedKey(t *testing.T) {
+	pair, _ := crypto.GenerateMasterKey()
+	key, _ := crypto.SplitPrivateKey(pair.Private)
+	id, _ := crypto.KeyIdentity(key)
+
+	cfg := &config.Config{
+		Key:                crypto.EncodePoint(pair.Public),
+		AEAD:               "aes-128-gcm",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		DisableHTTPMask:    true,
+		UserAuth:           true,
+		Users:              map[string]string{"carol": id},
+		RevokedKeys:        []string{id},
+	}
+	auth, err := NewServerAuth(cfg)
+	if err != nil {
+		t.Fatalf("NewServerAuth failed: %v", err)
+	}
+
+	raw, _ := hex.DecodeString(key)
+	var suspErr *SuspiciousError
+	if out := runAuthHandshake(t, cfg, auth, raw); !errors.As(out.err, &suspErr) {
+		t.Fatalf("revoked key should be suspicious, got %v", out.err)
+	}
+}
+
+// resplit recovers the master scalar from a split key and splits it again,
+// as the holder of a leaked key could.
+func resplit(t *testing.T, splitKey string) string {
+	t.Helper()
+	raw, _ := hex.DecodeString(splitKey)
+	r, err := edwards25519.NewScalar().SetCanonicalBytes(raw[:32])
+	if err != nil {
+		t.Fatalf("decode r: %v", err)
+	}
+	k, err := edwards25519.NewScalar().SetCanonicalBytes(raw[32:])
+	if err != nil {
+		t.Fatalf("decode k: %v", err)
+	}
+	fresh, err := crypto.SplitPrivateKey(new(edwards25519.Scalar).Add(r, k))
+	if err != nil {
+		t.Fatalf("resplit: %v", err)
+	}
+	return fresh
+}
+
+func TestRevocationRequiresUsers(t *testing.T) {
+	pair, _ := crypto.GenerateMasterKey()
+	leaked, _ := crypto.SplitPrivateKey(pair.Private)
+	leakedID, _ := crypto.KeyIdentity(leaked)
+	honest, _ := crypto.SplitPrivateKey(pair.Private)
+	honestID, _ := crypto.KeyIdentity(honest)
+
+	cfg := &config.Config{
+		Key:                crypto.EncodePoint(pair.Public),
+		AEAD:               "aes-128-gcm",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		DisableHTTPMask:    true,
+		UserAuth:           true,
+		RevokedKeys:        []string{leakedID},
+	}
+	// 仅配置吊销无法阻止重新拆分出的新身份，必须拒绝启动
+	if _, err := NewServerAuth(cfg); err == nil {
+		t.Fatalf("revocation without users should be rejected")
+	}
+
+	cfg.Users = map[string]string{"dave": leakedID, "erin": honestID}
+	auth, err := NewServerAuth(cfg)
+	if err != nil {
+		t.Fatalf("NewServerAuth failed: %v", err)
+	}
+	fresh := resplit(t, leaked)
+	freshID, _ := crypto.KeyIdentity(fresh)
+	if freshID == leakedID {
+		t.Fatalf("resplit should yield a new identity")
+	}
+	raw, _ := hex.DecodeString(fresh)
+	var suspErr *SuspiciousError
+	if out := runAuthHandshake(t, cfg, auth, raw); !errors.As(out.err, &suspErr) {
+		t.Fatalf("re-split revoked key should be suspicious, got %v", out.err)
+	}
+	honestRaw, _ := hex.DecodeString(honest)
+	if out := runAuthHandshake(t, cfg, auth, honestRaw); out.err != nil || out.user != "erin" {
+		t.Fatalf("listed key should still be authorized, got user=%q err=%v", out.user, out.err)
+	}
+}
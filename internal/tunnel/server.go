@@ -162,7 +162,7 @@ func probeHandshakeBytes(probe []byte, cfg *config.Config, table *sudoku.Table)
 		return err
 	}
 	ts := int64(binary.BigEndian.Uint64(handshakeBuf[:8]))
-	if abs(time.Now().Unix()-ts) > 60 {
+	if abs(time.Now().Unix()-ts) > int64(maxTimeSkew(cfg)/time.Second) {
 		return fmt.Errorf("time skew/replay")
 	}
 
@@ -170,8 +170,8 @@ func probeHandshakeBytes(probe []byte, cfg *config.Config, table *sudoku.Table)
 	if _, err := io.ReadFull(cConn, modeBuf); err != nil {
 		return err
 	}
-	if modeBuf[0] != downlinkModeByte(cfg) {
-		return fmt.Errorf("downlink mode mismatch: client=%d server=%d", modeBuf[0], downlinkModeByte(cfg))
+	if modeBuf[0]&downlinkModeMask != downlinkModeByte(cfg) {
+		return fmt.Errorf("downlink mode mismatch: client=%d server=%d", modeBuf[0]&downlinkModeMask, downlinkModeByte(cfg))
 	}
 	return nil
 }
@@ -250,6 +250,14 @@ func selectTableByProbe(r *bufio.Reader, cfg *config.Config, tables []*sudoku.Ta
 // HandshakeAndUpgradeWithTables performs the handshake by probing one of multiple tables.
 // This enables per-connection table rotation without adding a plaintext table selector.
 func HandshakeAndUpgradeWithTables(rawConn net.Conn, cfg *config.Config, tables []*sudoku.Table) (net.Conn, error) {
+	conn, _, err := HandshakeAndUpgradeWithAuth(rawConn, cfg, tables, nil)
+	return conn, err
+}
+
+// HandshakeAndUpgradeWithAuth performs the table-probing handshake and authorizes the
+// client against auth. It returns the resolved user id ("" when auth is not required).
+// Unknown or unsigned identities are reported as *SuspiciousError for fallback handling.
+func HandshakeAndUpgradeWithAuth(rawConn net.Conn, cfg *config.Config, tables []*sudoku.Table, auth *ServerAuth) (net.Conn, string, error) {
 	// 0. HTTP Header Check
 	bufReader := bufio.NewReader(rawConn)
 	rawConn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
@@ -279,14 +287,14 @@ func HandshakeAndUpgradeWithTables(rawConn net.Conn, cfg *config.Config, tables
 				r:        bufReader,
 				recorder: recorder,
 			}
-			return nil, &SuspiciousError{Err: fmt.Errorf("invalid http header: %w", err), Conn: badConn}
+			return nil, "", &SuspiciousError{Err: fmt.Errorf("invalid http header: %w", err), Conn: badConn}
 		}
 	}
 
 	// 1. Sudoku Layer
 	if !cfg.EnablePureDownlink && cfg.AEAD == "none" {
 		rawConn.SetReadDeadline(time.Time{})
-		return nil, fmt.Errorf("enable_pure_downlink=false requires AEAD")
+		return nil, "", fmt.Errorf("enable_pure_downlink=false requires AEAD")
 	}
 
 	selectedTable, preRead, err := selectTableByProbe(bufReader, cfg, tables)
@@ -295,46 +303,73 @@ func HandshakeAndUpgradeWithTables(rawConn net.Conn, cfg *config.Config, tables
 		combined := make([]byte, 0, len(httpHeaderData)+len(preRead))
 		combined = append(combined, httpHeaderData...)
 		combined = append(combined, preRead...)
-		return nil, &SuspiciousError{Err: err, Conn: &recordedConn{Conn: rawConn, recorded: combined}}
+		return nil, "", &SuspiciousError{Err: err, Conn: &recordedConn{Conn: rawConn, recorded: combined}}
 	}
 
 	baseConn := NewPreBufferedConn(rawConn, preRead)
 	sConn, obfsConn := buildObfsConnForServer(baseConn, selectedTable, cfg, true)
 
 	// 2. Crypto Layer
-	cConn, err := crypto.NewAEADConn(obfsConn, cfg.Key, cfg.AEAD)
+	tap := NewHandshakeTap(obfsConn)
+	cConn, err := crypto.NewAEADConn(tap, cfg.Key, cfg.AEAD)
 	if err != nil {
-		return nil, fmt.Errorf("crypto setup failed: %w", err)
+		return nil, "", fmt.Errorf("crypto setup failed: %w", err)
+	}
+
+	suspicious := func(err error) error {
+		rawConn.SetReadDeadline(time.Time{})
+		return &SuspiciousError{Err: err, Conn: &prefixedRecorderConn{Conn: sConn, prefix: httpHeaderData}}
 	}
 
 	// 3. Handshake
-	handshakeBuf := make([]byte, 16)
+	handshakeBuf := make([]byte, handshakeHeaderSize)
 	rawConn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
 	_, err = io.ReadFull(cConn, handshakeBuf)
 	if err != nil {
-		rawConn.SetReadDeadline(time.Time{})
-		return nil, &SuspiciousError{Err: fmt.Errorf("handshake read failed: %w", err), Conn: &prefixedRecorderConn{Conn: sConn, prefix: httpHeaderData}}
+		return nil, "", suspicious(fmt.Errorf("handshake read failed: %w", err))
 	}
 
+	skew := maxTimeSkew(cfg)
 	ts := int64(binary.BigEndian.Uint64(handshakeBuf[:8]))
-	if abs(time.Now().Unix()-ts) > 60 {
-		rawConn.SetReadDeadline(time.Time{})
-		return nil, &SuspiciousError{Err: fmt.Errorf("time skew/replay"), Conn: &prefixedRecorderConn{Conn: sConn, prefix: httpHeaderData}}
+	if abs(time.Now().Unix()-ts) > int64(skew/time.Second) {
+		return nil, "", suspicious(fmt.Errorf("time skew/replay"))
+	}
+	// A handshake stays acceptable until its timestamp leaves the skew window,
+	// so it only has to be remembered that long.
+	handshakeReplayCache.Reserve(replayCacheSize(cfg))
+	if !handshakeReplayCache.Check(tap.Fingerprint(), time.Unix(ts, 0).Add(skew+time.Second)) {
+		return nil, "", suspicious(fmt.Errorf("replayed handshake"))
 	}
 
 	// 4. Downlink mode negotiation
 	modeBuf := make([]byte, 1)
 	if _, err := io.ReadFull(cConn, modeBuf); err != nil {
-		rawConn.SetReadDeadline(time.Time{})
-		return nil, &SuspiciousError{Err: fmt.Errorf("read downlink mode failed: %w", err), Conn: &prefixedRecorderConn{Conn: sConn, prefix: httpHeaderData}}
+		return nil, "", suspicious(fmt.Errorf("read downlink mode failed: %w", err))
+	}
+	if modeBuf[0]&downlinkModeMask != downlinkModeByte(cfg) {
+		return nil, "", suspicious(fmt.Errorf("downlink mode mismatch: client=%d server=%d", modeBuf[0]&downlinkModeMask, downlinkModeByte(cfg)))
+	}
+
+	// 5. Handshake extensions & user authorization
+	var ext *handshakeExt
+	if modeBuf[0]&handshakeFlagExtended != 0 {
+		if ext, err = readHandshakeExt(cConn, handshakeBuf, modeBuf[0]); err != nil {
+			return nil, "", suspicious(err)
+		}
+	}
+	userID, err := auth.authorize(ext)
+	if err != nil {
+		return nil, "", suspicious(err)
 	}
 	rawConn.SetReadDeadline(time.Time{})
-	if modeBuf[0] != downlinkModeByte(cfg) {
-		return nil, &SuspiciousError{Err: fmt.Errorf("downlink mode mismatch: client=%d server=%d", modeBuf[0], downlinkModeByte(cfg)), Conn: &prefixedRecorderConn{Conn: sConn, prefix: httpHeaderData}}
+
+	// 6. Ephemeral key exchange (forward secrecy)
+	if err := acceptKeyExchange(cConn, cfg, ext); err != nil {
+		return nil, "", fmt.Errorf("key exchange failed: %w", err)
 	}
 
 	sConn.StopRecording()
-	return cConn, nil
+	return cConn, userID, nil
 }
 
 func abs(x int64) int64 {
@@ -0,0 +1,210 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PingMagicByte marks a Sudoku tunnel connection used as a health probe.
+// The client sends an 8-byte nonce after it and the server echoes the nonce back,
+// which proves the server accepted the handshake and is forwarding.
+const PingMagicByte byte = 0xEC
+
+const pingNonceSize = 8
+
+// DefaultHealthCheckInterval is used when the config leaves the interval unset.
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// Prober is implemented by dialers that can check their server end-to-end.
+type Prober interface {
+	Probe() (time.Duration, error)
+}
+
+// HandlePingServer answers a health probe whose magic byte has already been consumed.
+func HandlePingServer(conn net.Conn) error {
+	defer conn.Close()
+	nonce := make([]byte, pingNonceSize)
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	if _, err := io.ReadFull(conn, nonce); err != nil {
+		return fmt.Errorf("read ping: %w", err)
+	}
+	_, err := conn.Write(nonce)
+	return err
+}
+
+// Probe opens a fresh tunnel, exchanges a ping and returns the round trip including the handshake.
+func (d *BaseDialer) Probe() (time.Duration, error) {
+	start := time.Now()
+	conn, err := d.dialNew()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	msg := make([]byte, 1+pingNonceSize)
+	msg[0] = PingMagicByte
+	if _, err := rand.Read(msg[1:]); err != nil {
+		return 0, err
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return 0, fmt.Errorf("write ping: %w", err)
+	}
+	reply := make([]byte, pingNonceSize)
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return 0, fmt.Errorf("read pong: %w", err)
+	}
+	if !bytes.Equal(reply, msg[1:]) {
+		return 0, fmt.Errorf("pong mismatch")
+	}
+	return time.Since(start), nil
+}
+
+// Upstream is one Sudoku server with its dialer and last known health.
+type Upstream struct {
+	Address string
+	Dialer  Dialer
+
+	healthy atomic.Bool
+	latency atomic.Int64 // nanoseconds, 0 = not measured
+	active  atomic.Int64 // open connections handed out by a BalancedDialer
+}
+
+// NewUpstream wraps the dialer for one server. It starts out healthy.
+func NewUpstream(address string, dialer Dialer) *Upstream {
+	u := &Upstream{Address: address, Dialer: dialer}
+	u.healthy.Store(true)
+	return u
+}
+
+// Healthy reports the result of the last probe or dial.
+func (u *Upstream) Healthy() bool { return u.healthy.Load() }
+
+// Latency returns the last measured probe round trip, or 0 if unknown.
+func (u *Upstream) Latency() time.Duration { return time.Duration(u.latency.Load()) }
+
+// ActiveConns returns the number of open connections through this server.
+func (u *Upstream) ActiveConns() int64 { return u.active.Load() }
+
+// Check probes the server (when the dialer supports it) and records the result.
+func (u *Upstream) Check() error {
+	prober, ok := u.Dialer.(Prober)
+	if !ok {
+		return nil
+	}
+	rtt, err := prober.Probe()
+	if err != nil {
+		u.healthy.Store(false)
+		return err
+	}
+	u.latency.Store(int64(rtt))
+	u.healthy.Store(true)
+	return nil
+}
+
+func (u *Upstream) markFailed() { u.healthy.Store(false) }
+
+func (u *Upstream) dialUoT() (net.Conn, error) {
+	uot, ok := u.Dialer.(UoTDialer)
+	if !ok {
+		return nil, fmt.Errorf("%s: udp over tcp not supported", u.Address)
+	}
+	return uot.DialUDPOverTCP()
+}
+
+func (u *Upstream) dialBind(peerAddr string) (net.Conn, error) {
+	bind, ok := u.Dialer.(BindDialer)
+	if !ok {
+		return nil, fmt.Errorf("%s: bind not supported", u.Address)
+	}
+	return bind.DialBind(peerAddr)
+}
+
+// upstreamGroup holds the servers shared by the multi-server dialers and runs their health checks.
+type upstreamGroup struct {
+	upstreams []*Upstream
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newUpstreamGroup(upstreams []*Upstream) upstreamGroup {
+	return upstreamGroup{upstreams: upstreams, stop: make(chan struct{})}
+}
+
+// Upstreams returns the configured servers.
+func (g *upstreamGroup) Upstreams() []*Upstream { return g.upstreams }
+
+// Close stops the health checks and closes the servers' dialers (their connection pools).
+func (g *upstreamGroup) Close() error {
+	g.stopOnce.Do(func() {
+		close(g.stop)
+		for _, u := range g.upstreams {
+			if c, ok := u.Dialer.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	})
+	return nil
+}
+
+// healthLoop runs check right away and then every interval (<= 0 uses the default).
+func (g *upstreamGroup) healthLoop(interval time.Duration, check func()) {
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			check()
+		case <-g.stop:
+			return
+		}
+	}
+}
+
+// checkUpstreams probes every server concurrently and logs state changes.
+func (g *upstreamGroup) checkUpstreams() {
+	var wg sync.WaitGroup
+	for _, u := range g.upstreams {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			wasHealthy := u.Healthy()
+			if err := u.Check(); err != nil {
+				if wasHealthy {
+					log.Printf("[Health] %s is down: %v", u.Address, err)
+				}
+			} else if !wasHealthy {
+				log.Printf("[Health] %s is back (%v)", u.Address, u.Latency().Round(time.Millisecond))
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+// dialInOrder tries the servers in order until one succeeds, marking failures unhealthy.
+func (g *upstreamGroup) dialInOrder(order []int, dial func(*Upstream) (net.Conn, error)) (net.Conn, int, error) {
+	var lastErr error
+	for _, i := range order {
+		u := g.upstreams[i]
+		conn, err := dial(u)
+		if err == nil {
+			return conn, i, nil
+		}
+		log.Printf("[Upstream] %s failed: %v", u.Address, err)
+		u.markFailed()
+		lastErr = err
+	}
+	return nil, -1, fmt.Errorf("all servers failed: %w", lastErr)
+}
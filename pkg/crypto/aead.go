@@ -5,22 +5,60 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
+const (
+	// MaxFramePayload bounds the plaintext carried by one counter-mode frame.
+	// Frames announcing a longer body are rejected before anything is allocated.
+	MaxFramePayload = 16 * 1024
+	// DefaultRekeyBytes is how much a direction encrypts under one key when no policy is set.
+	DefaultRekeyBytes int64 = 1 << 30
+)
+
+// AEADConn frames a stream into AEAD sealed records.
+//
+// By default every frame is [len(2)][nonce][ciphertext] with a random in-band nonce
+// and one key for both directions. After EnableCounterNonce the frame becomes
+// [len(2)][ciphertext]: the nonce is an implicit per-direction counter, each
+// direction has its own key and the length prefix is authenticated, so frames
+// that are dropped, reordered or replayed within the session fail to decrypt.
+//
+// In counter mode a frame with an empty plaintext is a key update: the sender
+// switches its direction to the next key right after it, and the receiver follows.
+// Each direction rekeys independently, see SetRekeyPolicy.
 type AEADConn struct {
 	net.Conn
 	aead      cipher.AEAD
+	method    string
 	readBuf   bytes.Buffer
 	nonceSize int
+
+	counter bool
+	recv    cipher.AEAD
+	recvKey []byte
+	recvSeq uint64
+
+	writeMu       sync.Mutex
+	send          cipher.AEAD // counter-mode send key; rekeys replace it, never aead, which Read checks unlocked
+	sendKey       []byte
+	sendSeq       uint64
+	sentBytes     int64
+	keyedAt       time.Time
+	rekeyBytes    int64
+	rekeyInterval time.Duration
 }
 
 func NewAEADConn(c net.Conn, key string, method string) (*AEADConn, error) {
@@ -32,33 +70,123 @@ func NewAEADConn(c net.Conn, key string, method string) (*AEADConn, error) {
 	h.Write([]byte(key))
 	keyBytes := h.Sum(nil)
 
-	var aead cipher.AEAD
-	var err error
+	aead, err := newAEAD(method, keyBytes)
+	if err != nil {
+		return nil, err
+	}
 
+	return &AEADConn{
+		Conn:      c,
+		aead:      aead,
+		method:    method,
+		nonceSize: aead.NonceSize(),
+	}, nil
+}
+
+func newAEAD(method string, keyBytes []byte) (cipher.AEAD, error) {
 	switch method {
 	case "aes-128-gcm":
 		block, _ := aes.NewCipher(keyBytes[:16])
-		aead, err = cipher.NewGCM(block)
+		return cipher.NewGCM(block)
 	case "chacha20-poly1305":
-		aead, err = chacha20poly1305.New(keyBytes)
+		return chacha20poly1305.New(keyBytes)
 	default:
 		return nil, fmt.Errorf("unsupported cipher: %s", method)
 	}
+}
+
+// SetSessionKey switches to a 32-byte session key for all following frames.
+// Plaintext already decrypted under the previous key stays readable.
+// It must not be called concurrently with Read or Write.
+func (cc *AEADConn) SetSessionKey(keyBytes []byte) error {
+	if cc.aead == nil {
+		return nil
+	}
+	if len(keyBytes) != 32 {
+		return fmt.Errorf("invalid session key length: %d", len(keyBytes))
+	}
+	aead, err := newAEAD(cc.method, keyBytes)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	cc.aead = aead
+	cc.nonceSize = aead.NonceSize()
+	return nil
+}
 
-	return &AEADConn{
-		Conn:      c,
-		aead:      aead,
-		nonceSize: aead.NonceSize(),
-	}, nil
+// EnableCounterNonce switches to counter-nonce framing with separate 32-byte keys
+// for the send and receive directions. Both counters start at zero, so the keys
+// must be unique to this session. It must not be called concurrently with Read or Write.
+func (cc *AEADConn) EnableCounterNonce(sendKey, recvKey []byte) error {
+	if cc.aead == nil {
+		return errors.New("counter nonces require AEAD")
+	}
+	if len(sendKey) != 32 || len(recvKey) != 32 {
+		return errors.New("invalid direction key length")
+	}
+	send, err := newAEAD(cc.method, sendKey)
+	if err != nil {
+		return err
+	}
+	recv, err := newAEAD(cc.method, recvKey)
+	if err != nil {
+		return err
+	}
+	cc.aead, cc.send, cc.recv = send, send, recv
+	cc.sendKey = append([]byte(nil), sendKey...)
+	cc.recvKey = append([]byte(nil), recvKey...)
+	cc.nonceSize = send.NonceSize()
+	cc.counter = true
+	cc.sendSeq, cc.recvSeq = 0, 0
+	cc.sentBytes, cc.keyedAt = 0, time.Now()
+	if cc.rekeyBytes == 0 {
+		cc.rekeyBytes = DefaultRekeyBytes
+	}
+	return nil
+}
+
+// SetRekeyPolicy makes the send direction move to a fresh key once it has
+// encrypted maxBytes or the key is older than interval, whichever comes first.
+// maxBytes <= 0 selects DefaultRekeyBytes; interval <= 0 disables the time limit.
+// It only affects counter-nonce framing.
+func (cc *AEADConn) SetRekeyPolicy(maxBytes int64, interval time.Duration) {
+	cc.writeMu.Lock()
+	defer cc.writeMu.Unlock()
+	if maxBytes <= 0 {
+		maxBytes = DefaultRekeyBytes
+	}
+	cc.rekeyBytes = maxBytes
+	cc.rekeyInterval = interval
+}
+
+func nextTrafficKey(key []byte) ([]byte, error) {
+	return hkdf.Expand(sha256.New, key, "sudoku-rekey", 32)
+}
+
+// DirectionKeys splits a session key into independent client->server and
+// server->client keys for counter-nonce framing.
+func DirectionKeys(sessionKey []byte) (c2s, s2c []byte, err error) {
+	if c2s, err = hkdf.Expand(sha256.New, sessionKey, "sudoku-c2s", 32); err != nil {
+		return nil, nil, err
+	}
+	if s2c, err = hkdf.Expand(sha256.New, sessionKey, "sudoku-s2c", 32); err != nil {
+		return nil, nil, err
+	}
+	return c2s, s2c, nil
+}
+
+func counterNonce(nonce []byte, seq uint64) {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
 }
 
 func (cc *AEADConn) Write(p []byte) (int, error) {
 	if cc.aead == nil {
 		return cc.Conn.Write(p)
 	}
+	if cc.counter {
+		return cc.writeCounter(p)
+	}
 
 	maxPayload := 65535 - cc.nonceSize - cc.aead.Overhead()
 	totalWritten := 0
@@ -95,6 +223,72 @@ func (cc *AEADConn) Write(p []byte) (int, error) {
 	return totalWritten, nil
 }
 
+func (cc *AEADConn) writeCounter(p []byte) (int, error) {
+	cc.writeMu.Lock()
+	defer cc.writeMu.Unlock()
+
+	totalWritten := 0
+	for len(p) > 0 {
+		if cc.sentBytes >= cc.rekeyBytes || (cc.rekeyInterval > 0 && time.Since(cc.keyedAt) >= cc.rekeyInterval) {
+			if err := cc.rekeySendLocked(); err != nil {
+				return totalWritten, err
+			}
+		}
+		chunkSize := min(len(p), MaxFramePayload)
+		if err := cc.writeFrameLocked(p[:chunkSize]); err != nil {
+			return totalWritten, err
+		}
+		p = p[chunkSize:]
+		cc.sentBytes += int64(chunkSize)
+		totalWritten += chunkSize
+	}
+	return totalWritten, nil
+}
+
+func (cc *AEADConn) writeFrameLocked(chunk []byte) error {
+	if cc.sendSeq == math.MaxUint64 {
+		return errors.New("nonce space exhausted")
+	}
+	overhead := cc.send.Overhead()
+	frame := make([]byte, 2, 2+len(chunk)+overhead)
+	binary.BigEndian.PutUint16(frame, uint16(len(chunk)+overhead))
+	nonce := make([]byte, cc.nonceSize)
+	counterNonce(nonce, cc.sendSeq)
+	cc.sendSeq++
+	frame = cc.send.Seal(frame, nonce, chunk, frame[:2])
+	_, err := cc.Conn.Write(frame)
+	return err
+}
+
+// rekeySendLocked announces a key update with an empty frame and moves the
+// send direction to the next key.
+func (cc *AEADConn) rekeySendLocked() error {
+	next, err := nextTrafficKey(cc.sendKey)
+	if err != nil {
+		return err
+	}
+	send, err := newAEAD(cc.method, next)
+	if err != nil {
+		return err
+	}
+	if err := cc.writeFrameLocked(nil); err != nil {
+		return err
+	}
+	cc.send, cc.sendKey = send, next
+	cc.sendSeq, cc.sentBytes, cc.keyedAt = 0, 0, time.Now()
+	return nil
+}
+
+// Rekey forces the send direction onto the next key.
+func (cc *AEADConn) Rekey() error {
+	if !cc.counter {
+		return errors.New("rekeying requires counter nonces")
+	}
+	cc.writeMu.Lock()
+	defer cc.writeMu.Unlock()
+	return cc.rekeySendLocked()
+}
+
 func (cc *AEADConn) Read(p []byte) (int, error) {
 	if cc.aead == nil {
 		return cc.Conn.Read(p)
@@ -103,6 +297,9 @@ func (cc *AEADConn) Read(p []byte) (int, error) {
 	if cc.readBuf.Len() > 0 {
 		return cc.readBuf.Read(p)
 	}
+	if cc.counter {
+		return cc.readCounter(p)
+	}
 
 	header := make([]byte, 2)
 	if _, err := io.ReadFull(cc.Conn, header); err != nil {
@@ -129,3 +326,57 @@ func (cc *AEADConn) Read(p []byte) (int, error) {
 	cc.readBuf.Write(plaintext)
 	return cc.readBuf.Read(p)
 }
+
+func (cc *AEADConn) readCounter(p []byte) (int, error) {
+	for cc.readBuf.Len() == 0 {
+		if err := cc.readCounterFrame(); err != nil {
+			return 0, err
+		}
+	}
+	return cc.readBuf.Read(p)
+}
+
+func (cc *AEADConn) readCounterFrame() error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(cc.Conn, header); err != nil {
+		return err
+	}
+	frameLen := int(binary.BigEndian.Uint16(header))
+	overhead := cc.recv.Overhead()
+	if frameLen < overhead || frameLen > MaxFramePayload+overhead {
+		return fmt.Errorf("invalid frame length: %d", frameLen)
+	}
+
+	body := make([]byte, frameLen)
+	if _, err := io.ReadFull(cc.Conn, body); err != nil {
+		return err
+	}
+	if cc.recvSeq == math.MaxUint64 {
+		return errors.New("nonce space exhausted")
+	}
+
+	nonce := make([]byte, cc.nonceSize)
+	counterNonce(nonce, cc.recvSeq)
+	plaintext, err := cc.recv.Open(body[:0], nonce, body, header)
+	if err != nil {
+		// The expected counter never matches a dropped, reordered or replayed frame.
+		return errors.New("decryption failed: frame out of order or tampered")
+	}
+	cc.recvSeq++
+
+	if len(plaintext) == 0 {
+		// Key update: the peer's next frame uses the next key.
+		next, err := nextTrafficKey(cc.recvKey)
+		if err != nil {
+			return err
+		}
+		recv, err := newAEAD(cc.method, next)
+		if err != nil {
+			return err
+		}
+		cc.recv, cc.recvKey, cc.recvSeq = recv, next, 0
+		return nil
+	}
+	cc.readBuf.Write(plaintext)
+	return nil
+}
@@ -1,9 +1,12 @@
 package crypto
 
 import (
+	"bytes"
 	"io"
 	"net"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAEADConnRoundTrip_Chacha(t *testing.T) {
@@ -68,3 +71,139 @@ func TestAEADConnUnsupported(t *testing.T) {
 		t.Fatalf("expected error for unsupported cipher")
 	}
 }
+
+// frameRecorder captures what an AEADConn writes, one Write per frame.
+type frameRecorder struct {
+	net.Conn
+	frames [][]byte
+}
+
+func (r *frameRecorder) Write(p []byte) (int, error) {
+	r.frames = append(r.frames, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func counterPair(t *testing.T, w, r net.Conn) (*AEADConn, *AEADConn) {
+	t.Helper()
+	c2s, s2c, err := DirectionKeys(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("DirectionKeys failed: %v", err)
+	}
+	writer, _ := NewAEADConn(w, "k", "chacha20-poly1305")
+	reader, _ := NewAEADConn(r, "k", "chacha20-poly1305")
+	if err := writer.EnableCounterNonce(c2s, s2c); err != nil {
+		t.Fatalf("EnableCounterNonce failed: %v", err)
+	}
+	reader.EnableCounterNonce(s2c, c2s)
+	return writer, reader
+}
+
+func TestAEADConnCounterNonce(t *testing.T) {
+	rec := &frameRecorder{}
+	writer, _ := counterPair(t, rec, nil)
+
+	payload := bytes.Repeat([]byte("x"), MaxFramePayload+10)
+	if _, err := writer.Write(payload); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	writer.Write([]byte("tail"))
+	if len(rec.frames) != 3 {
+		t.Fatalf("expected 3 bounded frames, got %d", len(rec.frames))
+	}
+	// No in-band nonce: length prefix + ciphertext + tag only.
+	if got := len(rec.frames[2]); got != 2+4+16 {
+		t.Fatalf("unexpected frame size %d", got)
+	}
+
+	read := func(frames ...[]byte) (string, error) {
+		_, reader := counterPair(t, nil, &readOnlyPipe{Reader: bytes.NewReader(bytes.Join(frames, nil))})
+		out, err := io.ReadAll(reader)
+		return string(out), err
+	}
+
+	if out, err := read(rec.frames...); err != nil || out != string(payload)+"tail" {
+		t.Fatalf("in-order read failed: %d bytes, %v", len(out), err)
+	}
+	if _, err := read(rec.frames[1], rec.frames[0]); err == nil {
+		t.Fatalf("reordered frames must be rejected")
+	}
+	if _, err := read(rec.frames[0], rec.frames[0]); err == nil {
+		t.Fatalf("replayed frame must be rejected")
+	}
+
+	oversized := []byte{0xFF, 0xFF}
+	if _, err := read(oversized); err == nil || !strings.Contains(err.Error(), "frame length") {
+		t.Fatalf("oversized frame must be rejected, got %v", err)
+	}
+}
+
+type readOnlyPipe struct {
+	net.Conn
+	*bytes.Reader
+}
+
+func (r *readOnlyPipe) Read(p []byte) (int, error) { return r.Reader.Read(p) }
+
+func TestAEADConnRekey(t *testing.T) {
+	rec := &frameRecorder{}
+	writer, _ := counterPair(t, rec, nil)
+	writer.SetRekeyPolicy(100, 0)
+
+	var want bytes.Buffer
+	for i := 0; i < 10; i++ {
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, 60)
+		want.Write(chunk)
+		if _, err := writer.Write(chunk); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	if err := writer.Rekey(); err != nil {
+		t.Fatalf("forced rekey failed: %v", err)
+	}
+	writer.Write([]byte("end"))
+	want.WriteString("end")
+
+	updates := 0
+	for _, f := range rec.frames {
+		if len(f) == 2+16 {
+			updates++
+		}
+	}
+	if updates != 5 {
+		t.Fatalf("expected 5 key updates, got %d", updates)
+	}
+
+	_, reader := counterPair(t, nil, &readOnlyPipe{Reader: bytes.NewReader(bytes.Join(rec.frames, nil))})
+	got, err := io.ReadAll(reader)
+	if err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("read across key updates failed: %d bytes, %v", len(got), err)
+	}
+
+	// Dropping a key update desynchronizes the reader instead of being skipped.
+	var dropped [][]byte
+	skipped := false
+	for _, f := range rec.frames {
+		if len(f) == 2+16 && !skipped {
+			skipped = true
+			continue
+		}
+		dropped = append(dropped, f)
+	}
+	_, reader = counterPair(t, nil, &readOnlyPipe{Reader: bytes.NewReader(bytes.Join(dropped, nil))})
+	if _, err := io.ReadAll(reader); err == nil {
+		t.Fatalf("missing key update must be rejected")
+	}
+}
+
+func TestAEADConnRekeyInterval(t *testing.T) {
+	rec := &frameRecorder{}
+	writer, _ := counterPair(t, rec, nil)
+	writer.SetRekeyPolicy(0, time.Millisecond)
+
+	writer.Write([]byte("one"))
+	time.Sleep(5 * time.Millisecond)
+	writer.Write([]byte("two"))
+	if len(rec.frames) != 3 || len(rec.frames[1]) != 2+16 {
+		t.Fatalf("expected a key update between the writes, got %d frames", len(rec.frames))
+	}
+}
@@ -0,0 +1,129 @@
+package crypto
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"filippo.io/edwards25519"
+)
+
+const (
+	// IdentitySize is the length of an encoded key identity (compressed point).
+	IdentitySize = 32
+	// IdentitySignatureSize is the length of a Schnorr signature (R || s).
+	IdentitySignatureSize = 64
+)
+
+// identityScalar extracts the per-client secret scalar from a private key.
+// Split keys (r || k) identify themselves with r, which differs for every split
+// of the same master key; a master key (x) identifies itself with x.
+func identityScalar(key []byte) (*edwards25519.Scalar, error) {
+	switch len(key) {
+	case 32:
+		return edwards25519.NewScalar().SetCanonicalBytes(key)
+	case 64:
+		return edwards25519.NewScalar().SetCanonicalBytes(key[:32])
+	default:
+		return nil, errors.New("invalid key length: must be 32 bytes (Master) or 64 bytes (Split)")
+	}
+}
+
+// KeyIdentity returns the public identity (hex) of a master or split private key.
+// The server lists these identities to authorize individual clients.
+func KeyIdentity(keyHex string) (string, error) {
+	keyBytes, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return "", fmt.Errorf("invalid hex: %w", err)
+	}
+	s, err := identityScalar(keyBytes)
+	if err != nil {
+		return "", err
+	}
+	return EncodePoint(new(edwards25519.Point).ScalarBaseMult(s)), nil
+}
+
+// ParseIdentity decodes and validates a hex encoded key identity.
+func ParseIdentity(identityHex string) ([]byte, error) {
+	raw, err := hex.DecodeString(identityHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex: %w", err)
+	}
+	if len(raw) != IdentitySize {
+		return nil, fmt.Errorf("invalid identity length: %d", len(raw))
+	}
+	if _, err := new(edwards25519.Point).SetBytes(raw); err != nil {
+		return nil, fmt.Errorf("invalid identity point: %w", err)
+	}
+	return raw, nil
+}
+
+// SignWithIdentity proves possession of the private key by signing msg.
+// It returns the signer identity and a Schnorr signature over edwards25519.
+func SignWithIdentity(key []byte, msg []byte) ([]byte, []byte, error) {
+	a, err := identityScalar(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	identity := new(edwards25519.Point).ScalarBaseMult(a).Bytes()
+
+	// Deterministic nonce: n = H(a || msg), so a weak RNG cannot leak the key.
+	h := sha512.New()
+	h.Write([]byte("sudoku-identity-nonce"))
+	h.Write(a.Bytes())
+	h.Write(msg)
+	n, err := edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+	R := new(edwards25519.Point).ScalarBaseMult(n).Bytes()
+
+	c, err := identityChallenge(R, identity, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := edwards25519.NewScalar().MultiplyAdd(c, a, n)
+
+	sig := make([]byte, 0, IdentitySignatureSize)
+	sig = append(sig, R...)
+	sig = append(sig, s.Bytes()...)
+	return identity, sig, nil
+}
+
+// VerifyIdentity checks a signature produced by SignWithIdentity.
+func VerifyIdentity(identity, msg, sig []byte) bool {
+	if len(identity) != IdentitySize || len(sig) != IdentitySignatureSize {
+		return false
+	}
+	A, err := new(edwards25519.Point).SetBytes(identity)
+	if err != nil {
+		return false
+	}
+	R, err := new(edwards25519.Point).SetBytes(sig[:32])
+	if err != nil {
+		return false
+	}
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(sig[32:])
+	if err != nil {
+		return false
+	}
+	c, err := identityChallenge(sig[:32], identity, msg)
+	if err != nil {
+		return false
+	}
+
+	// s*G == R + c*A
+	lhs := new(edwards25519.Point).ScalarBaseMult(s)
+	rhs := new(edwards25519.Point).Add(R, new(edwards25519.Point).ScalarMult(c, A))
+	return lhs.Equal(rhs) == 1
+}
+
+func identityChallenge(R, identity, msg []byte) (*edwards25519.Scalar, error) {
+	h := sha512.New()
+	h.Write([]byte("sudoku-identity"))
+	h.Write(R)
+	h.Write(identity)
+	h.Write(msg)
+	return edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+}
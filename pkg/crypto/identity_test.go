@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestIdentitySignVerify(t *testing.T) {
+	pair, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatalf("GenerateMasterKey failed: %v", err)
+	}
+	splitA, _ := SplitPrivateKey(pair.Private)
+	splitB, _ := SplitPrivateKey(pair.Private)
+
+	idA, err := KeyIdentity(splitA)
+	if err != nil {
+		t.Fatalf("KeyIdentity failed: %v", err)
+	}
+	idB, _ := KeyIdentity(splitB)
+	if idA == idB {
+		t.Fatalf("split keys of the same master must have distinct identities")
+	}
+
+	keyA, _ := hex.DecodeString(splitA)
+	msg := []byte("handshake transcript")
+	identity, sig, err := SignWithIdentity(keyA, msg)
+	if err != nil {
+		t.Fatalf("SignWithIdentity failed: %v", err)
+	}
+	if hex.EncodeToString(identity) != idA {
+		t.Fatalf("signer identity mismatch")
+	}
+	if !VerifyIdentity(identity, msg, sig) {
+		t.Fatalf("valid signature rejected")
+	}
+	if VerifyIdentity(identity, []byte("other transcript"), sig) {
+		t.Fatalf("signature accepted for a different message")
+	}
+
+	rawB, err := ParseIdentity(idB)
+	if err != nil {
+		t.Fatalf("ParseIdentity failed: %v", err)
+	}
+	if VerifyIdentity(rawB, msg, sig) {
+		t.Fatalf("signature accepted for a different identity")
+	}
+}
+
+func TestParseIdentityRejectsGarbage(t *testing.T) {
+	if _, err := ParseIdentity("zz"); err == nil {
+		t.Fatalf("expected error for invalid hex")
+	}
+	if _, err := ParseIdentity("abcd"); err == nil {
+		t.Fatalf("expected error for short identity")
+	}
+}
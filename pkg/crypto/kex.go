@@ -0,0 +1,35 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// EphemeralKeySize is the length of an encoded X25519 public key.
+const EphemeralKeySize = 32
+
+// GenerateEphemeralKey creates a one-shot X25519 key pair for a single session.
+func GenerateEphemeralKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// DeriveSessionKey mixes the X25519 shared secret with the pre-shared key.
+// The pre-shared key is used as HKDF salt so a session key can only be derived by
+// someone who both knows the key and took part in the exchange; info binds the
+// result to the handshake transcript. Once the ephemeral keys are discarded,
+// recorded traffic cannot be decrypted even if the pre-shared key later leaks.
+func DeriveSessionKey(priv *ecdh.PrivateKey, peerPublic []byte, psk string, info []byte) ([]byte, error) {
+	peer, err := ecdh.X25519().NewPublicKey(peerPublic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer public key: %w", err)
+	}
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange failed: %w", err)
+	}
+	salt := sha256.Sum256([]byte(psk))
+	return hkdf.Key(sha256.New, shared, salt[:], "sudoku-session|"+string(info), 32)
+}
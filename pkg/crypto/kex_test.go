@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestDeriveSessionKeyAgreement(t *testing.T) {
+	a, err := GenerateEphemeralKey()
+	if err != nil {
+		t.Fatalf("keygen failed: %v", err)
+	}
+	b, _ := GenerateEphemeralKey()
+	info := []byte("transcript")
+
+	ka, err := DeriveSessionKey(a, b.PublicKey().Bytes(), "psk", info)
+	if err != nil {
+		t.Fatalf("derive failed: %v", err)
+	}
+	kb, _ := DeriveSessionKey(b, a.PublicKey().Bytes(), "psk", info)
+	if !bytes.Equal(ka, kb) {
+		t.Fatalf("both sides must derive the same key")
+	}
+
+	if other, _ := DeriveSessionKey(a, b.PublicKey().Bytes(), "other-psk", info); bytes.Equal(ka, other) {
+		t.Fatalf("session key must depend on the pre-shared key")
+	}
+	if other, _ := DeriveSessionKey(a, b.PublicKey().Bytes(), "psk", []byte("tampered")); bytes.Equal(ka, other) {
+		t.Fatalf("session key must depend on the transcript")
+	}
+	if _, err := DeriveSessionKey(a, []byte("short"), "psk", info); err == nil {
+		t.Fatalf("expected error for malformed public key")
+	}
+}
+
+func TestAEADConnSetSessionKey(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	connA, _ := NewAEADConn(left, "secret-key", "aes-128-gcm")
+	connB, _ := NewAEADConn(right, "secret-key", "aes-128-gcm")
+	session := bytes.Repeat([]byte{0x42}, 32)
+
+	go func() {
+		connA.Write([]byte("before"))
+		connA.SetSessionKey(session)
+		connA.Write([]byte("after"))
+	}()
+
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(connB, buf); err != nil || string(buf) != "before" {
+		t.Fatalf("pre-switch read failed: %q %v", buf, err)
+	}
+
+	// A reader still holding the pre-shared key cannot open session frames.
+	stale, _ := NewAEADConn(right, "secret-key", "aes-128-gcm")
+	if _, err := stale.Read(buf); err == nil {
+		t.Fatalf("session frame should not decrypt under the pre-shared key")
+	}
+
+	go connA.Write([]byte("again"))
+	if err := connB.SetSessionKey(session); err != nil {
+		t.Fatalf("SetSessionKey failed: %v", err)
+	}
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(connB, buf); err != nil || string(buf) != "again" {
+		t.Fatalf("post-switch read failed: %q %v", buf, err)
+	}
+}
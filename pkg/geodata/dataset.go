@@ -0,0 +1,119 @@
+package geodata
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Dataset 加载单个数据源（如 geoip.dat / geosite.dat）并在后台刷新。
+// parse 解析并应用内容，返回错误时调用方应保留现有数据
+type Dataset struct {
+	source   string
+	parse    func([]byte) error
+	interval time.Duration
+	*sourceFetcher
+
+	ctx       context.Context // Close 时取消，中断进行中的下载
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewDataset 创建数据源；cacheDir 为空时使用默认缓存目录，interval <= 0 时为 DefaultUpdateInterval
+func NewDataset(source, cacheDir string, interval time.Duration, parse func([]byte) error) *Dataset {
+	if interval <= 0 {
+		interval = DefaultUpdateInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dataset{
+		source:        source,
+		parse:         parse,
+		interval:      interval,
+		sourceFetcher: newSourceFetcher(cacheDir),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Start 同步加载本地文件或磁盘缓存；远程源随后在后台重新验证并按间隔刷新。
+// 只有远程源尚无可用缓存时才在启动路径上下载
+func (d *Dataset) Start() error {
+	if !isRemote(d.source) {
+		body, err := d.fetch(d.ctx, d.source, true, nil)
+		if err != nil {
+			return err
+		}
+		return d.parse(body)
+	}
+
+	revalidate := true
+	if cached, err := d.fetch(d.ctx, d.source, true, nil); err != nil || d.parse(cached) != nil {
+		if err := d.download(); err != nil {
+			return err
+		}
+		revalidate = false
+	}
+	d.wg.Add(1)
+	go d.refreshLoop(revalidate)
+	return nil
+}
+
+// Close 停止后台刷新并等待进行中的更新结束；已加载的数据仍可使用
+func (d *Dataset) Close() error {
+	d.closeOnce.Do(func() {
+		d.cancel()
+		d.wg.Wait()
+	})
+	return nil
+}
+
+// download 从网络获取并应用内容；请求失败时回退到缓存
+func (d *Dataset) download() error {
+	applied := false
+	body, err := d.fetch(d.ctx, d.source, false, func(b []byte) error {
+		err := d.parse(b)
+		applied = err == nil
+		return err
+	})
+	if err != nil || applied {
+		return err
+	}
+	return d.parse(body)
+}
+
+func (d *Dataset) refreshLoop(revalidate bool) {
+	defer d.wg.Done()
+	if revalidate {
+		d.refresh()
+	}
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+			d.refresh()
+		}
+	}
+}
+
+// refresh 重新验证远程内容；未变化（304）或失败时保留当前数据
+func (d *Dataset) refresh() {
+	applied := false
+	_, err := d.fetch(d.ctx, d.source, false, func(b []byte) error {
+		err := d.parse(b)
+		applied = err == nil
+		return err
+	})
+	switch {
+	case err != nil:
+		if d.ctx.Err() == nil {
+			log.Printf("[GeoData] Failed to refresh %s, keeping current data: %v", d.source, err)
+		}
+	case applied:
+		log.Printf("[GeoData] Updated %s", d.source)
+	}
+}
@@ -0,0 +1,80 @@
+package geodata
+
+import (
+	"net"
+	"strings"
+)
+
+// GeoIP 按国家/地区代码匹配 IP，数据来自 v2ray geoip.dat 或 MaxMind .mmdb
+type GeoIP struct {
+	db   *MMDB
+	sets map[string]*ipSet
+}
+
+// LoadGeoIP 解析 geoip.dat 或 .mmdb 内容（按内容自动识别）。
+// 对 geoip.dat 只保留 codes 中的条目（为空则全部），以节省内存
+func LoadGeoIP(data []byte, codes []string) (*GeoIP, error) {
+	if IsMMDB(data) {
+		db, err := ParseMMDB(data)
+		if err != nil {
+			return nil, err
+		}
+		return &GeoIP{db: db}, nil
+	}
+	sets, err := parseGeoIPDat(data, codes)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP{sets: sets}, nil
+}
+
+// Has 报告数据中是否包含 code；mmdb 无法预先列举，总是返回 true
+func (g *GeoIP) Has(code string) bool {
+	if g.db != nil {
+		return true
+	}
+	_, ok := g.sets[strings.ToUpper(code)]
+	return ok
+}
+
+// Match 判断 ip 是否属于 code 对应的国家/地区
+func (g *GeoIP) Match(code string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	code = strings.ToUpper(code)
+	if g.db != nil {
+		return g.db.Country(ip) == code
+	}
+	set, ok := g.sets[code]
+	return ok && set.contains(ip)
+}
+
+// GeoSite 按分类匹配域名，数据来自 v2ray geosite.dat
+type GeoSite struct {
+	sites map[string]*siteMatcher
+}
+
+// LoadGeoSite 解析 geosite.dat，只保留 codes 中的分类（可带 @属性 过滤，如 "category-ads-all@ads"）
+func LoadGeoSite(data []byte, codes []string) (*GeoSite, error) {
+	sites, err := parseGeoSiteDat(data, codes)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoSite{sites: sites}, nil
+}
+
+// Has 报告数据中是否包含分类 code
+func (g *GeoSite) Has(code string) bool {
+	_, ok := g.sites[strings.ToUpper(code)]
+	return ok
+}
+
+// Match 判断 domain 是否属于分类 code
+func (g *GeoSite) Match(code, domain string) bool {
+	site, ok := g.sites[strings.ToUpper(code)]
+	if !ok || domain == "" {
+		return false
+	}
+	return site.match(strings.ToLower(strings.TrimSuffix(domain, ".")))
+}
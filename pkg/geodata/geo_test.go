@@ -0,0 +1,296 @@
+package geodata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// ---- minimal protobuf encoder for v2ray dat fixtures ----
+
+func pbVarint(num int, v uint64) []byte {
+	b := binary.AppendUvarint(nil, uint64(num)<<3|wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func pbBytes(num int, content []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(num)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(content)))
+	return append(b, content...)
+}
+
+func geoIPEntry(code string, cidrs ...string) []byte {
+	entry := pbBytes(1, []byte(code))
+	for _, c := range cidrs {
+		p := netip.MustParsePrefix(c)
+		cidr := append(pbBytes(1, p.Addr().AsSlice()), pbVarint(2, uint64(p.Bits()))...)
+		entry = append(entry, pbBytes(2, cidr)...)
+	}
+	return pbBytes(1, entry)
+}
+
+type siteDomain struct {
+	typ   uint64
+	value string
+	attrs []string
+}
+
+func geoSiteEntry(code string, domains ...siteDomain) []byte {
+	entry := pbBytes(1, []byte(code))
+	for _, d := range domains {
+		msg := append(pbVarint(1, d.typ), pbBytes(2, []byte(d.value))...)
+		for _, a := range d.attrs {
+			msg = append(msg, pbBytes(3, append(pbBytes(1, []byte(a)), pbVarint(2, 1)...))...)
+		}
+		entry = append(entry, pbBytes(2, msg)...)
+	}
+	return pbBytes(1, entry)
+}
+
+func TestGeoIPDat(t *testing.T) {
+	var data []byte
+	data = append(data, geoIPEntry("CN", "1.0.1.0/24", "1.0.2.0/23", "240e::/18")...)
+	data = append(data, geoIPEntry("US", "8.8.8.0/24")...)
+	data = append(data, geoIPEntry("PRIVATE", "10.0.0.0/8", "fc00::/7")...)
+
+	g, err := LoadGeoIP(data, []string{"cn", "private"})
+	if err != nil {
+		t.Fatalf("LoadGeoIP failed: %v", err)
+	}
+	if g.Has("US") {
+		t.Fatalf("unrequested code should not be loaded")
+	}
+	cases := []struct {
+		code, ip string
+		want     bool
+	}{
+		{"CN", "1.0.1.9", true},
+		{"cn", "1.0.3.255", true},
+		{"CN", "1.0.4.0", false},
+		{"CN", "240e:1::1", true},
+		{"CN", "8.8.8.8", false},
+		{"PRIVATE", "10.1.1.1", true},
+		{"PRIVATE", "fd00::1", true},
+		{"US", "8.8.8.8", false},
+	}
+	for _, tc := range cases {
+		if got := g.Match(tc.code, net.ParseIP(tc.ip)); got != tc.want {
+			t.Fatalf("Match(%s, %s) = %v, want %v", tc.code, tc.ip, got, tc.want)
+		}
+	}
+
+	if _, err := LoadGeoIP(data[:len(data)-3], nil); err == nil {
+		t.Fatalf("truncated geoip.dat should fail")
+	}
+}
+
+func TestGeoSiteDat(t *testing.T) {
+	var data []byte
+	data = append(data, geoSiteEntry("CATEGORY-ADS-ALL",
+		siteDomain{typ: siteSuffix, value: "doubleclick.net", attrs: []string{"ads"}},
+		siteDomain{typ: siteFull, value: "ads.example.com"},
+		siteDomain{typ: siteKeyword, value: "adservice"},
+		siteDomain{typ: siteRegex, value: `^track[0-9]+\.example\.org$`},
+	)...)
+	data = append(data, geoSiteEntry("CN", siteDomain{typ: siteSuffix, value: "baidu.com"})...)
+
+	g, err := LoadGeoSite(data, []string{"category-ads-all", "category-ads-all@ads"})
+	if err != nil {
+		t.Fatalf("LoadGeoSite failed: %v", err)
+	}
+	if g.Has("cn") || !g.Has("category-ads-all@ads") {
+		t.Fatalf("loaded categories wrong")
+	}
+	cases := []struct {
+		code, domain string
+		want         bool
+	}{
+		{"category-ads-all", "doubleclick.net", true},
+		{"category-ads-all", "stats.g.doubleclick.net.", true},
+		{"category-ads-all", "notdoubleclick.net", false},
+		{"category-ads-all", "ads.example.com", true},
+		{"category-ads-all", "x.ads.example.com", false},
+		{"category-ads-all", "pagead.adservice.google.com", true},
+		{"category-ads-all", "track42.example.org", true},
+		{"category-ads-all@ads", "g.doubleclick.net", true},
+		{"category-ads-all@ads", "ads.example.com", false},
+	}
+	for _, tc := range cases {
+		if got := g.Match(tc.code, tc.domain); got != tc.want {
+			t.Fatalf("Match(%s, %s) = %v, want %v", tc.code, tc.domain, got, tc.want)
+		}
+	}
+}
+
+// ---- minimal MaxMind DB writer for mmdb fixtures ----
+
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func mmdbUint(typ byte, v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	raw := bytes.TrimLeft(b[:], "\x00")
+	return append([]byte{typ<<5 | byte(len(raw))}, raw...)
+}
+
+func mmdbMap(kv ...[]byte) []byte {
+	out := []byte{7<<5 | byte(len(kv)/2)}
+	for _, b := range kv {
+		out = append(out, b...)
+	}
+	return out
+}
+
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  [2]int // data offset + 1, 0 when empty
+}
+
+// buildMMDB writes a database mapping each prefix to {"country":{"iso_code":code}}.
+func buildMMDB(t *testing.T, ipVersion, recordSize int, prefixes map[string]string) []byte {
+	t.Helper()
+	var dataSection []byte
+	offsets := map[string]int{}
+	root := &mmdbNode{}
+	for cidr, code := range prefixes {
+		if _, ok := offsets[code]; !ok {
+			offsets[code] = len(dataSection)
+			dataSection = append(dataSection, mmdbMap(mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString(code)))...)
+		}
+		p := netip.MustParsePrefix(cidr)
+		addr, bits := p.Addr().AsSlice(), p.Bits()
+		if ipVersion == 6 && p.Addr().Is4() {
+			addr, bits = netip.AddrFrom16(p.Addr().As16()).AsSlice(), bits+96
+			copy(addr[10:12], []byte{0, 0}) // ::a.b.c.d rather than ::ffff:a.b.c.d
+		}
+		n := root
+		for i := 0; i < bits; i++ {
+			bit := (addr[i/8] >> (7 - uint(i%8))) & 1
+			if i == bits-1 {
+				n.data[bit] = offsets[code] + 1
+				break
+			}
+			if n.child[bit] == nil {
+				n.child[bit] = &mmdbNode{}
+			}
+			n = n.child[bit]
+		}
+	}
+
+	var nodes []*mmdbNode
+	index := map[*mmdbNode]int{}
+	queue := []*mmdbNode{root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil {
+				queue = append(queue, c)
+			}
+		}
+	}
+	count := len(nodes)
+	record := func(n *mmdbNode, side int) uint32 {
+		switch {
+		case n.child[side] != nil:
+			return uint32(index[n.child[side]])
+		case n.data[side] != 0:
+			return uint32(count + 16 + n.data[side] - 1)
+		}
+		return uint32(count)
+	}
+
+	var tree []byte
+	for _, n := range nodes {
+		l, r := record(n, 0), record(n, 1)
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			tree = append(tree, byte(l>>16), byte(l>>8), byte(l), byte(l>>24)<<4|byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		default:
+			tree = binary.BigEndian.AppendUint32(tree, l)
+			tree = binary.BigEndian.AppendUint32(tree, r)
+		}
+	}
+
+	out := append(tree, make([]byte, 16)...)
+	out = append(out, dataSection...)
+	out = append(out, mmdbMetadataMarker...)
+	out = append(out, mmdbMap(
+		mmdbString("node_count"), mmdbUint(6, uint32(count)),
+		mmdbString("record_size"), mmdbUint(5, uint32(recordSize)),
+		mmdbString("ip_version"), mmdbUint(5, uint32(ipVersion)),
+	)...)
+	return out
+}
+
+func TestMMDBCountryLookup(t *testing.T) {
+	prefixes := map[string]string{
+		"1.0.1.0/24": "CN",
+		"8.8.8.0/24": "US",
+	}
+	for _, tc := range []struct{ version, size int }{{4, 24}, {6, 28}, {6, 32}} {
+		p := prefixes
+		if tc.version == 6 {
+			p = map[string]string{"240e::/18": "cn"}
+			for k, v := range prefixes {
+				p[k] = v
+			}
+		}
+		data := buildMMDB(t, tc.version, tc.size, p)
+		if !IsMMDB(data) {
+			t.Fatalf("fixture not detected as mmdb")
+		}
+		g, err := LoadGeoIP(data, nil)
+		if err != nil {
+			t.Fatalf("v%d/%d: LoadGeoIP failed: %v", tc.version, tc.size, err)
+		}
+		if !g.Match("CN", net.ParseIP("1.0.1.200")) || !g.Match("us", net.ParseIP("8.8.8.8")) {
+			t.Fatalf("v%d/%d: IPv4 lookup failed", tc.version, tc.size)
+		}
+		if g.Match("CN", net.ParseIP("1.0.2.1")) || g.Match("CN", net.ParseIP("8.8.8.8")) {
+			t.Fatalf("v%d/%d: false positive", tc.version, tc.size)
+		}
+		if tc.version == 6 && !g.Match("CN", net.ParseIP("240e:1234::1")) {
+			t.Fatalf("v%d/%d: IPv6 lookup failed", tc.version, tc.size)
+		}
+	}
+}
+
+func TestMMDBRejectsMalformedData(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		// 指针指向自身
+		{"self pointer", []byte{1 << 5, 0x00}},
+		// map 的值指回 map 自身，形成环
+		{"pointer cycle", append(append([]byte{7<<5 | 1}, mmdbString("a")...), 1<<5, 0x00)},
+		// 声明约 1600 万个元素的 map，数据却只有几个字节
+		{"huge map", []byte{7<<5 | 31, 0xFF, 0xFF, 0xFF}},
+		{"huge array", []byte{0<<5 | 31, 4, 0xFF, 0xFF, 0xFF}},
+	} {
+		if _, _, err := decodeMMDB(tc.data, 0); err == nil {
+			t.Fatalf("%s: expected error", tc.name)
+		}
+		data := append(append([]byte(nil), mmdbMetadataMarker...), tc.data...)
+		if _, err := ParseMMDB(data); err == nil {
+			t.Fatalf("%s: ParseMMDB should fail", tc.name)
+		}
+	}
+}
+
+func FuzzDecodeMMDB(f *testing.F) {
+	f.Add(mmdbMap(mmdbString("iso_code"), mmdbString("CN")))
+	f.Add([]byte{1 << 5, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decodeMMDB(data, 0)
+	})
+}
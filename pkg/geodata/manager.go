@@ -3,14 +3,18 @@ package geodata
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
-	"net/http"
+	"net/netip"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -28,18 +32,55 @@ const (
 	lanRange4End   = 2147483647 // 127.255.255.255
 )
 
-// IPRange 表示一个 IP 区间 [Start, End]
+// IPRange 表示一个 IPv4 区间 [Start, End]
 type IPRange struct {
 	Start uint32
 	End   uint32
 }
 
+// IPRange6 表示一个 IPv6 区间 [Start, End]
+type IPRange6 struct {
+	Start netip.Addr
+	End   netip.Addr
+}
+
+// DefaultUpdateInterval 是未配置时远程规则的后台刷新间隔
+const DefaultUpdateInterval = 24 * time.Hour
+
+// Options 配置一个规则管理器
+type Options struct {
+	URLs           []string      // 规则源：http(s) URL、file:// 或本地路径
+	CacheDir       string        // 远程规则的本地缓存目录，为空时使用用户缓存目录下的 sudoku/rules
+	UpdateInterval time.Duration // 后台刷新间隔，<=0 时为 DefaultUpdateInterval
+}
+
+// Manager 加载并定期刷新一组规则源。各实例相互独立，由调用方通过 Start/Close 管理生命周期
 type Manager struct {
-	ipRanges     []IPRange
+	data     atomic.Pointer[ruleData] // 当前生效的规则快照，更新时整体替换
+	loadMu   sync.Mutex               // 串行化加载，保护 sources
+	sources  map[string]*ruleSet      // 每个源最近一次成功解析的内容
+	urls     []string
+	interval time.Duration
+	*sourceFetcher
+
+	ctx       context.Context // Close 时取消，中断进行中的下载
+	cancel    context.CancelFunc
+	startOnce sync.Once
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// ipSet 是合并、排序后的 IP 区间集合，可二分查找
+type ipSet struct {
+	ipRanges  []IPRange
+	ipRanges6 []IPRange6
+}
+
+// ruleData 是合并后的只读规则快照
+type ruleData struct {
+	ipSet
 	domainExact  map[string]struct{} // 精确匹配 DOMAIN
 	domainSuffix map[string]struct{} // 后缀匹配 DOMAIN-SUFFIX
-	mu           sync.RWMutex
-	urls         []string
 }
 
 // RuleSet 用于解析 YAML 格式的 payload
@@ -47,67 +88,212 @@ type RuleSet struct {
 	Payload []string `yaml:"payload"`
 }
 
-var instance *Manager
-var once sync.Once
+// NewManager 创建规则管理器；在调用 Start 之前不会加载任何规则
+func NewManager(opts Options) *Manager {
+	interval := opts.UpdateInterval
+	if interval <= 0 {
+		interval = DefaultUpdateInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		urls:          opts.URLs,
+		sources:       make(map[string]*ruleSet),
+		interval:      interval,
+		sourceFetcher: newSourceFetcher(opts.CacheDir),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
 
-// GetInstance 单例模式
-func GetInstance(urls []string) *Manager {
-	once.Do(func() {
-		instance = &Manager{
-			urls:         urls,
-			domainExact:  make(map[string]struct{}),
-			domainSuffix: make(map[string]struct{}),
-		}
-		go instance.Update()
+// Start 同步加载本地文件与磁盘缓存，然后在后台立即刷新远程规则并按间隔定期刷新。重复调用无效
+func (m *Manager) Start() {
+	m.startOnce.Do(func() {
+		m.LoadCached()
+		m.wg.Add(1)
+		go m.refreshLoop()
+	})
+}
+
+// Close 停止后台刷新并等待进行中的更新结束；已加载的规则仍可查询
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		m.cancel()
+		m.wg.Wait()
 	})
-	return instance
+	return nil
+}
+
+func (m *Manager) refreshLoop() {
+	defer m.wg.Done()
+	m.Update()
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.Update()
+		}
+	}
 }
 
+// Update 从网络刷新远程规则（带缓存校验），本地文件重新读取；下载失败的源回退到缓存，
+// 缓存也不可用时保留该源上一次的内容
 func (m *Manager) Update() {
+	if m.ctx.Err() != nil {
+		return
+	}
 	log.Printf("[GeoData] Updating rules from %d sources...", len(m.urls))
+	m.load(false)
+}
 
-	var tempRanges []IPRange
-	tempExact := make(map[string]struct{})
-	tempSuffix := make(map[string]struct{})
+// LoadCached 仅从本地文件和磁盘缓存加载规则，不访问网络；没有任何可用内容时保持现有规则
+func (m *Manager) LoadCached() {
+	m.load(true)
+}
+
+func (m *Manager) load(offline bool) {
+	m.loadMu.Lock()
+	defer m.loadMu.Unlock()
+
+	loaded := 0
+	for _, u := range m.urls {
+		// 下载的内容在校验时解析一次，之后直接复用；缓存与本地文件在下面解析
+		var set *ruleSet
+		body, err := m.fetch(m.ctx, u, offline, func(b []byte) error {
+			set = newRuleSet()
+			m.parseBody(b, set)
+			if set.empty() {
+				set = nil
+				return errNoRules
+			}
+			return nil
+		})
+		if err != nil {
+			if !offline {
+				if _, ok := m.sources[u]; ok {
+					log.Printf("[GeoData] Failed to load %s, keeping previous rules: %v", u, err)
+				} else {
+					log.Printf("[GeoData] Failed to load %s: %v", u, err)
+				}
+			}
+			continue
+		}
+		if set == nil {
+			set = newRuleSet()
+			m.parseBody(body, set)
+		}
+		m.sources[u] = set
+		loaded++
+	}
+	if offline && loaded == 0 {
+		return
+	}
 
+	sets := make([]*ruleSet, 0, len(m.urls))
 	for _, u := range m.urls {
-		m.downloadAndParse(u, &tempRanges, tempExact, tempSuffix)
+		if set, ok := m.sources[u]; ok {
+			sets = append(sets, set)
+		}
 	}
+	next := compileRules(sets...)
+	prev := m.data.Swap(next)
 
-	// 优化 IP 区间
-	mergedIPs := mergeRanges(tempRanges)
+	source := "network"
+	if offline {
+		source = "cache"
+	}
+	log.Printf("[GeoData] Rules Updated (%s, %d/%d sources): %d IP Ranges, %d IPv6 Ranges, %d Domains, %d Suffixes",
+		source, loaded, len(m.urls), len(next.ipRanges), len(next.ipRanges6), len(next.domainExact), len(next.domainSuffix))
+	if prev != nil {
+		log.Printf("[GeoData] Rules Diff: %s", diffRules(prev, next))
+	}
+}
 
-	m.mu.Lock()
-	m.ipRanges = mergedIPs
-	m.domainExact = tempExact
-	m.domainSuffix = tempSuffix
-	m.mu.Unlock()
+// compileRules 合并各源的规则并优化 IP 区间
+func compileRules(sets ...*ruleSet) *ruleData {
+	merged := newRuleSet()
+	for _, set := range sets {
+		merged.ipRanges = append(merged.ipRanges, set.ipRanges...)
+		merged.ipRanges6 = append(merged.ipRanges6, set.ipRanges6...)
+		for d := range set.exact {
+			merged.exact[d] = struct{}{}
+		}
+		for d := range set.suffix {
+			merged.suffix[d] = struct{}{}
+		}
+	}
+	return &ruleData{
+		ipSet: ipSet{
+			ipRanges:  mergeRanges(merged.ipRanges),
+			ipRanges6: mergeRanges6(merged.ipRanges6),
+		},
+		domainExact:  merged.exact,
+		domainSuffix: merged.suffix,
+	}
+}
 
-	log.Printf("[GeoData] Rules Updated: %d IP Ranges, %d Domains, %d Suffixes",
-		len(mergedIPs), len(tempExact), len(tempSuffix))
+// diffRules 统计两个快照之间新增/移除的条目
+func diffRules(prev, next *ruleData) string {
+	exactAdded, exactRemoved := diffKeys(prev.domainExact, next.domainExact)
+	suffixAdded, suffixRemoved := diffKeys(prev.domainSuffix, next.domainSuffix)
+	v4Added, v4Removed := diffKeys(toSet(prev.ipRanges), toSet(next.ipRanges))
+	v6Added, v6Removed := diffKeys(toSet(prev.ipRanges6), toSet(next.ipRanges6))
+	return fmt.Sprintf("Domains +%d/-%d, Suffixes +%d/-%d, IP Ranges +%d/-%d, IPv6 Ranges +%d/-%d",
+		exactAdded, exactRemoved, suffixAdded, suffixRemoved, v4Added, v4Removed, v6Added, v6Removed)
 }
 
-func (m *Manager) downloadAndParse(url string, ipRanges *[]IPRange, exact, suffix map[string]struct{}) {
-	client := http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Printf("[GeoData] Failed to download %s: %v", url, err)
-		return
+func toSet[T comparable](items []T) map[T]struct{} {
+	set := make(map[T]struct{}, len(items))
+	for _, item := range items {
+		set[item] = struct{}{}
 	}
-	defer resp.Body.Close()
+	return set
+}
 
-	// 读取全部内容
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("[GeoData] Failed to read body from %s: %v", url, err)
-		return
+func diffKeys[T comparable](prev, next map[T]struct{}) (added, removed int) {
+	for k := range next {
+		if _, ok := prev[k]; !ok {
+			added++
+		}
 	}
+	for k := range prev {
+		if _, ok := next[k]; !ok {
+			removed++
+		}
+	}
+	return added, removed
+}
 
+// ruleSet 汇总一次更新中解析出的规则
+type ruleSet struct {
+	ipRanges  []IPRange
+	ipRanges6 []IPRange6
+	exact     map[string]struct{}
+	suffix    map[string]struct{}
+}
+
+func newRuleSet() *ruleSet {
+	return &ruleSet{
+		exact:  make(map[string]struct{}),
+		suffix: make(map[string]struct{}),
+	}
+}
+
+var errNoRules = errors.New("no rules recognized")
+
+func (set *ruleSet) empty() bool {
+	return len(set.ipRanges) == 0 && len(set.ipRanges6) == 0 && len(set.exact) == 0 && len(set.suffix) == 0
+}
+
+// parseBody 解析一个规则源的内容：优先按 YAML payload，失败则按行
+func (m *Manager) parseBody(body []byte, set *ruleSet) {
 	// 1. 尝试作为 YAML 解析
 	var rs RuleSet
 	if err := yaml.Unmarshal(body, &rs); err == nil && len(rs.Payload) > 0 {
 		for _, rule := range rs.Payload {
-			m.parseRule(rule, ipRanges, exact, suffix)
+			m.parseRule(rule, set)
 		}
 		return
 	}
@@ -120,7 +306,7 @@ func (m *Manager) downloadAndParse(url string, ipRanges *[]IPRange, exact, suffi
 		if err != nil && err != io.EOF {
 			break
 		}
-		m.parseRule(line, ipRanges, exact, suffix)
+		m.parseRule(line, set)
 		if err == io.EOF {
 			break
 		}
@@ -128,7 +314,7 @@ func (m *Manager) downloadAndParse(url string, ipRanges *[]IPRange, exact, suffi
 }
 
 // parseRule 统一处理单行规则字符串
-func (m *Manager) parseRule(line string, ipRanges *[]IPRange, exact, suffix map[string]struct{}) {
+func (m *Manager) parseRule(line string, set *ruleSet) {
 	line = strings.TrimSpace(line)
 	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
 		return
@@ -143,58 +329,93 @@ func (m *Manager) parseRule(line string, ipRanges *[]IPRange, exact, suffix map[
 
 		switch ruleType {
 		case "DOMAIN":
-			exact[ruleValue] = struct{}{}
+			set.exact[ruleValue] = struct{}{}
 		case "DOMAIN-SUFFIX":
-			suffix[ruleValue] = struct{}{}
+			set.suffix[ruleValue] = struct{}{}
 		case "IP-CIDR", "IP-CIDR6":
-			// 处理 IP-CIDR,1.2.3.4/24
-			parseIPLine(ruleValue, ipRanges)
+			// 处理 IP-CIDR,1.2.3.4/24 与 IP-CIDR6,2001:db8::/32
+			parseIPLine(ruleValue, set)
 		}
 		return
 	}
 
 	// 2. 尝试解析纯 CIDR 或 IP
-	parseIPLine(line, ipRanges)
+	parseIPLine(line, set)
 }
 
-func parseIPLine(line string, list *[]IPRange) {
+func parseIPLine(line string, set *ruleSet) {
 	// 移除可能的引号
 	line = strings.Trim(line, "'\"")
 
-	_, ipNet, err := net.ParseCIDR(line)
-	if err != nil {
+	var prefix netip.Prefix
+	if strings.Contains(line, "/") {
+		p, err := netip.ParsePrefix(line)
+		if err != nil {
+			return
+		}
+		prefix = p.Masked()
+	} else {
 		// 尝试作为单 IP
-		ip := net.ParseIP(line)
-		if ip != nil {
-			val := ipToUint32(ip)
-			*list = append(*list, IPRange{Start: val, End: val})
+		addr, err := netip.ParseAddr(line)
+		if err != nil {
+			return
 		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	set.addPrefix(prefix)
+}
+
+// addPrefix 把前缀转换为区间加入规则集
+func (set *ruleSet) addPrefix(prefix netip.Prefix) {
+	// IPv4 映射地址（::ffff:a.b.c.d/bits）按 IPv4 处理
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+
+	start, end := prefix.Addr(), lastAddr(prefix)
+	if start.Is4() {
+		s4, e4 := start.As4(), end.As4()
+		set.ipRanges = append(set.ipRanges, IPRange{
+			Start: binary.BigEndian.Uint32(s4[:]),
+			End:   binary.BigEndian.Uint32(e4[:]),
+		})
 		return
 	}
-	start := ipToUint32(ipNet.IP)
-	mask := binary.BigEndian.Uint32(ipNet.Mask)
-	end := start | (^mask)
-	*list = append(*list, IPRange{Start: start, End: end})
+	set.ipRanges6 = append(set.ipRanges6, IPRange6{Start: start, End: end})
+}
+
+// lastAddr 返回前缀覆盖的最后一个地址
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
 }
 
 // IsCN 检查目标是否匹配 CN 规则 (域名优先，其次 IP)
 // host 可以是域名或 IP 字符串
 func (m *Manager) IsCN(host string, ip net.IP) bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	data := m.data.Load()
 
 	// 0. Check if it's a local network address - always treat as "CN" (local)
 	if m.isLocalNetwork(ip) {
 		return true
 	}
 
+	if data == nil {
+		return false
+	}
+
 	// 1. Domain matching
 	if ip == nil || (len(host) > 0 && host != ip.String()) {
 		// This is a domain
 		domain := strings.TrimSuffix(host, ".") // Remove trailing dot
 
 		// Exact match
-		if _, ok := m.domainExact[domain]; ok {
+		if _, ok := data.domainExact[domain]; ok {
 			return true
 		}
 
@@ -203,30 +424,43 @@ func (m *Manager) IsCN(host string, ip net.IP) bool {
 		parts := strings.Split(domain, ".")
 		for i := 0; i < len(parts); i++ {
 			suffix := strings.Join(parts[i:], ".")
-			if _, ok := m.domainSuffix[suffix]; ok {
+			if _, ok := data.domainSuffix[suffix]; ok {
 				return true
 			}
 		}
 	}
 
 	// 2. IP matching
-	if ip != nil {
-		ip4 := ip.To4()
-		if ip4 == nil {
-			return false // IPv6 not supported for direct connection rules, default proxy
-		}
-		val := ipToUint32(ip4)
-
-		idx := sort.Search(len(m.ipRanges), func(i int) bool {
-			return m.ipRanges[i].End >= val
-		})
+	return data.contains(ip)
+}
 
-		if idx < len(m.ipRanges) && m.ipRanges[idx].Start <= val {
-			return true
-		}
+// contains 判断 ip 是否落在集合中的某个区间
+func (d *ipSet) contains(ip net.IP) bool {
+	if ip == nil {
+		return false
 	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return d.containsIPv6(ip)
+	}
+	val := ipToUint32(ip4)
 
-	return false
+	idx := sort.Search(len(d.ipRanges), func(i int) bool {
+		return d.ipRanges[i].End >= val
+	})
+	return idx < len(d.ipRanges) && d.ipRanges[idx].Start <= val
+}
+
+// containsIPv6 在合并后的 IPv6 区间中二分查找
+func (d *ipSet) containsIPv6(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	idx := sort.Search(len(d.ipRanges6), func(i int) bool {
+		return d.ipRanges6[i].End.Compare(addr) >= 0
+	})
+	return idx < len(d.ipRanges6) && d.ipRanges6[idx].Start.Compare(addr) <= 0
 }
 
 func ipToUint32(ip net.IP) uint32 {
@@ -260,6 +494,32 @@ func mergeRanges(ranges []IPRange) []IPRange {
 	return result
 }
 
+func mergeRanges6(ranges []IPRange6) []IPRange6 {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start.Less(ranges[j].Start)
+	})
+	var result []IPRange6
+	current := ranges[0]
+	for i := 1; i < len(ranges); i++ {
+		next := ranges[i]
+		// 相交或相邻（End 的下一个地址即 next.Start）则合并；End 为最大地址时 Next 无效
+		adjacent := current.End.Next()
+		if next.Start.Compare(current.End) <= 0 || !adjacent.IsValid() || adjacent == next.Start {
+			if next.End.Compare(current.End) > 0 {
+				current.End = next.End
+			}
+		} else {
+			result = append(result, current)
+			current = next
+		}
+	}
+	result = append(result, current)
+	return result
+}
+
 func (m *Manager) isLocalNetwork(ip net.IP) bool {
 	if ip == nil {
 		return false
@@ -267,8 +527,8 @@ func (m *Manager) isLocalNetwork(ip net.IP) bool {
 
 	ip4 := ip.To4()
 	if ip4 == nil {
-		// For IPv6, check if it's loopback or link-local
-		return ip.IsLoopback() || ip.IsLinkLocalUnicast()
+		// For IPv6, check if it's loopback, link-local or unique local (fc00::/7)
+		return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsPrivate()
 	}
 
 	val := ipToUint32(ip4)
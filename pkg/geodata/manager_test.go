@@ -0,0 +1,175 @@
+package geodata
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// loadRules loads lines through the public path: a local file:// source read by LoadCached.
+func loadRules(t *testing.T, lines ...string) *Manager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.list")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatalf("write rules: %v", err)
+	}
+	m := NewManager(Options{URLs: []string{"file://" + path}})
+	m.LoadCached()
+	return m
+}
+
+func TestIPv6RangesParsedAndMerged(t *testing.T) {
+	m := loadRules(t,
+		"IP-CIDR6,2001:db8::/33,no-resolve",
+		"IP-CIDR6,2001:db8:8000::/33", // adjacent: merges into 2001:db8::/32
+		"IP-CIDR,2400:3200::/32",      // IPv6 under the generic type
+		"2400:3200:1::/48",            // contained in the previous range
+		"240e::/18",                   // bare CIDR line
+		"IP-CIDR,1.0.1.0/24",
+		"IP-CIDR,::ffff:1.0.8.0/120", // IPv4-mapped form is stored as IPv4
+		"IP-CIDR6,ffff:ffff:ffff:ffff:ffff:ffff:ffff:fff0/124",
+		"IP-CIDR6,ffff:ffff:ffff:ffff:ffff:ffff:ffff:ff00/120",
+	)
+
+	data := m.data.Load()
+	if len(data.ipRanges6) != 4 {
+		t.Fatalf("expected 4 merged IPv6 ranges, got %d: %v", len(data.ipRanges6), data.ipRanges6)
+	}
+	if len(data.ipRanges) != 2 {
+		t.Fatalf("expected 2 IPv4 ranges, got %d", len(data.ipRanges))
+	}
+
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"2001:db8::1", true},
+		{"2001:db8:ffff:ffff::1", true},
+		{"2001:db9::1", false},
+		{"2400:3200:1::53", true},
+		{"2400:3201::1", false},
+		{"240e:3fff::1", true},
+		{"240e:4000::1", false},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", true},
+		{"2606:4700::1111", false},
+		{"1.0.1.77", true},
+		{"1.0.8.9", true},
+		{"::ffff:1.0.1.5", true},
+		{"8.8.8.8", false},
+	}
+	for _, tc := range cases {
+		if got := m.IsCN(tc.ip, net.ParseIP(tc.ip)); got != tc.want {
+			t.Fatalf("IsCN(%s) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestIPv6LocalNetwork(t *testing.T) {
+	m := loadRules(t)
+	for _, ip := range []string{"::1", "fe80::1", "fd12:3456::1", "192.168.1.1"} {
+		if !m.IsCN(ip, net.ParseIP(ip)) {
+			t.Fatalf("%s should be treated as local", ip)
+		}
+	}
+	if m.IsCN("2001:4860::8888", net.ParseIP("2001:4860::8888")) {
+		t.Fatalf("public IPv6 must not be local")
+	}
+}
+
+func TestFailedSourceKeepsPreviousRules(t *testing.T) {
+	var broken atomic.Bool
+	var version atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.list":
+			if broken.Load() {
+				http.Error(w, "down", http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("DOMAIN,a.example\n"))
+		case "/b.list":
+			if version.Load() == 0 {
+				w.Write([]byte("DOMAIN,b1.example\n1.0.1.0/24\n"))
+			} else {
+				w.Write([]byte("DOMAIN,b2.example\n1.0.1.0/24\n240e::/18\n"))
+			}
+		}
+	}))
+	defer srv.Close()
+
+	m := NewManager(Options{URLs: []string{srv.URL + "/a.list", srv.URL + "/b.list"}})
+	m.cacheDir = "" // exercise the in-memory fallback only
+	m.Update()
+	before := m.data.Load()
+	if !m.IsCN("a.example", nil) || !m.IsCN("b1.example", nil) {
+		t.Fatalf("initial rules missing")
+	}
+
+	broken.Store(true)
+	version.Store(1)
+	m.Update()
+	after := m.data.Load()
+	if after == before {
+		t.Fatalf("refresh should swap in a new snapshot")
+	}
+	if !m.IsCN("a.example", nil) {
+		t.Fatalf("failed source dropped its previous rules")
+	}
+	if m.IsCN("b1.example", nil) || !m.IsCN("b2.example", nil) {
+		t.Fatalf("healthy source was not refreshed")
+	}
+
+	if got, want := diffRules(before, after), "Domains +1/-1, Suffixes +0/-0, IP Ranges +0/-0, IPv6 Ranges +1/-0"; got != want {
+		t.Fatalf("diff summary = %q, want %q", got, want)
+	}
+}
+
+func TestManagersAreIndependent(t *testing.T) {
+	dir := t.TempDir()
+	pathA := filepath.Join(dir, "a.list")
+	pathB := filepath.Join(dir, "b.list")
+	os.WriteFile(pathA, []byte("DOMAIN,a.example\n"), 0o644)
+	os.WriteFile(pathB, []byte("DOMAIN,b.example\n"), 0o644)
+
+	a := NewManager(Options{URLs: []string{pathA}, UpdateInterval: 20 * time.Millisecond})
+	b := NewManager(Options{URLs: []string{pathB}})
+	if a.IsCN("a.example", nil) {
+		t.Fatalf("rules must not load before Start")
+	}
+	a.Start()
+	b.Start()
+	defer b.Close()
+
+	// Start loads local sources synchronously.
+	if !a.IsCN("a.example", nil) || a.IsCN("b.example", nil) {
+		t.Fatalf("manager a has the wrong rules")
+	}
+	if !b.IsCN("b.example", nil) || b.IsCN("a.example", nil) {
+		t.Fatalf("manager b has the wrong rules")
+	}
+
+	// The background refresh picks up changes on its interval.
+	os.WriteFile(pathA, []byte("DOMAIN,a2.example\n"), 0o644)
+	deadline := time.Now().Add(2 * time.Second)
+	for !a.IsCN("a2.example", nil) {
+		if time.Now().After(deadline) {
+			t.Fatalf("periodic refresh did not apply the new rules")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// After Close no further refreshes happen, but loaded rules stay queryable.
+	a.Close()
+	os.WriteFile(pathA, []byte("DOMAIN,a3.example\n"), 0o644)
+	time.Sleep(100 * time.Millisecond)
+	if a.IsCN("a3.example", nil) || !a.IsCN("a2.example", nil) {
+		t.Fatalf("closed manager kept refreshing or lost its rules")
+	}
+	a.Close() // idempotent
+}
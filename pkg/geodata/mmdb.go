@@ -0,0 +1,309 @@
+package geodata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+)
+
+// mmdbMetadataMarker 标记 MaxMind DB 元数据段的起始位置
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// MMDB 是 MaxMind DB（GeoIP2 / GeoLite2 Country 等 .mmdb 文件）的只读解析器，只实现按 IP 查询国家代码所需的部分
+type MMDB struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	treeSize   uint
+	ipv4Start  uint // IPv6 树中 ::/96 对应的节点，IPv4 查询从这里开始
+	data       []byte
+}
+
+// IsMMDB 判断内容是否为 MaxMind DB 格式
+func IsMMDB(data []byte) bool {
+	return bytes.LastIndex(data, mmdbMetadataMarker) >= 0
+}
+
+// ParseMMDB 解析 .mmdb 文件内容；data 在 MMDB 的生命周期内不得修改
+func ParseMMDB(data []byte) (*MMDB, error) {
+	idx := bytes.LastIndex(data, mmdbMetadataMarker)
+	if idx < 0 {
+		return nil, errors.New("mmdb: metadata marker not found")
+	}
+	meta, _, err := decodeMMDB(data[idx+len(mmdbMetadataMarker):], 0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: metadata: %w", err)
+	}
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return nil, errors.New("mmdb: metadata is not a map")
+	}
+
+	db := &MMDB{buf: data}
+	db.nodeCount = uint(toUint(m["node_count"]))
+	db.recordSize = uint(toUint(m["record_size"]))
+	db.ipVersion = uint(toUint(m["ip_version"]))
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", db.recordSize)
+	}
+	db.treeSize = db.nodeCount * db.recordSize / 4
+	if db.nodeCount == 0 || db.treeSize+16 > uint(idx) {
+		return nil, errors.New("mmdb: corrupt search tree")
+	}
+	db.data = data[db.treeSize+16 : idx]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.readRecord(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// Country 返回 ip 所属国家/地区的 ISO 代码（大写），未收录时返回空字符串
+func (db *MMDB) Country(ip net.IP) string {
+	record, err := db.lookup(ip)
+	if err != nil || record == nil {
+		return ""
+	}
+	m, ok := record.(map[string]any)
+	if !ok {
+		return ""
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := m[key].(map[string]any); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				return strings.ToUpper(code)
+			}
+		}
+	}
+	return ""
+}
+
+func (db *MMDB) lookup(ip net.IP) (any, error) {
+	var addr []byte
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		addr = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else {
+		if db.ipVersion != 6 {
+			return nil, nil
+		}
+		addr = ip.To16()
+		if addr == nil {
+			return nil, nil
+		}
+	}
+
+	for i := 0; i < len(addr)*8 && node < db.nodeCount; i++ {
+		bit := (addr[i/8] >> (7 - uint(i%8))) & 1
+		node = db.readRecord(node, uint(bit))
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("mmdb: lookup ended inside the tree")
+	}
+	offset := node - db.nodeCount - 16
+	if offset >= uint(len(db.data)) {
+		return nil, errors.New("mmdb: data pointer out of range")
+	}
+	value, _, err := decodeMMDB(db.data, offset)
+	return value, err
+}
+
+func (db *MMDB) readRecord(node, side uint) uint {
+	switch db.recordSize {
+	case 24:
+		off := node*6 + side*3
+		b := db.buf[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.buf[node*7 : node*7+7]
+		if side == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + side*4
+		return uint(binary.BigEndian.Uint32(db.buf[off : off+4]))
+	}
+}
+
+// mmdbMaxDepth 限制 map/array 嵌套与指针跳转的深度，防止畸形文件造成无限递归
+const mmdbMaxDepth = 32
+
+// decodeMMDB 解码 data 中 offset 处的一个值，返回值与其后的位置；指针相对于 data 起始
+func decodeMMDB(data []byte, offset uint) (any, uint, error) {
+	return decodeMMDBValue(data, offset, 0)
+}
+
+func decodeMMDBValue(data []byte, offset uint, depth int) (any, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	if offset >= uint(len(data)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	ctrl := data[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == 1 { // pointer
+		size := uint(ctrl>>3) & 0x3
+		need := size + 1
+		if offset+need > uint(len(data)) {
+			return nil, 0, errors.New("truncated pointer")
+		}
+		b := data[offset : offset+need]
+		var ptr uint
+		switch size {
+		case 0:
+			ptr = uint(ctrl&0x7)<<8 | uint(b[0])
+		case 1:
+			ptr = (uint(ctrl&0x7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			ptr = (uint(ctrl&0x7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			ptr = uint(binary.BigEndian.Uint32(b))
+		}
+		// 规范不允许指针指向另一个指针
+		if ptr < uint(len(data)) && data[ptr]>>5 == 1 {
+			return nil, 0, errors.New("pointer to pointer")
+		}
+		value, _, err := decodeMMDBValue(data, ptr, depth+1)
+		return value, offset + need, err
+	}
+
+	if typ == 0 { // extended type
+		if offset >= uint(len(data)) {
+			return nil, 0, errors.New("truncated extended type")
+		}
+		typ = 7 + uint(data[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(data)) {
+			return nil, 0, errors.New("truncated size")
+		}
+		var extra uint
+		for _, c := range data[offset : offset+n] {
+			extra = extra<<8 | uint(c)
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	// size 来自文件，每个元素至少占 1 字节，容量预分配不超过剩余数据长度
+	hint := size
+	if remain := uint(len(data)) - offset; hint > remain {
+		hint = remain
+	}
+
+	switch typ {
+	case 7: // map
+		m := make(map[string]any, hint)
+		for i := uint(0); i < size; i++ {
+			key, next, err := decodeMMDBValue(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := decodeMMDBValue(data, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, _ := key.(string)
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case 11: // array
+		arr := make([]any, 0, hint)
+		for i := uint(0); i < size; i++ {
+			value, next, err := decodeMMDBValue(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, value)
+			offset = next
+		}
+		return arr, offset, nil
+	case 14: // boolean
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, errors.New("truncated value")
+	}
+	b := data[offset : offset+size]
+	offset += size
+	switch typ {
+	case 2: // utf-8 string
+		return string(b), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4: // bytes
+		return append([]byte(nil), b...), offset, nil
+	case 5, 6, 9, 10: // uint16 / uint32 / uint64 / uint128（超过 64 位时截断）
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset, nil
+	case 8: // int32
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		if size == 4 {
+			return int64(int32(v)), offset, nil
+		}
+		return int64(v), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 12, 13: // data cache container / end marker
+		return nil, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+func toUint(v any) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,205 @@
+package geodata
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sourceFetcher 读取规则源：本地文件直接读取，远程源经磁盘缓存与重新验证
+type sourceFetcher struct {
+	cacheDir string // 远程规则的本地缓存目录，为空则不缓存
+	client   *http.Client
+}
+
+func newSourceFetcher(cacheDir string) *sourceFetcher {
+	if cacheDir == "" {
+		cacheDir = defaultCacheDir()
+	}
+	return &sourceFetcher{
+		cacheDir: cacheDir,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// ReadSource 读取 source（本地路径、file:// 或 http(s) URL）的内容；远程内容缓存于 cacheDir
+// （为空时使用默认缓存目录），下载失败时回退到缓存。validate 非空时，下载的内容须通过校验才会写入缓存并返回
+func ReadSource(source, cacheDir string, validate func([]byte) error) ([]byte, error) {
+	return newSourceFetcher(cacheDir).fetch(context.Background(), source, false, validate)
+}
+
+// cacheMeta 记录缓存内容对应的校验信息，用于 ETag / If-Modified-Since 重新验证
+type cacheMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sudoku", "rules")
+}
+
+// isRemote 判断规则源是否需要通过 HTTP 获取；file:// 与普通路径按本地文件读取
+func isRemote(source string) bool {
+	lower := strings.ToLower(source)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+func localPath(source string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(source), "file://") {
+		return source, nil
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", err
+	}
+	path := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		// file://relative/path 视为相对路径
+		path = u.Host + path
+	}
+	if path == "" {
+		return "", fmt.Errorf("empty file path in %q", source)
+	}
+	return path, nil
+}
+
+// fetch 返回规则源内容。本地文件直接读取；远程源在 offline 时只读缓存，
+// 否则携带缓存的校验信息请求，304 时使用缓存，请求失败或内容未通过 validate 时同样回退到缓存
+func (f *sourceFetcher) fetch(ctx context.Context, source string, offline bool, validate func([]byte) error) ([]byte, error) {
+	if !isRemote(source) {
+		path, err := localPath(source)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(path)
+	}
+
+	cached, meta, cacheErr := f.readCache(source)
+	if offline {
+		return cached, cacheErr
+	}
+
+	body, err := f.download(ctx, source, cached, meta, validate)
+	if err != nil {
+		if cacheErr == nil {
+			return cached, nil
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+func (f *sourceFetcher) download(ctx context.Context, source string, cached []byte, meta *cacheMeta, validate func([]byte) error) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && meta != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// 强制门户或错误页也可能以 200 返回：解析通过后才覆盖缓存
+	if validate != nil {
+		if err := validate(body); err != nil {
+			return nil, fmt.Errorf("invalid content: %w", err)
+		}
+	}
+	f.writeCache(source, body, &cacheMeta{
+		URL:          source,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	})
+	return body, nil
+}
+
+func (f *sourceFetcher) cachePaths(source string) (string, string) {
+	sum := sha256.Sum256([]byte(source))
+	base := filepath.Join(f.cacheDir, hex.EncodeToString(sum[:8]))
+	return base + ".rules", base + ".json"
+}
+
+func (f *sourceFetcher) readCache(source string) ([]byte, *cacheMeta, error) {
+	if f.cacheDir == "" {
+		return nil, nil, fmt.Errorf("no cache directory")
+	}
+	dataPath, metaPath := f.cachePaths(source)
+	body, err := os.ReadFile(dataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := &cacheMeta{}
+	if raw, err := os.ReadFile(metaPath); err == nil {
+		if json.Unmarshal(raw, meta) != nil || meta.URL != source {
+			meta = nil
+		}
+	}
+	return body, meta, nil
+}
+
+// writeCache 先写临时文件再重命名，避免中途退出留下半截缓存
+func (f *sourceFetcher) writeCache(source string, body []byte, meta *cacheMeta) {
+	if f.cacheDir == "" {
+		return
+	}
+	if err := os.MkdirAll(f.cacheDir, 0o755); err != nil {
+		return
+	}
+	dataPath, metaPath := f.cachePaths(source)
+	rawMeta, _ := json.Marshal(meta)
+	if writeFileAtomic(dataPath, body) == nil {
+		writeFileAtomic(metaPath, rawMeta)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
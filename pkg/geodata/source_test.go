@@ -0,0 +1,171 @@
+package geodata
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLocalRuleSources(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "cn.list")
+	yamlPath := filepath.Join(dir, "cn.yaml")
+	os.WriteFile(plain, []byte("DOMAIN-SUFFIX,baidu.com\n1.0.1.0/24\n"), 0o644)
+	os.WriteFile(yamlPath, []byte("payload:\n  - DOMAIN,qq.com\n  - IP-CIDR6,240e::/18\n"), 0o644)
+
+	m := NewManager(Options{URLs: []string{plain, "file://" + yamlPath}})
+	m.LoadCached()
+
+	if !m.IsCN("www.baidu.com", nil) || !m.IsCN("qq.com", nil) {
+		t.Fatalf("domain rules from local files not loaded")
+	}
+	if !m.IsCN("1.0.1.1", net.ParseIP("1.0.1.1")) || !m.IsCN("240e::1", net.ParseIP("240e::1")) {
+		t.Fatalf("ip rules from local files not loaded")
+	}
+}
+
+func TestRemoteRuleCacheRevalidation(t *testing.T) {
+	var hits, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("DOMAIN-SUFFIX,example.cn\n"))
+	}))
+	url := srv.URL + "/cn.list"
+	cacheDir := t.TempDir()
+
+	// First run: nothing cached yet, the network refresh fills the cache.
+	m := NewManager(Options{URLs: []string{url}, CacheDir: cacheDir})
+	m.LoadCached()
+	if m.IsCN("www.example.cn", nil) {
+		t.Fatalf("no rules should exist before the first download")
+	}
+	m.Update()
+	if !m.IsCN("www.example.cn", nil) {
+		t.Fatalf("downloaded rules not applied")
+	}
+
+	// Second run: rules come from the cache immediately, then revalidate with a 304.
+	m2 := NewManager(Options{URLs: []string{url}, CacheDir: cacheDir})
+	m2.LoadCached()
+	if !m2.IsCN("www.example.cn", nil) {
+		t.Fatalf("cached rules not loaded at startup")
+	}
+	m2.Update()
+	if notModified.Load() != 1 || !m2.IsCN("www.example.cn", nil) {
+		t.Fatalf("expected one 304 revalidation keeping the rules, got %d", notModified.Load())
+	}
+
+	// Offline: the download fails and the cached copy is used.
+	srv.Close()
+	m3 := NewManager(Options{URLs: []string{url}, CacheDir: cacheDir})
+	m3.Update()
+	if !m3.IsCN("www.example.cn", nil) {
+		t.Fatalf("cache fallback failed when the source is unreachable")
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("unexpected number of requests: %d", hits.Load())
+	}
+}
+
+func TestRemoteRuleCacheKeepsGoodCopyOnGarbage(t *testing.T) {
+	var portal atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if portal.Load() {
+			// 强制门户：以 200 返回登录页
+			w.Write([]byte("<html><body>Please log in to the Wi-Fi</body></html>\n"))
+			return
+		}
+		w.Write([]byte("DOMAIN-SUFFIX,example.cn\n"))
+	}))
+	defer srv.Close()
+	url := srv.URL + "/cn.list"
+	cacheDir := t.TempDir()
+
+	m := NewManager(Options{URLs: []string{url}, CacheDir: cacheDir})
+	m.Update()
+	if !m.IsCN("www.example.cn", nil) {
+		t.Fatalf("downloaded rules not applied")
+	}
+
+	portal.Store(true)
+	m.Update()
+	if !m.IsCN("www.example.cn", nil) {
+		t.Fatalf("portal page replaced the rules")
+	}
+
+	// 下次离线启动仍从完好的缓存加载
+	m2 := NewManager(Options{URLs: []string{url}, CacheDir: cacheDir})
+	m2.LoadCached()
+	if !m2.IsCN("www.example.cn", nil) {
+		t.Fatalf("portal page overwrote the cache")
+	}
+}
+
+func TestDatasetStartsFromCacheAndRefreshesInBackground(t *testing.T) {
+	var body atomic.Value
+	body.Store("v1")
+	release := make(chan struct{})
+	var block atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if block.Load() {
+			<-release
+		}
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer srv.Close()
+	url := srv.URL + "/geoip.dat"
+	cacheDir := t.TempDir()
+
+	var current atomic.Value
+	parse := func(b []byte) error {
+		current.Store(string(b))
+		return nil
+	}
+
+	// First run: nothing cached, Start has to download.
+	ds := NewDataset(url, cacheDir, time.Hour, parse)
+	if err := ds.Start(); err != nil {
+		t.Fatalf("first start failed: %v", err)
+	}
+	ds.Close()
+	if current.Load() != "v1" {
+		t.Fatalf("downloaded data not applied: %v", current.Load())
+	}
+
+	// Second run: the cached copy is used without waiting for the network,
+	// and the newer content replaces it once the background refresh completes.
+	body.Store("v2")
+	block.Store(true)
+	ds = NewDataset(url, cacheDir, time.Hour, parse)
+	defer ds.Close()
+	done := make(chan error, 1)
+	go func() { done <- ds.Start() }()
+	select {
+	case err := <-done:
+		if err != nil || current.Load() != "v1" {
+			t.Fatalf("start from cache: err=%v data=%v", err, current.Load())
+		}
+	case <-time.After(2 * time.Second):
+		close(release)
+		t.Fatalf("Start blocked on the network despite a usable cache")
+	}
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for current.Load() != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatalf("background refresh did not apply new data: %v", current.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,334 @@
+package geodata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// v2ray geoip.dat / geosite.dat 是 protobuf 编码的列表：
+//
+//	GeoIPList   { repeated GeoIP entry = 1 }
+//	GeoIP       { string country_code = 1; repeated CIDR cidr = 2; bool reverse_match = 3 }
+//	CIDR        { bytes ip = 1; uint32 prefix = 2 }
+//	GeoSiteList { repeated GeoSite entry = 1 }
+//	GeoSite     { string country_code = 1; repeated Domain domain = 2 }
+//	Domain      { Type type = 1; string value = 2; repeated Attribute attribute = 3 }
+//	Attribute   { string key = 1; oneof { bool bool_value = 2; int64 int_value = 3 } }
+//
+// 这里只实现解析这些消息所需的最小 protobuf 解码。
+
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+// Domain.Type
+const (
+	siteKeyword = 0 // Plain：子串匹配
+	siteRegex   = 1
+	siteSuffix  = 2 // Domain：域名及其子域名
+	siteFull    = 3
+)
+
+var errTruncated = errors.New("protobuf: truncated message")
+
+// protoField 迭代消息中的字段；对 wireBytes 返回内容，对 varint 返回数值
+func protoField(b []byte) (num int, wire int, value uint64, content []byte, rest []byte, err error) {
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, 0, nil, nil, errTruncated
+	}
+	b = b[n:]
+	num, wire = int(tag>>3), int(tag&7)
+	switch wire {
+	case wireVarint:
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		return num, wire, v, nil, b[n:], nil
+	case wire64:
+		if len(b) < 8 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		return num, wire, binary.LittleEndian.Uint64(b), nil, b[8:], nil
+	case wire32:
+		if len(b) < 4 {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		return num, wire, uint64(binary.LittleEndian.Uint32(b)), nil, b[4:], nil
+	case wireBytes:
+		l, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < l {
+			return 0, 0, 0, nil, nil, errTruncated
+		}
+		return num, wire, 0, b[n : n+int(l)], b[n+int(l):], nil
+	}
+	return 0, 0, 0, nil, nil, fmt.Errorf("protobuf: unsupported wire type %d", wire)
+}
+
+// forEachEntry 遍历列表消息中的 entry（字段 1），只对 country_code 在 want 中的条目调用 fn；want 为空表示全部
+func forEachEntry(data []byte, want map[string]struct{}, fn func(code string, entry []byte) error) error {
+	for len(data) > 0 {
+		num, wire, _, entry, rest, err := protoField(data)
+		if err != nil {
+			return err
+		}
+		data = rest
+		if num != 1 || wire != wireBytes {
+			continue
+		}
+		code, err := entryCode(entry)
+		if err != nil {
+			return err
+		}
+		if want != nil {
+			if _, ok := want[code]; !ok {
+				continue
+			}
+		}
+		if err := fn(code, entry); err != nil {
+			return fmt.Errorf("%s: %w", code, err)
+		}
+	}
+	return nil
+}
+
+func entryCode(entry []byte) (string, error) {
+	for len(entry) > 0 {
+		num, wire, _, content, rest, err := protoField(entry)
+		if err != nil {
+			return "", err
+		}
+		if num == 1 && wire == wireBytes {
+			return strings.ToUpper(string(content)), nil
+		}
+		entry = rest
+	}
+	return "", nil
+}
+
+func codeSet(codes []string) map[string]struct{} {
+	if len(codes) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(codes))
+	for _, c := range codes {
+		set[strings.ToUpper(c)] = struct{}{}
+	}
+	return set
+}
+
+// parseGeoIPDat 解析 geoip.dat，只保留 codes 中的条目（为空则全部）
+func parseGeoIPDat(data []byte, codes []string) (map[string]*ipSet, error) {
+	sets := make(map[string]*ipSet)
+	err := forEachEntry(data, codeSet(codes), func(code string, entry []byte) error {
+		acc := newRuleSet()
+		reverse := false
+		for len(entry) > 0 {
+			num, wire, value, content, rest, err := protoField(entry)
+			if err != nil {
+				return err
+			}
+			entry = rest
+			switch {
+			case num == 2 && wire == wireBytes:
+				prefix, err := parseCIDRMessage(content)
+				if err != nil {
+					return err
+				}
+				acc.addPrefix(prefix)
+			case num == 3 && wire == wireVarint:
+				reverse = value != 0
+			}
+		}
+		if reverse {
+			return errors.New("reverse_match entries are not supported")
+		}
+		set := &ipSet{ipRanges: mergeRanges(acc.ipRanges), ipRanges6: mergeRanges6(acc.ipRanges6)}
+		if prev, ok := sets[code]; ok {
+			set = &ipSet{
+				ipRanges:  mergeRanges(append(prev.ipRanges, set.ipRanges...)),
+				ipRanges6: mergeRanges6(append(prev.ipRanges6, set.ipRanges6...)),
+			}
+		}
+		sets[code] = set
+		return nil
+	})
+	return sets, err
+}
+
+func parseCIDRMessage(b []byte) (netip.Prefix, error) {
+	var ip []byte
+	var bits uint64
+	for len(b) > 0 {
+		num, wire, value, content, rest, err := protoField(b)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		b = rest
+		switch {
+		case num == 1 && wire == wireBytes:
+			ip = content
+		case num == 2 && wire == wireVarint:
+			bits = value
+		}
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok || bits > uint64(addr.BitLen()) {
+		return netip.Prefix{}, fmt.Errorf("invalid cidr %x/%d", ip, bits)
+	}
+	return netip.PrefixFrom(addr, int(bits)).Masked(), nil
+}
+
+// siteMatcher 匹配一个 geosite 分类中的域名
+type siteMatcher struct {
+	full     map[string]struct{}
+	suffix   map[string]struct{}
+	keywords []string
+	regexps  []*regexp.Regexp
+}
+
+func (s *siteMatcher) match(domain string) bool {
+	if _, ok := s.full[domain]; ok {
+		return true
+	}
+	for d := domain; ; {
+		if _, ok := s.suffix[d]; ok {
+			return true
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+	for _, k := range s.keywords {
+		if strings.Contains(domain, k) {
+			return true
+		}
+	}
+	for _, re := range s.regexps {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGeoSiteDat 解析 geosite.dat。codes 中的每一项可以带属性过滤，如 "category-ads-all@ads"，
+// 返回的 map 以请求时的写法（大写）为键
+func parseGeoSiteDat(data []byte, codes []string) (map[string]*siteMatcher, error) {
+	attrsByCode := make(map[string][]string) // 分类 -> 请求的属性过滤（"" 表示不过滤）
+	var want map[string]struct{}
+	if len(codes) > 0 {
+		want = make(map[string]struct{}, len(codes))
+	}
+	for _, c := range codes {
+		code, attr, _ := strings.Cut(strings.ToUpper(c), "@")
+		attrsByCode[code] = append(attrsByCode[code], strings.ToLower(attr))
+		want[code] = struct{}{}
+	}
+
+	sites := make(map[string]*siteMatcher)
+	err := forEachEntry(data, want, func(code string, entry []byte) error {
+		attrs, ok := attrsByCode[code]
+		if !ok {
+			attrs = []string{""}
+		}
+		matchers := make(map[string]*siteMatcher, len(attrs))
+		for _, attr := range attrs {
+			key := code
+			if attr != "" {
+				key += "@" + strings.ToUpper(attr)
+			}
+			m, ok := sites[key]
+			if !ok {
+				m = &siteMatcher{full: make(map[string]struct{}), suffix: make(map[string]struct{})}
+				sites[key] = m
+			}
+			matchers[attr] = m
+		}
+
+		for len(entry) > 0 {
+			num, wire, _, content, rest, err := protoField(entry)
+			if err != nil {
+				return err
+			}
+			entry = rest
+			if num != 2 || wire != wireBytes {
+				continue
+			}
+			typ, value, domainAttrs, err := parseDomainMessage(content)
+			if err != nil {
+				return err
+			}
+			for attr, m := range matchers {
+				if attr != "" && !containsString(domainAttrs, attr) {
+					continue
+				}
+				if err := m.add(typ, value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return sites, err
+}
+
+func (s *siteMatcher) add(typ uint64, value string) error {
+	switch typ {
+	case siteKeyword:
+		s.keywords = append(s.keywords, strings.ToLower(value))
+	case siteRegex:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return err
+		}
+		s.regexps = append(s.regexps, re)
+	case siteSuffix:
+		s.suffix[strings.ToLower(value)] = struct{}{}
+	case siteFull:
+		s.full[strings.ToLower(value)] = struct{}{}
+	}
+	return nil
+}
+
+func parseDomainMessage(b []byte) (typ uint64, value string, attrs []string, err error) {
+	for len(b) > 0 {
+		num, wire, v, content, rest, ferr := protoField(b)
+		if ferr != nil {
+			return 0, "", nil, ferr
+		}
+		b = rest
+		switch {
+		case num == 1 && wire == wireVarint:
+			typ = v
+		case num == 2 && wire == wireBytes:
+			value = string(content)
+		case num == 3 && wire == wireBytes:
+			key, kerr := entryCode(content)
+			if kerr != nil {
+				return 0, "", nil, kerr
+			}
+			attrs = append(attrs, strings.ToLower(key))
+		}
+	}
+	return typ, value, attrs, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
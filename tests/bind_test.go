@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/protocol"
+)
+
+func TestSocks5BindThroughTunnel(t *testing.T) {
+	ports, _ := getFreePorts(2)
+	serverPort, clientPort := ports[0], ports[1]
+
+	startSudokuServer(&config.Config{
+		Mode:               "server",
+		LocalPort:          serverPort,
+		Key:                "bindkey",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		FallbackAddr:       "127.0.0.1:80",
+		AllowBind:          true,
+	})
+	startSudokuClient(&config.Config{
+		Mode:               "client",
+		LocalPort:          clientPort,
+		ServerAddress:      fmt.Sprintf("127.0.0.1:%d", serverPort),
+		Key:                "bindkey",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		ProxyMode:          "global",
+	})
+
+	ctrl, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", clientPort))
+	if err != nil {
+		t.Fatalf("dial client: %v", err)
+	}
+	defer ctrl.Close()
+	ctrl.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// 期望的对端为 127.0.0.1，其他来源的连接会被服务端拒绝
+	req := []byte{0x05, 0x01, 0x00, 0x05, 0x02, 0x00, 0x01, 127, 0, 0, 1, 0, 0}
+	if _, err := ctrl.Write(req); err != nil {
+		t.Fatalf("write bind request: %v", err)
+	}
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(ctrl, method); err != nil || method[1] != 0x00 {
+		t.Fatalf("method negotiation failed: %x %v", method, err)
+	}
+
+	readReply := func() string {
+		t.Helper()
+		head := make([]byte, 3)
+		if _, err := io.ReadFull(ctrl, head); err != nil {
+			t.Fatalf("read bind reply: %v", err)
+		}
+		if head[1] != 0x00 {
+			t.Fatalf("bind failed with REP=%#x", head[1])
+		}
+		addr, _, _, err := protocol.ReadAddress(ctrl)
+		if err != nil {
+			t.Fatalf("read bind address: %v", err)
+		}
+		return addr
+	}
+
+	bound := readReply()
+	_, port, _ := net.SplitHostPort(bound)
+	peer, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		t.Fatalf("connect to bound address %s: %v", bound, err)
+	}
+	defer peer.Close()
+
+	if accepted := readReply(); accepted != peer.LocalAddr().String() {
+		t.Fatalf("second reply = %s, want %s", accepted, peer.LocalAddr())
+	}
+
+	if _, err := peer.Write([]byte("from-peer")); err != nil {
+		t.Fatalf("peer write: %v", err)
+	}
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(ctrl, buf); err != nil || string(buf) != "from-peer" {
+		t.Fatalf("client read %q, %v", buf, err)
+	}
+	if _, err := ctrl.Write([]byte("to-peer")); err != nil {
+		t.Fatalf("client write: %v", err)
+	}
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf = make([]byte, 7)
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "to-peer" {
+		t.Fatalf("peer read %q, %v", buf, err)
+	}
+}
+
+func TestSocks5BindDisabledByDefault(t *testing.T) {
+	ports, _ := getFreePorts(2)
+	serverPort, clientPort := ports[0], ports[1]
+
+	startSudokuServer(&config.Config{
+		Mode:               "server",
+		LocalPort:          serverPort,
+		Key:                "bindkey",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		FallbackAddr:       "127.0.0.1:80",
+	})
+	startSudokuClient(&config.Config{
+		Mode:               "client",
+		LocalPort:          clientPort,
+		ServerAddress:      fmt.Sprintf("127.0.0.1:%d", serverPort),
+		Key:                "bindkey",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		ProxyMode:          "global",
+	})
+
+	ctrl, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", clientPort))
+	if err != nil {
+		t.Fatalf("dial client: %v", err)
+	}
+	defer ctrl.Close()
+	ctrl.SetDeadline(time.Now().Add(10 * time.Second))
+
+	req := []byte{0x05, 0x01, 0x00, 0x05, 0x02, 0x00, 0x01, 127, 0, 0, 1, 0, 0}
+	if _, err := ctrl.Write(req); err != nil {
+		t.Fatalf("write bind request: %v", err)
+	}
+	resp := make([]byte, 5)
+	if _, err := io.ReadFull(ctrl, resp); err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if resp[3] == 0x00 {
+		t.Fatalf("server without allow_bind accepted BIND")
+	}
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/saba-futai/sudoku/internal/config"
+	"github.com/saba-futai/sudoku/internal/tunnel"
+	"github.com/saba-futai/sudoku/pkg/obfs/sudoku"
+)
+
+func TestProbeThroughTunnel(t *testing.T) {
+	ports, _ := getFreePorts(2)
+	serverPort, decoyPort := ports[0], ports[1]
+	startEchoServer(decoyPort)
+
+	serverCfg := &config.Config{
+		Mode:               "server",
+		LocalPort:          serverPort,
+		Key:                "probe-key",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		FallbackAddr:       "127.0.0.1:80",
+	}
+	startSudokuServer(serverCfg)
+
+	clientCfg := &config.Config{
+		ServerAddress:      fmt.Sprintf("127.0.0.1:%d", serverPort),
+		Key:                "probe-key",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+	}
+	table := sudoku.NewTable(clientCfg.Key, clientCfg.ASCII)
+	d := &tunnel.BaseDialer{Config: clientCfg, Tables: []*sudoku.Table{table}}
+	if rtt, err := d.Probe(); err != nil || rtt <= 0 {
+		t.Fatalf("probe against a live server failed: %v", err)
+	}
+
+	// Something that accepts TCP but is not a Sudoku server must fail the probe.
+	decoyCfg := *clientCfg
+	decoyCfg.ServerAddress = fmt.Sprintf("127.0.0.1:%d", decoyPort)
+	d = &tunnel.BaseDialer{Config: &decoyCfg, Tables: []*sudoku.Table{table}}
+	if _, err := d.Probe(); err == nil {
+		t.Fatalf("probe against a non-Sudoku endpoint should fail")
+	}
+}
+
+func TestClientFailsOverToSecondServer(t *testing.T) {
+	ports, _ := getFreePorts(3)
+	deadPort, serverPort, clientPort := ports[0], ports[1], ports[2]
+	echoPort, _ := getFreePort()
+	startEchoServer(echoPort)
+
+	serverCfg := &config.Config{
+		Mode:               "server",
+		LocalPort:          serverPort,
+		Key:                "failover-key",
+		AEAD:               "aes-128-gcm",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		FallbackAddr:       "127.0.0.1:80",
+	}
+	startSudokuServer(serverCfg)
+
+	clientCfg := &config.Config{
+		Mode:      "client",
+		LocalPort: clientPort,
+		Servers: []string{
+			fmt.Sprintf("127.0.0.1:%d", deadPort),
+			fmt.Sprintf("127.0.0.1:%d", serverPort),
+		},
+		Key:                "failover-key",
+		AEAD:               "aes-128-gcm",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		ProxyMode:          "global",
+	}
+	startSudokuClient(clientCfg)
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", clientPort))
+		if err != nil {
+			t.Fatalf("dial client failed: %v", err)
+		}
+		sendHTTPConnect(t, conn, fmt.Sprintf("127.0.0.1:%d", echoPort))
+		conn.Write([]byte("failover"))
+		resp := make([]byte, 8)
+		if _, err := io.ReadFull(conn, resp); err != nil || string(resp) != "failover" {
+			t.Fatalf("echo through backup server failed: %q %v", resp, err)
+		}
+		conn.Close()
+	}
+}
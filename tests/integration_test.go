@@ -412,7 +412,7 @@ func startSudokuClient(cfg *config.Config) {
 	if err != nil {
 		panic(err)
 	}
-	go app.RunClient(cfg, []*sudoku.Table{table})
+	go app.RunClient(cfg, []*sudoku.Table{table}, nil)
 	time.Sleep(200 * time.Millisecond)
 	waitForPort(cfg.LocalPort)
 }
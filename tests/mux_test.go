@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/saba-futai/sudoku/internal/config"
+)
+
+// startCountingProxy forwards TCP to targetPort and counts accepted connections.
+func startCountingProxy(t *testing.T, targetPort int) (int, *atomic.Int32) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var count atomic.Int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			count.Add(1)
+			go func(c net.Conn) {
+				defer c.Close()
+				up, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", targetPort))
+				if err != nil {
+					return
+				}
+				defer up.Close()
+				go io.Copy(up, c)
+				io.Copy(c, up)
+			}(c)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, &count
+}
+
+func TestMuxSharesOneTunnel(t *testing.T) {
+	ports, _ := getFreePorts(2)
+	serverPort, clientPort := ports[0], ports[1]
+	echoPort, _ := getFreePort()
+	startEchoServer(echoPort)
+
+	udpConn, udpPort, err := startUDPEchoServer()
+	if err != nil {
+		t.Fatalf("failed to start udp echo: %v", err)
+	}
+	defer udpConn.Close()
+
+	serverCfg := &config.Config{
+		Mode:               "server",
+		LocalPort:          serverPort,
+		Key:                "mux-key",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: false,
+		FallbackAddr:       "127.0.0.1:80",
+	}
+	startSudokuServer(serverCfg)
+	proxyPort, upstreamConns := startCountingProxy(t, serverPort)
+
+	clientCfg := &config.Config{
+		Mode:               "client",
+		LocalPort:          clientPort,
+		ServerAddress:      fmt.Sprintf("127.0.0.1:%d", proxyPort),
+		Key:                "mux-key",
+		AEAD:               "chacha20-poly1305",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: false,
+		ProxyMode:          "global",
+		CounterNonce:       true,
+		Mux:                true,
+	}
+	startSudokuClient(clientCfg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", clientPort))
+			if err != nil {
+				t.Errorf("dial client %d failed: %v", id, err)
+				return
+			}
+			defer conn.Close()
+			sendHTTPConnect(t, conn, fmt.Sprintf("127.0.0.1:%d", echoPort))
+			msg := bytes.Repeat([]byte(fmt.Sprintf("mux-%d|", id)), 4000)
+			go conn.Write(msg)
+			resp := make([]byte, len(msg))
+			if _, err := io.ReadFull(conn, resp); err != nil || !bytes.Equal(resp, msg) {
+				t.Errorf("echo %d mismatch: %v", id, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// UDP associate rides the same tunnel.
+	ctrl, relay := performUDPAssociate(t, clientPort)
+	defer ctrl.Close()
+	relayConn, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		t.Fatalf("dial relay failed: %v", err)
+	}
+	defer relayConn.Close()
+	target := fmt.Sprintf("127.0.0.1:%d", udpPort)
+	relayConn.Write(buildSocksUDPRequest(t, target, []byte("udp over mux")))
+	buf := make([]byte, 256)
+	n, err := relayConn.Read(buf)
+	if err != nil {
+		t.Fatalf("udp read failed: %v", err)
+	}
+	if _, data := parseSocksUDPResponse(t, buf[:n]); string(data) != "udp over mux" {
+		t.Fatalf("unexpected udp payload %q", data)
+	}
+
+	if got := upstreamConns.Load(); got != 1 {
+		t.Fatalf("expected a single tunnel connection, got %d", got)
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/saba-futai/sudoku/internal/config"
+)
+
+func TestClientPoolPrehandshakes(t *testing.T) {
+	ports, _ := getFreePorts(2)
+	serverPort, clientPort := ports[0], ports[1]
+	echoPort, _ := getFreePort()
+	startEchoServer(echoPort)
+
+	serverCfg := &config.Config{
+		Mode:               "server",
+		LocalPort:          serverPort,
+		Key:                "pool-key",
+		AEAD:               "aes-128-gcm",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		FallbackAddr:       "127.0.0.1:80",
+	}
+	startSudokuServer(serverCfg)
+	proxyPort, upstreamConns := startCountingProxy(t, serverPort)
+
+	clientCfg := &config.Config{
+		Mode:               "client",
+		LocalPort:          clientPort,
+		ServerAddress:      fmt.Sprintf("127.0.0.1:%d", proxyPort),
+		Key:                "pool-key",
+		AEAD:               "aes-128-gcm",
+		ASCII:              "prefer_entropy",
+		EnablePureDownlink: true,
+		ProxyMode:          "global",
+		KeyExchange:        true,
+		PoolSize:           2,
+	}
+	startSudokuClient(clientCfg)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for upstreamConns.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := upstreamConns.Load(); got != 2 {
+		t.Fatalf("expected 2 warm tunnels before any request, got %d", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", clientPort))
+		if err != nil {
+			t.Fatalf("dial client failed: %v", err)
+		}
+		sendHTTPConnect(t, conn, fmt.Sprintf("127.0.0.1:%d", echoPort))
+		msg := []byte(fmt.Sprintf("pooled-%d", i))
+		conn.Write(msg)
+		resp := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, resp); err != nil || string(resp) != string(msg) {
+			t.Fatalf("echo via pooled tunnel failed: %q %v", resp, err)
+		}
+		conn.Close()
+	}
+}